@@ -31,13 +31,7 @@ type TurnstileState struct {
 func initStateMachine(ctx context.Context, state service.StateStore) error {
 	s := state.(*TurnstileState)
 	s.incoming = make(chan fsm.Event)
-	s.machine, s.errCh = fsm.New(uuid.New(), machineName, states.Locked(uuid.New()).
-		WithTransitions(
-			fsm.Transition{
-				Checks: []fsm.CheckFn{transitions.HasCoin},
-				Next:   transitions.ToUnlocked,
-			},
-		))
+	s.machine, s.errCh = fsm.New(uuid.New(), machineName, initialState())
 
 	machineCtx, cancel := context.WithCancel(ctx)
 
@@ -54,6 +48,17 @@ func initStateMachine(ctx context.Context, state service.StateStore) error {
 	return nil
 }
 
+func initialState() fsm.State {
+	return states.Locked(uuid.New()).
+		WithTransitions(
+			fsm.Transition{
+				Name:   "insert coin",
+				Checks: []fsm.CheckFn{transitions.HasCoin},
+				Next:   transitions.ToUnlocked,
+			},
+		)
+}
+
 func initErrorHandler(ctx context.Context, state service.StateStore) error {
 	s := state.(*TurnstileState)
 
@@ -108,6 +113,9 @@ func main() {
 
 	app.SetProperties("", "", "")
 
+	// makes the turnstile available to the fsm list and fsm graph commands
+	fsm.RegisterStates(machineName, initialState())
+
 	state := new(TurnstileState)
 
 	cmd.Execute(context.Background(), app, state)
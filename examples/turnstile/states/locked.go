@@ -1,11 +1,14 @@
 package states
 
 import (
+	"context"
 	"errors"
 
 	"github.com/birchwood-langham/bootstrap/examples/turnstile/events"
 	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type LockedState struct {
@@ -52,3 +55,12 @@ func (l *LockedState) WithTransitions(transitions ...fsm.Transition) fsm.State {
 	l.transitions = append(l.transitions, transitions...)
 	return l
 }
+
+func (l *LockedState) Transitions() []fsm.Transition {
+	return l.transitions
+}
+
+func (l *LockedState) OnEnter(_ context.Context, evt fsm.Event) error {
+	logger.Logger().Info("Turnstile has been locked", zap.String("event", evt.Name()))
+	return nil
+}
@@ -1,11 +1,14 @@
 package states
 
 import (
+	"context"
 	"errors"
 
 	"github.com/birchwood-langham/bootstrap/examples/turnstile/events"
 	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type UnlockedState struct {
@@ -51,3 +54,12 @@ func (u *UnlockedState) WithTransitions(transitions ...fsm.Transition) fsm.State
 	u.transitions = append(u.transitions, transitions...)
 	return u
 }
+
+func (u *UnlockedState) Transitions() []fsm.Transition {
+	return u.transitions
+}
+
+func (u *UnlockedState) OnEnter(_ context.Context, evt fsm.Event) error {
+	logger.Logger().Info("Turnstile has been unlocked, please push to enter", zap.String("event", evt.Name()))
+	return nil
+}
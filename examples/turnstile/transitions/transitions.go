@@ -27,6 +27,7 @@ func Pushed(state fsm.State) bool {
 func ToLocked(_ fsm.State) fsm.State {
 	return states.Locked(uuid.New()).WithTransitions(
 		fsm.Transition{
+			Name:   "insert coin",
 			Checks: []fsm.CheckFn{HasCoin},
 			Next:   ToUnlocked,
 		},
@@ -36,6 +37,7 @@ func ToLocked(_ fsm.State) fsm.State {
 func ToUnlocked(_ fsm.State) fsm.State {
 	return states.Unlocked(uuid.New()).WithTransitions(
 		fsm.Transition{
+			Name:   "push",
 			Checks: []fsm.CheckFn{Pushed},
 			Next:   ToLocked,
 		},
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/examples/turnstile/events"
+	"github.com/birchwood-langham/bootstrap/examples/turnstile/transitions"
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+	"github.com/birchwood-langham/bootstrap/pkg/fsm/fsmtest"
+)
+
+const (
+	locked   = "Turnstile is locked"
+	unlocked = "Turnstile Unlocked"
+)
+
+func newTurnstile() fsm.Machine {
+	m, _ := fsm.New(uuid.New(), machineName, transitions.ToLocked(nil))
+	return m
+}
+
+func coin() fsm.Event {
+	return events.InsertCoin(uuid.New(), "test", time.Now().UnixNano())
+}
+
+func push() fsm.Event {
+	return events.Push(uuid.New(), "test", time.Now().UnixNano())
+}
+
+func TestTurnstile(t *testing.T) {
+	fsmtest.RunScripts(t,
+		fsmtest.Script{
+			Name:    "coin unlocks and push locks",
+			Machine: newTurnstile,
+			Steps: []fsmtest.Step{
+				{Event: coin(), State: unlocked, Transitions: true},
+				{Event: push(), State: locked, Transitions: true},
+			},
+		},
+		fsmtest.Script{
+			Name:    "push without a coin is rejected",
+			Machine: newTurnstile,
+			Steps: []fsmtest.Step{
+				{Event: push(), State: locked, Err: fsmtest.AnyError},
+			},
+		},
+		fsmtest.Script{
+			Name:    "second coin is returned",
+			Machine: newTurnstile,
+			Steps: []fsmtest.Step{
+				{Event: coin(), State: unlocked},
+				{Event: coin(), State: unlocked, Err: fsmtest.AnyError},
+			},
+		},
+	)
+}
+
+func TestTurnstile_Property(t *testing.T) {
+	fsmtest.Check(t, fsmtest.Property{
+		Machine: newTurnstile,
+		Events: func(r *rand.Rand) fsm.Event {
+			if r.Intn(2) == 0 {
+				return coin()
+			}
+
+			return push()
+		},
+		Invariants: []fsmtest.Invariant{fsmtest.OneOf(locked, unlocked)},
+	})
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+var auditKeyFile string
+
+var Audit = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit log tools",
+	Long:  "Tools for working with the audit log written by the service",
+}
+
+var AuditVerify = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Verify an audit log",
+	Long: "Verify the hash chain of an audit log to detect entries that have been modified, removed or truncated. " +
+		"A log signed with a key is verified with the key in --key-file, or the audit.key configuration if no file is given",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	Annotations:  map[string]string{ConfigOptionalAnnotation: "true"},
+	RunE: func(_ *cobra.Command, args []string) error {
+		var opts []logger.AuditOption
+
+		switch {
+		case auditKeyFile != "":
+			key, err := os.ReadFile(auditKeyFile)
+			if err != nil {
+				return fmt.Errorf("could not read audit key: %w", err)
+			}
+
+			opts = append(opts, logger.WithAuditKey(bytes.TrimSpace(key)))
+		case viper.GetString(config.AuditKeyKey) != "":
+			opts = append(opts, logger.WithAuditKey([]byte(viper.GetString(config.AuditKeyKey))))
+		}
+
+		last, err := logger.VerifyAuditLog(args[0], opts...)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Audit log is intact: %d entries, head %s\n", last.Sequence, last.Hash)
+
+		return nil
+	},
+}
+
+func init() {
+	AuditVerify.Flags().StringVar(&auditKeyFile, "key-file", "", "file holding the key the audit log was signed with")
+
+	Audit.AddCommand(AuditVerify)
+	AddCommand(Audit)
+}
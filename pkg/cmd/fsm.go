@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+var graphFormat string
+var graphOutput string
+
+var Fsm = &cobra.Command{
+	Use:   "fsm",
+	Short: "State machine tools",
+	Long:  "Tools for working with the state machines registered by the service",
+}
+
+var FsmList = &cobra.Command{
+	Use:         "list",
+	Short:       "List the registered state machines",
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{ConfigOptionalAnnotation: "true"},
+	Run: func(_ *cobra.Command, _ []string) {
+		for _, name := range fsm.Graphs() {
+			fmt.Println(name)
+		}
+	},
+}
+
+var FsmGraph = &cobra.Command{
+	Use:   "graph <machine>",
+	Short: "Draw a state machine",
+	Long: "Export a registered state machine definition or hand-written machine as a Graphviz DOT, Mermaid or PlantUML " +
+		"diagram, so diagrams in documentation can be generated from the code",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	Annotations:  map[string]string{ConfigOptionalAnnotation: "true"},
+	RunE: func(_ *cobra.Command, args []string) error {
+		g, err := fsm.LookupGraph(args[0])
+		if errors.Is(err, fsm.GraphNotRegisteredError) {
+			return fmt.Errorf("%w, registered state machines: %s", err, strings.Join(fsm.Graphs(), ", "))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		diagram, err := g.Render(graphFormat)
+		if err != nil {
+			return err
+		}
+
+		if graphOutput == "" {
+			fmt.Print(diagram)
+			return nil
+		}
+
+		return os.WriteFile(graphOutput, []byte(diagram), 0644)
+	},
+}
+
+func init() {
+	FsmGraph.Flags().StringVarP(&graphFormat, "format", "f", "dot", "diagram format: dot, mermaid or plantuml")
+	FsmGraph.Flags().StringVarP(&graphOutput, "output", "o", "", "file to write the diagram to, the diagram is written to stdout if not set")
+
+	Fsm.AddCommand(FsmList, FsmGraph)
+	AddCommand(Fsm)
+}
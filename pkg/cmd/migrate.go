@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+var migrationsDir string
+var migrationsTable string
+
+// Migrate is the command for managing database schema migrations. No database drivers are linked in by the command,
+// import the packages of the databases the service uses so the command can connect to them
+var Migrate = &cobra.Command{
+	Use:   "migrate",
+	Short: "Database schema migrations",
+	Long: "Apply and roll back the versioned SQL migrations for the database in the configuration file. " +
+		"The migrations registered by the service are used unless a directory is given",
+}
+
+var MigrateUp = &cobra.Command{
+	Use:          "up",
+	Short:        "Apply every pending migration",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return withMigrator(ctx, func(ctx context.Context, m *db.Migrator) error {
+			applied, err := m.Up(ctx)
+
+			for _, mig := range applied {
+				fmt.Printf("applied %d_%s\n", mig.Version, mig.Name)
+			}
+
+			return err
+		})
+	},
+}
+
+var MigrateDown = &cobra.Command{
+	Use:          "down [steps]",
+	Short:        "Roll back the most recently applied migrations",
+	Long:         "Roll back the given number of the most recently applied migrations, the last migration is rolled back if no number is given",
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		steps := 1
+
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations to roll back: %s", args[0])
+			}
+
+			steps = n
+		}
+
+		return withMigrator(ctx, func(ctx context.Context, m *db.Migrator) error {
+			rolledBack, err := m.Down(ctx, steps)
+
+			for _, mig := range rolledBack {
+				fmt.Printf("rolled back %d_%s\n", mig.Version, mig.Name)
+			}
+
+			return err
+		})
+	},
+}
+
+var MigrateStatus = &cobra.Command{
+	Use:          "status",
+	Short:        "Show which migrations have been applied",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return withMigrator(ctx, func(ctx context.Context, m *db.Migrator) error {
+			status, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+
+			for _, s := range status {
+				state, appliedAt := "pending", ""
+
+				if s.Applied {
+					state, appliedAt = "applied", s.AppliedAt.Format(time.RFC3339)
+				}
+
+				if s.Modified {
+					state = "modified"
+				}
+
+				if s.Missing {
+					state = "missing"
+				}
+
+				_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+			}
+
+			return w.Flush()
+		})
+	},
+}
+
+var MigrateCreate = &cobra.Command{
+	Use:          "create <name>",
+	Short:        "Create a new pair of migration files",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	Annotations:  map[string]string{ConfigOptionalAnnotation: "true"},
+	RunE: func(_ *cobra.Command, args []string) error {
+		dir := migrationsDir
+		if dir == "" {
+			dir = "migrations"
+		}
+
+		up, down, err := db.CreateMigration(dir, args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created %s\ncreated %s\n", up, down)
+
+		return nil
+	},
+}
+
+// withMigrator connects to the configured database and runs the function with a migrator for the migrations
+func withMigrator(ctx context.Context, fn func(context.Context, *db.Migrator) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var source fs.FS
+
+	if migrationsDir != "" {
+		source = os.DirFS(migrationsDir)
+	} else if source = db.RegisteredMigrations(); source == nil {
+		return errors.New("no migrations have been registered, use --dir to give the migrations directory")
+	}
+
+	cfg, err := db.FromConfig()
+	if err != nil {
+		return err
+	}
+
+	conn, err := sql.Open(cfg.Driver(), db.MigrationDSN(cfg))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m, err := db.NewMigrator(conn, cfg.Dialect(), source, db.WithMigrationsTable(migrationsTable))
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, m)
+}
+
+func init() {
+	Migrate.PersistentFlags().StringVarP(&migrationsDir, "dir", "d", "", "directory holding the migrations, defaults to the registered migrations or ./migrations for create")
+	Migrate.PersistentFlags().StringVar(&migrationsTable, "table", db.DefaultMigrationsTable, "table the applied migrations are recorded in")
+
+	Migrate.AddCommand(MigrateUp, MigrateDown, MigrateStatus, MigrateCreate)
+	AddCommand(Migrate)
+}
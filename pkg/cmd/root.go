@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	ge "errors"
 	"fmt"
 	"math"
 	"os"
@@ -12,12 +13,19 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
 	"github.com/birchwood-langham/bootstrap/pkg/io/strings"
 	"github.com/birchwood-langham/bootstrap/pkg/logger"
 	"github.com/birchwood-langham/bootstrap/pkg/service"
 )
 
+// ConfigOptionalAnnotation marks a command that can run without a configuration file, e.g. tools that work on
+// files given on the command line. The configuration is still read if one is found
+const ConfigOptionalAnnotation = "bootstrap.config-optional"
+
 var cfgFile string
+var configOptional bool
 var log *zap.Logger
 var ctx context.Context
 var app service.Application
@@ -32,19 +40,40 @@ func MaxPort() int {
 }
 
 func startService(cmd *cobra.Command, args []string) {
+	if viper.GetString(config.AuditFilePathKey) != "" && logger.Audit() == nil {
+		log.Fatal("could not open the audit log", zap.String("file-path", viper.GetString(config.AuditFilePathKey)))
+	}
+
+	defer func() {
+		_ = logger.Audit().Close()
+	}()
+
+	audit("config.loaded", map[string]interface{}{
+		"file":    viper.ConfigFileUsed(),
+		"version": viper.GetString(config.VersionKey),
+	})
+
 	if err := app.Init(ctx, state); err != nil {
-		log.Fatal("could not initialize the application", zap.Error(err))
+		audit("service.init-failed", map[string]interface{}{"error": err.Error()})
+		log.Error("could not initialize the application", errors.Fields(err)...)
+		os.Exit(errors.ExitCode(err))
 	}
 
+	audit("service.started", nil)
+
 	if app.RunFunction() != nil {
 		if err := app.RunFunction()(ctx, state); err != nil {
 			log.Error("Command failed", zap.Error(err))
 		}
 
 		if err := app.Cleanup(state); err != nil {
-			log.Fatal("could not execute cleanup", zap.Error(err))
+			audit("service.cleanup-failed", map[string]interface{}{"error": err.Error()})
+			log.Error("could not execute cleanup", errors.Fields(err)...)
+			os.Exit(errors.ExitCode(err))
 		}
 
+		audit("service.shutdown", nil)
+
 		return
 	}
 
@@ -58,7 +87,18 @@ func startService(cmd *cobra.Command, args []string) {
 	log.Warn("Caught signal, terminating", zap.String("signal", incoming.String()))
 
 	if err := app.Cleanup(state); err != nil {
-		log.Fatal("could not execute cleanup", zap.Error(err))
+		audit("service.cleanup-failed", map[string]interface{}{"error": err.Error()})
+		log.Error("could not execute cleanup", errors.Fields(err)...)
+		os.Exit(errors.ExitCode(err))
+	}
+
+	audit("service.shutdown", map[string]interface{}{"signal": incoming.String()})
+}
+
+// audit records a lifecycle event in the audit log, failures are logged rather than stopping the service
+func audit(event string, data map[string]interface{}) {
+	if err := logger.Audit().Record(event, data); err != nil {
+		log.Error("could not record audit entry", zap.String("event", event), zap.Error(err))
 	}
 }
 
@@ -97,6 +137,12 @@ func initConfig() {
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if configOptional && ge.As(err, &notFound) {
+			log.Debug("no configuration file found", zap.Error(err))
+			return
+		}
+
 		log.Fatal("could not read application configuration file", zap.Error(err))
 	}
 
@@ -106,7 +152,13 @@ func initConfig() {
 }
 
 func setupLogger() {
-	log = logger.Get(logger.ApplicationLogLevel(), logger.ConfiguredLumberjackLogger())
+	writer := logger.ConfiguredWriter()
+
+	if rw, ok := writer.(*logger.RotatingWriter); ok {
+		rw.RotateOnSignal(syscall.SIGHUP)
+	}
+
+	log = logger.Get(logger.ApplicationLogLevel(), writer)
 	zap.ReplaceGlobals(log)
 }
 
@@ -125,8 +177,12 @@ func Execute(c context.Context, a service.Application, s service.StateStore) {
 	RootCmd.Short = service.ShortDescription
 	RootCmd.Long = service.LongDescription
 
+	if c, _, err := RootCmd.Find(os.Args[1:]); err == nil {
+		configOptional = c.Annotations[ConfigOptionalAnnotation] == "true"
+	}
+
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(errors.ExitCode(err))
 	}
 }
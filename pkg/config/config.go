@@ -25,6 +25,18 @@ const (
 	LogFileMaxAge = "log.max-age"
 	// LogFileCompress is the configuration key for retrieving the log file compression configuration
 	LogFileCompress = "log.compress"
+	// LogFileCompression is the configuration key for retrieving the algorithm used to compress rotated log files
+	LogFileCompression = "log.compression"
+	// LogFileRotation is the configuration key for retrieving the schedule for rotating the log file
+	LogFileRotation = "log.rotation"
+	// LogFileMaxTotalSize is the configuration key for retrieving the maximum size of all rotated log files
+	LogFileMaxTotalSize = "log.max-total-size"
+	// AuditFilePathKey is the configuration key for retrieving the path for the audit log generated by the service
+	AuditFilePathKey = "audit.filepath"
+	// AuditKeyKey is the configuration key for retrieving the secret key used to sign the entries of the audit log
+	AuditKeyKey = "audit.key"
+	// DatabaseKey is the configuration key for the section holding the database connection settings
+	DatabaseKey = "database"
 )
 
 type Config struct {
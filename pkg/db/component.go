@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/health"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+	"github.com/birchwood-langham/bootstrap/pkg/resilience"
+	"github.com/birchwood-langham/bootstrap/pkg/service"
+)
+
+const (
+	// DefaultConnectAttempts is the number of times the component tries to connect on startup
+	DefaultConnectAttempts = 5
+	// DefaultConnectBackoff is the delay before the first connection retry, it is doubled for every following retry
+	DefaultConnectBackoff = time.Second
+	// DefaultMaxIdleConns is the number of idle connections kept in the pool, it matches the database/sql default
+	DefaultMaxIdleConns = 2
+)
+
+// poolStats publishes the statistics of every open pool under the db expvar
+var poolStats = expvar.NewMap("db")
+
+// Component is a service.Component that opens a connection pool when the application is initialized
+// and closes it when the application is cleaned up
+type Component struct {
+	name   string
+	config Configuration
+
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+
+	connectAttempts int
+	connectBackoff  time.Duration
+
+	migrations      fs.FS
+	migratorOptions []MigratorOption
+
+	health *health.Registry
+	db     *sql.DB
+}
+
+var _ service.Component = (*Component)(nil)
+
+// ComponentOption sets an optional setting on the component
+type ComponentOption func(*Component)
+
+// WithName sets the name the pool's health check and metrics are registered under
+func WithName(name string) ComponentOption {
+	return func(c *Component) {
+		c.name = name
+	}
+}
+
+// WithMaxOpenConns limits the number of open connections, there is no limit if it is zero
+func WithMaxOpenConns(n int) ComponentOption {
+	return func(c *Component) {
+		c.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns limits the number of idle connections kept in the pool
+func WithMaxIdleConns(n int) ComponentOption {
+	return func(c *Component) {
+		c.maxIdleConns = n
+	}
+}
+
+// WithConnMaxLifetime sets the maximum time a connection may be reused, connections are reused forever if it is zero
+func WithConnMaxLifetime(d time.Duration) ComponentOption {
+	return func(c *Component) {
+		c.connMaxLifetime = d
+	}
+}
+
+// WithConnMaxIdleTime sets the maximum time a connection may be idle, idle connections are kept if it is zero
+func WithConnMaxIdleTime(d time.Duration) ComponentOption {
+	return func(c *Component) {
+		c.connMaxIdleTime = d
+	}
+}
+
+// WithConnectRetries sets the number of times the component tries to connect on startup and the delay before the
+// first retry, the delay is doubled for every following retry. The component tries once if attempts is less than one
+func WithConnectRetries(attempts int, backoff time.Duration) ComponentOption {
+	return func(c *Component) {
+		c.connectAttempts = attempts
+		c.connectBackoff = backoff
+	}
+}
+
+// WithHealthRegistry sets the registry the pool's health check is registered with, the default registry is used otherwise
+func WithHealthRegistry(r *health.Registry) ComponentOption {
+	return func(c *Component) {
+		c.health = r
+	}
+}
+
+// WithMigrations applies any pending migrations in the file system once the component has connected, see NewMigrator
+func WithMigrations(fsys fs.FS, opts ...MigratorOption) ComponentOption {
+	return func(c *Component) {
+		c.migrations = fsys
+		c.migratorOptions = opts
+	}
+}
+
+// NewComponent creates a component that connects to the configured database, the component is named after the
+// configuration's driver unless it is given a name
+func NewComponent(cfg Configuration, opts ...ComponentOption) *Component {
+	c := &Component{
+		name:            cfg.Driver(),
+		config:          cfg,
+		maxIdleConns:    DefaultMaxIdleConns,
+		connectAttempts: DefaultConnectAttempts,
+		connectBackoff:  DefaultConnectBackoff,
+		health:          health.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ComponentFromConfig creates a component using the connection and pool settings under the given configuration path,
+// the settings are read from the database section if no path is given, see FromConfig and ComponentOptionsFromConfig
+func ComponentFromConfig(path ...string) (*Component, error) {
+	cfg, err := FromConfig(path...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewComponent(cfg, ComponentOptionsFromConfig(path...)...), nil
+}
+
+// ComponentOptionsFromConfig returns the options for the pool settings under the given configuration path, the settings
+// are read from the database section if no path is given. The pool settings are
+//
+//	database:
+//	    max-open-conns: 20
+//	    max-idle-conns: 5
+//	    conn-max-lifetime: 30m
+//	    conn-max-idle-time: 5m
+//	    connect-attempts: 5
+//	    connect-backoff: 1s
+//
+// The component is named after the configuration path, unless the settings are read from the database section
+func ComponentOptionsFromConfig(path ...string) []ComponentOption {
+	opts := make([]ComponentOption, 0)
+
+	if len(path) > 0 {
+		opts = append(opts, WithName(strings.Join(path, ".")))
+	}
+
+	path = configPath(path)
+
+	get := func(key string) *config.Config {
+		return config.Get(append(append([]string{}, path...), key)...)
+	}
+
+	return append(opts,
+		WithMaxOpenConns(get("max-open-conns").Int(0)),
+		WithMaxIdleConns(get("max-idle-conns").Int(DefaultMaxIdleConns)),
+		WithConnMaxLifetime(get("conn-max-lifetime").Duration(0)),
+		WithConnMaxIdleTime(get("conn-max-idle-time").Duration(0)),
+		WithConnectRetries(
+			get("connect-attempts").Int(DefaultConnectAttempts),
+			get("connect-backoff").Duration(DefaultConnectBackoff),
+		),
+	)
+}
+
+// Init opens the pool and waits until it can connect to the database, retrying with an exponential backoff.
+// Once connected, any pending migrations are applied and the pool's health check and metrics are registered
+func (c *Component) Init(ctx context.Context, state service.StateStore) error {
+	pool, err := sql.Open(c.config.Driver(), c.config.DSN())
+	if err != nil {
+		return fmt.Errorf("could not open %s connection pool: %w", c.name, err)
+	}
+
+	pool.SetMaxOpenConns(c.maxOpenConns)
+	pool.SetMaxIdleConns(c.maxIdleConns)
+	pool.SetConnMaxLifetime(c.connMaxLifetime)
+	pool.SetConnMaxIdleTime(c.connMaxIdleTime)
+
+	if err := c.connect(ctx, pool); err != nil {
+		_ = pool.Close()
+		return err
+	}
+
+	if c.migrations != nil {
+		if err := c.migrate(ctx, state, pool); err != nil {
+			_ = pool.Close()
+			return fmt.Errorf("could not migrate %s database: %w", c.name, err)
+		}
+	}
+
+	c.db = pool
+
+	c.health.Register(c.name, health.CheckFunc(pool.PingContext))
+	poolStats.Set(c.name, expvar.Func(func() interface{} {
+		return statsMap(pool.Stats())
+	}))
+
+	return nil
+}
+
+func (c *Component) connect(ctx context.Context, pool *sql.DB) error {
+	attempts := c.connectAttempts
+	if attempts < 1 {
+		// the policy retries without a limit when it has no attempts, the component only tries once
+		attempts = 1
+	}
+
+	retry := resilience.NewRetryPolicy("connect to "+c.name+" database",
+		resilience.WithAttempts(attempts),
+		resilience.WithBackoff(resilience.Exponential(c.connectBackoff, 0)),
+	)
+
+	if err := retry.Do(ctx, pool.PingContext); err != nil {
+		return err
+	}
+
+	logger.Logger().Info("Connected to database", zap.String("name", c.name), zap.String("driver", c.config.Driver()))
+
+	return nil
+}
+
+// migrate applies the pending migrations using the pool, or using a separate pool if the configuration connects
+// differently to apply migrations
+func (c *Component) migrate(ctx context.Context, state service.StateStore, pool *sql.DB) error {
+	if dsn := MigrationDSN(c.config); dsn != c.config.DSN() {
+		migrations, err := sql.Open(c.config.Driver(), dsn)
+		if err != nil {
+			return err
+		}
+
+		defer migrations.Close()
+
+		pool = migrations
+	}
+
+	return AutoMigrate(func() *sql.DB { return pool }, c.config.Dialect(), c.migrations, c.migratorOptions...)(ctx, state)
+}
+
+// Cleanup removes the pool's health check and metrics and closes the pool
+func (c *Component) Cleanup(service.StateStore) error {
+	if c.db == nil {
+		return nil
+	}
+
+	c.health.Unregister(c.name)
+	poolStats.Delete(c.name)
+
+	err := c.db.Close()
+	c.db = nil
+
+	return err
+}
+
+// DB returns the connection pool, it is nil until the component has been initialized
+func (c *Component) DB() *sql.DB {
+	return c.db
+}
+
+// Name returns the name the pool's health check and metrics are registered under
+func (c *Component) Name() string {
+	return c.name
+}
+
+// Configuration returns the configuration of the database the component connects to
+func (c *Component) Configuration() Configuration {
+	return c.config
+}
+
+// Stats returns the statistics of the connection pool
+func (c *Component) Stats() (sql.DBStats, error) {
+	if c.db == nil {
+		return sql.DBStats{}, errors.New("the connection pool has not been opened")
+	}
+
+	return c.db.Stats(), nil
+}
+
+func statsMap(s sql.DBStats) map[string]interface{} {
+	return map[string]interface{}{
+		"max-open-connections": s.MaxOpenConnections,
+		"open-connections":     s.OpenConnections,
+		"in-use":               s.InUse,
+		"idle":                 s.Idle,
+		"wait-count":           s.WaitCount,
+		"wait-duration":        s.WaitDuration.Milliseconds(),
+		"max-idle-closed":      s.MaxIdleClosed,
+		"max-idle-time-closed": s.MaxIdleTimeClosed,
+		"max-lifetime-closed":  s.MaxLifetimeClosed,
+	}
+}
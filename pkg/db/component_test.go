@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	"github.com/birchwood-langham/bootstrap/pkg/health"
+)
+
+// flakyDriver refuses connections until it has been asked to connect more than failures times
+type flakyDriver struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (d *flakyDriver) Open(string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.attempts++
+
+	if d.attempts <= d.failures {
+		return nil, errors.New("connection refused")
+	}
+
+	return conn{}, nil
+}
+
+type conn struct{}
+
+func (conn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (conn) Close() error                        { return nil }
+func (conn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+var flaky = &flakyDriver{}
+
+func init() {
+	sql.Register("flaky", flaky)
+}
+
+// configuration connects to the database using any driver and data source name
+type configuration struct {
+	driver  string
+	dsn     string
+	dialect db.Dialect
+}
+
+func (c configuration) Driver() string      { return c.driver }
+func (c configuration) DSN() string         { return c.dsn }
+func (c configuration) Dialect() db.Dialect { return c.dialect }
+
+func TestComponent(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		attempts  int
+		connected bool
+	}{
+		{name: "connects after retrying", retries: 3, failures: 2, attempts: 3, connected: true},
+		{name: "gives up", retries: 3, failures: 5, attempts: 3, connected: false},
+		{name: "tries once without retries", retries: 0, failures: 5, attempts: 1, connected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flaky.failures, flaky.attempts = test.failures, 0
+
+			registry := health.NewRegistry(time.Second)
+
+			c := db.NewComponent(configuration{driver: "flaky", dialect: db.SQLite},
+				db.WithName("orders"),
+				db.WithConnectRetries(test.retries, time.Millisecond),
+				db.WithMaxOpenConns(4),
+				db.WithHealthRegistry(registry),
+			)
+
+			err := c.Init(context.Background(), nil)
+
+			if test.connected != (err == nil) {
+				t.Fatalf("expected connected to be %v - %v", test.connected, err)
+			}
+
+			if flaky.attempts != test.attempts {
+				t.Errorf("expected %d attempts to connect, got %d", test.attempts, flaky.attempts)
+			}
+
+			if !test.connected {
+				if c.DB() != nil || len(registry.Names()) != 0 {
+					t.Errorf("expected a failed component not to register its pool")
+				}
+
+				return
+			}
+
+			if report := registry.Run(context.Background()); !report.Healthy() || report.Checks["orders"].Status != health.StatusUp {
+				t.Errorf("expected the pool's health check to be registered, got %+v", report)
+			}
+
+			if stats, err := c.Stats(); err != nil || stats.MaxOpenConnections != 4 {
+				t.Errorf("unexpected pool stats %+v - %v", stats, err)
+			}
+
+			if expvar.Get("db").(*expvar.Map).Get("orders") == nil {
+				t.Errorf("expected the pool's stats to be published")
+			}
+
+			if err := c.Cleanup(nil); err != nil {
+				t.Fatalf("could not clean up the component - %v", err)
+			}
+
+			if c.DB() != nil || len(registry.Names()) != 0 || expvar.Get("db").(*expvar.Map).Get("orders") != nil {
+				t.Errorf("expected cleanup to close the pool and remove its health check and stats")
+			}
+		})
+	}
+}
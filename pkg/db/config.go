@@ -0,0 +1,106 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+)
+
+// DefaultDriver is the driver used when the configuration does not name one
+const DefaultDriver = "postgres"
+
+// Configuration is the connection configuration for a database
+type Configuration interface {
+	// Driver is the name of the database/sql driver used to connect
+	Driver() string
+	// DSN is the data source name passed to the driver
+	DSN() string
+	// Dialect describes the database's SQL dialect
+	Dialect() Dialect
+}
+
+// MigrationConfiguration is implemented by configurations that need different connection settings to apply
+// migrations, such as MySQL, which only runs a migration holding more than one statement when they are enabled
+type MigrationConfiguration interface {
+	// MigrationDSN is the data source name used by the connections that apply migrations
+	MigrationDSN() string
+}
+
+// MigrationDSN returns the data source name used to apply migrations to the database, the configuration's own DSN is
+// used unless it implements MigrationConfiguration
+func MigrationDSN(cfg Configuration) string {
+	if mc, ok := cfg.(MigrationConfiguration); ok {
+		return mc.MigrationDSN()
+	}
+
+	return cfg.DSN()
+}
+
+// ConfigurationLoader reads a database configuration from the settings under the configuration path
+type ConfigurationLoader func(path ...string) (Configuration, error)
+
+var (
+	loadersMu sync.RWMutex
+	loaders   = make(map[string]ConfigurationLoader)
+)
+
+// RegisterDriver registers the loader for the configurations of a driver, the database packages register
+// their drivers when they are imported
+func RegisterDriver(driver string, loader ConfigurationLoader) {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+
+	loaders[driver] = loader
+}
+
+// Drivers returns the names of the registered drivers in alphabetical order
+func Drivers() []string {
+	loadersMu.RLock()
+	defer loadersMu.RUnlock()
+
+	drivers := make([]string, 0, len(loaders))
+	for d := range loaders {
+		drivers = append(drivers, d)
+	}
+
+	sort.Strings(drivers)
+
+	return drivers
+}
+
+// FromConfig reads the database configuration under the configuration path, or from the database section if no
+// path is given. The driver setting selects the type of configuration, e.g.
+//
+//	database:
+//	    driver: mysql
+//	    host: localhost
+//	    ...
+//
+// The package for the driver must be imported to register it, postgres is used if no driver is configured
+func FromConfig(path ...string) (Configuration, error) {
+	path = configPath(path)
+
+	driver := config.Get(append(append([]string{}, path...), "driver")...).String(DefaultDriver)
+
+	loadersMu.RLock()
+	loader, ok := loaders[driver]
+	loadersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("database driver %s configured in %s has not been registered, registered drivers: %s",
+			driver, strings.Join(path, "."), strings.Join(Drivers(), ", "))
+	}
+
+	return loader(path...)
+}
+
+func configPath(path []string) []string {
+	if len(path) == 0 {
+		return []string{config.DatabaseKey}
+	}
+
+	return path
+}
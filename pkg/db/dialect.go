@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+)
+
+// Dialect describes the differences between the databases the migrator and transaction helpers support
+type Dialect interface {
+	// Name is the name of the database, e.g. postgres
+	Name() string
+	// Placeholder returns the placeholder for the nth argument of a statement, starting from 1
+	Placeholder(n int) string
+	// Lock takes a lock with the given name on the connection, blocking until it is available
+	Lock(ctx context.Context, conn *sql.Conn, name string) error
+	// Unlock releases a lock taken on the connection
+	Unlock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// Postgres is the dialect for PostgreSQL, locks are session level advisory locks
+var Postgres Dialect = postgres{}
+
+// MySQL is the dialect for MySQL, locks are named locks held by the connection
+var MySQL Dialect = mysql{}
+
+// SQLite is the dialect for SQLite, it does not take locks as SQLite only allows a single writer
+var SQLite Dialect = sqlite{}
+
+type postgres struct{}
+
+func (postgres) Name() string {
+	return "postgres"
+}
+
+func (postgres) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgres) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey(name))
+	return err
+}
+
+func (postgres) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey(name))
+	return err
+}
+
+// lockKey hashes the lock name to the 64 bit key used by postgres advisory locks
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+
+	return int64(h.Sum64())
+}
+
+type mysql struct{}
+
+func (mysql) Name() string {
+	return "mysql"
+}
+
+func (mysql) Placeholder(int) string {
+	return "?"
+}
+
+func (mysql) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	var locked sql.NullInt64
+
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", name).Scan(&locked); err != nil {
+		return err
+	}
+
+	if locked.Int64 != 1 {
+		return fmt.Errorf("could not take lock %s", name)
+	}
+
+	return nil
+}
+
+func (mysql) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+type sqlite struct{}
+
+func (sqlite) Name() string {
+	return "sqlite"
+}
+
+func (sqlite) Placeholder(int) string {
+	return "?"
+}
+
+func (sqlite) Lock(context.Context, *sql.Conn, string) error {
+	return nil
+}
+
+func (sqlite) Unlock(context.Context, *sql.Conn, string) error {
+	return nil
+}
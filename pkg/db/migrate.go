@@ -0,0 +1,460 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+	"github.com/birchwood-langham/bootstrap/pkg/service"
+)
+
+// DefaultMigrationsTable is the table the migrator records the applied migrations in
+const DefaultMigrationsTable = "schema_migrations"
+
+// migrationFile matches migration file names, e.g. 20210301120000_create_orders.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Migration is a versioned change to the database schema
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	// Applied is true if the migration has been applied
+	Applied bool
+	// AppliedAt is the time the migration was applied
+	AppliedAt time.Time
+	// Modified is true if the migration has been changed since it was applied
+	Modified bool
+	// Missing is true if the migration has been applied but its file no longer exists
+	Missing bool
+}
+
+// ChecksumMismatchError is returned when an applied migration has been changed since it was applied
+type ChecksumMismatchError struct {
+	Version int64
+	Name    string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("migration %d_%s has been modified since it was applied", e.Version, e.Name)
+}
+
+// IrreversibleMigrationError is returned when rolling back a migration that has no down migration
+var IrreversibleMigrationError = errors.New("migration cannot be rolled back")
+
+// LoadMigrations reads the migrations from the root of the file system, each migration is a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, the down migration is optional.
+// The migrations are returned in version order
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %w", e.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s have the same version", version, m.Name, version, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s does not have an up migration", m.Version, m.Name)
+		}
+
+		sum := sha256.Sum256([]byte(m.Up))
+		m.Checksum = hex.EncodeToString(sum[:])
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// CreateMigration writes an empty pair of migration files to the directory, versioned with the current time,
+// and returns their paths
+func CreateMigration(dir string, name string) (string, string, error) {
+	name = strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}), "_"))
+
+	if name == "" {
+		return "", "", errors.New("a migration name is required")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("could not create migrations directory: %w", err)
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102150405"), name))
+	up, down := base+".up.sql", base+".down.sql"
+
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return "", "", fmt.Errorf("could not create migration: %w", err)
+		}
+
+		if err := f.Close(); err != nil {
+			return "", "", err
+		}
+	}
+
+	return up, down, nil
+}
+
+// Migrator applies migrations to a database, recording the applied migrations in a table. Migrations are applied
+// while holding a lock, so services starting at the same time do not apply the same migration twice
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+	table      string
+}
+
+// MigratorOption sets an optional setting on the migrator
+type MigratorOption func(*Migrator)
+
+// WithMigrationsTable sets the table the applied migrations are recorded in
+func WithMigrationsTable(table string) MigratorOption {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// NewMigrator creates a migrator for the migrations in the file system, see LoadMigrations. To use migrations
+// embedded in the binary, pass the directory holding them, e.g.
+//
+//	//go:embed migrations/*.sql
+//	var embedded embed.FS
+//
+//	migrations, _ := fs.Sub(embedded, "migrations")
+//	migrator, err := db.NewMigrator(conn, db.Postgres, migrations)
+func NewMigrator(conn *sql.DB, dialect Dialect, fsys fs.FS, opts ...MigratorOption) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{db: conn, dialect: dialect, migrations: migrations, table: DefaultMigrationsTable}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if !tableName.MatchString(m.table) {
+		return nil, fmt.Errorf("invalid migrations table name %s", m.table)
+	}
+
+	return m, nil
+}
+
+// Migrations returns the migrations in version order
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration in version order and returns the migrations that were applied.
+// It fails without applying anything if an applied migration has been modified
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied := make([]Migration, 0)
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			if s.Modified {
+				return ChecksumMismatchError{Version: s.Version, Name: s.Name}
+			}
+		}
+
+		for _, s := range status {
+			if s.Applied || s.Missing {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, s.Migration); err != nil {
+				return err
+			}
+
+			applied = append(applied, s.Migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the given number of the most recently applied migrations and returns the migrations that were
+// rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	rolledBack := make([]Migration, 0)
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(status) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			s := status[i]
+
+			if !s.Applied {
+				continue
+			}
+
+			if s.Missing || s.Down == "" {
+				return fmt.Errorf("%w: %d_%s has no down migration", IrreversibleMigrationError, s.Version, s.Name)
+			}
+
+			if err := m.rollback(ctx, conn, s.Migration); err != nil {
+				return err
+			}
+
+			rolledBack = append(rolledBack, s.Migration)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status reports whether each migration has been applied, including applied migrations whose files no longer exist
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+
+	err := m.locked(ctx, func(conn *sql.Conn) (err error) {
+		status, err = m.status(ctx, conn)
+		return err
+	})
+
+	return status, err
+}
+
+// locked runs the function on a single connection while holding the migrations lock, creating the migrations
+// table if it does not exist
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to the database: %w", err)
+	}
+	defer conn.Close()
+
+	if err := m.dialect.Lock(ctx, conn, m.table); err != nil {
+		return fmt.Errorf("could not lock migrations: %w", err)
+	}
+
+	defer func() {
+		if err := m.dialect.Unlock(context.Background(), conn, m.table); err != nil {
+			logger.Logger().Error("Could not unlock migrations", zap.Error(err))
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version BIGINT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	checksum VARCHAR(64) NOT NULL,
+	applied_at BIGINT NOT NULL
+)`, m.table))
+
+	if err != nil {
+		return fmt.Errorf("could not create migrations table %s: %w", m.table, err)
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("could not read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]MigrationStatus)
+
+	for rows.Next() {
+		var s MigrationStatus
+		var appliedAt int64
+
+		if err := rows.Scan(&s.Version, &s.Name, &s.Checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("could not read applied migrations: %w", err)
+		}
+
+		s.Applied = true
+		s.AppliedAt = time.Unix(0, appliedAt)
+		applied[s.Version] = s
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read applied migrations: %w", err)
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations)+len(applied))
+
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig}
+
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+			s.Modified = a.Checksum != mig.Checksum
+			delete(applied, mig.Version)
+		}
+
+		status = append(status, s)
+	}
+
+	for _, a := range applied {
+		a.Missing = true
+		status = append(status, a)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+
+	return status, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	logger.Logger().Info("Applying migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+
+	insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) VALUES (%s, %s, %s, %s)", m.table,
+		m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3), m.dialect.Placeholder(4))
+
+	return m.inTx(ctx, conn, mig, mig.Up, insert, mig.Version, mig.Name, mig.Checksum, time.Now().UnixNano())
+}
+
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	logger.Logger().Info("Rolling back migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.table, m.dialect.Placeholder(1))
+
+	return m.inTx(ctx, conn, mig, mig.Down, remove, mig.Version)
+}
+
+// inTx runs the migration's sql and the statement recording it in a single transaction
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, mig Migration, migration string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %d_%s failed: %w", mig.Version, mig.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("could not record migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+var (
+	sourceMu sync.RWMutex
+	source   fs.FS
+)
+
+// RegisterMigrations sets the migrations the migrate command applies when it is not given a directory
+func RegisterMigrations(fsys fs.FS) {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+
+	source = fsys
+}
+
+// RegisteredMigrations returns the migrations registered with RegisterMigrations, or nil if none have been registered
+func RegisteredMigrations() fs.FS {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+
+	return source
+}
+
+// AutoMigrate returns an init function that applies any pending migrations when the application is initialized.
+// The connection is obtained when the init function runs, so it can be taken from a component initialized before it
+func AutoMigrate(conn func() *sql.DB, dialect Dialect, fsys fs.FS, opts ...MigratorOption) service.InitFunc {
+	return func(ctx context.Context, _ service.StateStore) error {
+		m, err := NewMigrator(conn(), dialect, fsys, opts...)
+		if err != nil {
+			return err
+		}
+
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		logger.Logger().Info("Database migrated", zap.Int("applied", len(applied)))
+
+		return nil
+	}
+}
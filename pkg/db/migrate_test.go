@@ -0,0 +1,192 @@
+//go:build cgo
+// +build cgo
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	"github.com/birchwood-langham/bootstrap/pkg/health"
+)
+
+func migrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_create_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY, total INTEGER);")},
+		"0001_create_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+		"0002_add_status.up.sql":      {Data: []byte("ALTER TABLE orders ADD COLUMN status TEXT;\nCREATE INDEX orders_status ON orders (status);")},
+		"0002_add_status.down.sql":    {Data: []byte("DROP INDEX orders_status;\nUPDATE orders SET status = NULL;")},
+		"0003_seed.up.sql":            {Data: []byte("INSERT INTO orders (id, total, status) VALUES (1, 100, 'new');")},
+		"readme.md":                   {Data: []byte("not a migration")},
+	}
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("could not open database - %v", err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestLoadMigrations(t *testing.T) {
+	loaded, err := db.LoadMigrations(migrations())
+	if err != nil {
+		t.Fatalf("could not load migrations - %v", err)
+	}
+
+	if len(loaded) != 3 || loaded[0].Name != "create_orders" || loaded[2].Down != "" {
+		t.Errorf("unexpected migrations %+v", loaded)
+	}
+
+	invalid := fstest.MapFS{
+		"0001_create_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+	}
+
+	if _, err := db.LoadMigrations(invalid); err == nil {
+		t.Errorf("expected a migration without an up migration to be rejected")
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+	source := migrations()
+
+	m, err := db.NewMigrator(conn, db.SQLite, source)
+	if err != nil {
+		t.Fatalf("could not create migrator - %v", err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || len(applied) != 3 {
+		t.Fatalf("expected 3 migrations to be applied, got %d - %v", len(applied), err)
+	}
+
+	var status string
+	if err := conn.QueryRow("SELECT status FROM orders WHERE id = 1").Scan(&status); err != nil || status != "new" {
+		t.Errorf("expected the migrations to have created and seeded the orders table - %v", err)
+	}
+
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("expected no migrations to be pending, got %d - %v", len(applied), err)
+	}
+
+	// the last migration cannot be rolled back, so nothing is rolled back
+	if _, err := m.Down(ctx, 1); !errors.Is(err, db.IrreversibleMigrationError) {
+		t.Errorf("expected an irreversible migration error, got %v", err)
+	}
+
+	delete(source, "0003_seed.up.sql")
+	_, _ = conn.Exec("DELETE FROM orders")
+
+	m, _ = db.NewMigrator(conn, db.SQLite, source)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("could not get migration status - %v", err)
+	}
+
+	if len(statuses) != 3 || !statuses[2].Missing || !statuses[0].Applied {
+		t.Errorf("unexpected migration status %+v", statuses)
+	}
+
+	_, _ = conn.Exec("DELETE FROM schema_migrations WHERE version = 3")
+
+	rolledBack, err := m.Down(ctx, 2)
+	if err != nil || len(rolledBack) != 2 || rolledBack[0].Version != 2 {
+		t.Fatalf("expected 2 migrations to be rolled back, got %+v - %v", rolledBack, err)
+	}
+
+	if _, err := conn.Exec("SELECT 1 FROM orders"); err == nil {
+		t.Errorf("expected the orders table to have been dropped")
+	}
+}
+
+func TestMigrator_ChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	conn := openSQLite(t)
+	source := migrations()
+
+	m, _ := db.NewMigrator(conn, db.SQLite, source)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("could not apply migrations - %v", err)
+	}
+
+	source["0001_create_orders.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE orders (id BIGINT PRIMARY KEY);")}
+	source["0004_add_customer.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE orders ADD COLUMN customer TEXT;")}
+
+	m, _ = db.NewMigrator(conn, db.SQLite, source)
+
+	var mismatch db.ChecksumMismatchError
+	if _, err := m.Up(ctx); !errors.As(err, &mismatch) || mismatch.Version != 1 {
+		t.Fatalf("expected a checksum mismatch for migration 1, got %v", err)
+	}
+
+	statuses, _ := m.Status(ctx)
+	if !statuses[0].Modified || statuses[3].Applied {
+		t.Errorf("expected the modified migration to be reported and the new migration not to be applied, got %+v", statuses)
+	}
+}
+
+func TestAutoMigrate(t *testing.T) {
+	conn := openSQLite(t)
+
+	migrate := db.AutoMigrate(func() *sql.DB { return conn }, db.SQLite, migrations(), db.WithMigrationsTable("migrations"))
+
+	if err := migrate(context.Background(), nil); err != nil {
+		t.Fatalf("could not migrate database - %v", err)
+	}
+
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM migrations").Scan(&count); err != nil || count != 3 {
+		t.Errorf("expected 3 migrations to be recorded, got %d - %v", count, err)
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := db.CreateMigration(dir, "Add customer email")
+	if err != nil {
+		t.Fatalf("could not create migration - %v", err)
+	}
+
+	name := regexp.MustCompile(`^\d{14}_add_customer_email\.(up|down)\.sql$`)
+
+	if filepath.Dir(up) != dir || !name.MatchString(filepath.Base(up)) || !name.MatchString(filepath.Base(down)) {
+		t.Errorf("unexpected migration files %s %s", up, down)
+	}
+}
+
+func TestComponent_WithMigrations(t *testing.T) {
+	cfg := configuration{driver: "sqlite3", dsn: filepath.Join(t.TempDir(), "test.db"), dialect: db.SQLite}
+
+	c := db.NewComponent(cfg, db.WithMigrations(migrations()), db.WithHealthRegistry(health.NewRegistry(0)))
+
+	if c.Name() != "sqlite3" {
+		t.Errorf("expected the component to be named after its driver, got %s", c.Name())
+	}
+
+	if err := c.Init(context.Background(), nil); err != nil {
+		t.Fatalf("could not initialize the component - %v", err)
+	}
+
+	defer func() { _ = c.Cleanup(nil) }()
+
+	var total int
+	if err := c.DB().QueryRow("SELECT total FROM orders WHERE id = 1").Scan(&total); err != nil || total != 100 {
+		t.Errorf("expected the migrations to have been applied - %v", err)
+	}
+}
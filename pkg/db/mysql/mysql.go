@@ -0,0 +1,228 @@
+package mysql
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+const (
+	// DefaultDriver is the database/sql driver used to connect, it is registered by go-sql-driver/mysql
+	DefaultDriver = "mysql"
+	// DefaultHost is the host used when no host has been configured
+	DefaultHost = "localhost"
+	// DefaultPort is the port used when no port has been configured
+	DefaultPort = 3306
+)
+
+// deadlock is the error number MySQL returns when a transaction is chosen as the victim of a deadlock
+const deadlock = 1213
+
+var _ db.Configuration = Configuration{}
+
+func init() {
+	db.RegisterDriver(DefaultDriver, func(path ...string) (db.Configuration, error) {
+		return FromConfig(path...), nil
+	})
+}
+
+// Configuration is the connection configuration for a MySQL database
+type Configuration struct {
+	host     string
+	port     int
+	user     string
+	password string
+	database string
+
+	tls            string
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	params         map[string]string
+}
+
+// Option sets an optional connection setting on the configuration
+type Option func(*Configuration)
+
+// WithTLS sets the TLS configuration used to connect, true, false, skip-verify, preferred or the name of a
+// configuration registered with the driver's RegisterTLSConfig
+func WithTLS(name string) Option {
+	return func(c *Configuration) {
+		c.tls = name
+	}
+}
+
+// WithConnectTimeout sets the maximum time to wait while connecting
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.connectTimeout = d
+	}
+}
+
+// WithReadTimeout sets the maximum time to wait for a read from the server
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the maximum time to wait for a write to the server
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.writeTimeout = d
+	}
+}
+
+// WithParam adds a connection parameter, such as a system variable, that has no option of its own
+func WithParam(key, value string) Option {
+	return func(c *Configuration) {
+		if c.params == nil {
+			c.params = make(map[string]string)
+		}
+
+		c.params[key] = value
+	}
+}
+
+// NewConfiguration creates the configuration for the database on the given server
+func NewConfiguration(host string, port int, user string, password string, database string, opts ...Option) Configuration {
+	c := Configuration{
+		host:     host,
+		port:     port,
+		user:     user,
+		password: password,
+		database: database,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// FromConfig creates the configuration from the settings under the given configuration path, the settings are read from
+// the database section if no path is given. E.g.
+//
+//	database:
+//	    driver: mysql
+//	    host: localhost
+//	    port: 3306
+//	    user: orders
+//	    password: secret
+//	    name: orders
+//	    tls: true
+//	    connect-timeout: 10s
+//	    read-timeout: 30s
+//	    write-timeout: 30s
+//	    params:
+//	        sql_mode: "'STRICT_ALL_TABLES'"
+func FromConfig(path ...string) Configuration {
+	if len(path) == 0 {
+		path = []string{config.DatabaseKey}
+	}
+
+	get := func(key string) *config.Config {
+		return config.Get(append(append([]string{}, path...), key)...)
+	}
+
+	c := NewConfiguration(
+		get("host").String(DefaultHost),
+		get("port").Int(DefaultPort),
+		get("user").String(""),
+		get("password").String(""),
+		get("name").String(""),
+		WithTLS(get("tls").String("")),
+		WithConnectTimeout(get("connect-timeout").Duration(0)),
+		WithReadTimeout(get("read-timeout").Duration(0)),
+		WithWriteTimeout(get("write-timeout").Duration(0)),
+	)
+
+	for k, v := range get("params").StringMapString(nil) {
+		WithParam(k, v)(&c)
+	}
+
+	return c
+}
+
+// Driver returns the name of the database/sql driver used to connect
+func (c Configuration) Driver() string {
+	return DefaultDriver
+}
+
+// DSN returns the data source name for the driver, with every value escaped. Time values are parsed to time.Time
+func (c Configuration) DSN() string {
+	return c.config().FormatDSN()
+}
+
+// MigrationDSN returns the data source name with multiple statements enabled, so migrations can hold more than one
+// statement. They are only enabled for the connections applying migrations, as they make SQL injection more damaging
+func (c Configuration) MigrationDSN() string {
+	cfg := c.config()
+	cfg.MultiStatements = true
+
+	return cfg.FormatDSN()
+}
+
+func (c Configuration) config() *driver.Config {
+	cfg := driver.NewConfig()
+
+	cfg.User = c.user
+	cfg.Passwd = c.password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	cfg.DBName = c.database
+	cfg.TLSConfig = c.tls
+	cfg.Timeout = c.connectTimeout
+	cfg.ReadTimeout = c.readTimeout
+	cfg.WriteTimeout = c.writeTimeout
+	cfg.ParseTime = true
+
+	if len(c.params) > 0 {
+		cfg.Params = c.params
+	}
+
+	return cfg
+}
+
+// Dialect returns the MySQL dialect
+func (c Configuration) Dialect() db.Dialect {
+	return db.MySQL
+}
+
+func (c Configuration) Host() string {
+	return c.host
+}
+
+func (c Configuration) Port() int {
+	return c.port
+}
+
+func (c Configuration) User() string {
+	return c.user
+}
+
+func (c Configuration) Password() string {
+	return c.password
+}
+
+func (c Configuration) Database() string {
+	return c.database
+}
+
+// IsRetryable returns true if the error is a deadlock, MySQL errors do not report their SQLSTATE so db.IsRetryable
+// does not recognise them. Use it as the TxOptions.Retryable function for transactions on MySQL databases
+func IsRetryable(err error) bool {
+	var me *driver.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == deadlock
+	}
+
+	return db.IsRetryable(err)
+}
@@ -0,0 +1,89 @@
+package mysql_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	"github.com/birchwood-langham/bootstrap/pkg/db/mysql"
+)
+
+func TestConfiguration_DSN(t *testing.T) {
+	c := mysql.NewConfiguration("db.local", 3307, "orders", "p@ss/w:rd", "orders",
+		mysql.WithTLS("skip-verify"),
+		mysql.WithConnectTimeout(10*time.Second),
+	)
+
+	expected := "orders:p@ss/w:rd@tcp(db.local:3307)/orders?parseTime=true&timeout=10s&tls=skip-verify"
+
+	if dsn := c.DSN(); dsn != expected {
+		t.Errorf("expected %s, got %s", expected, dsn)
+	}
+
+	expected = "orders:p@ss/w:rd@tcp(db.local:3307)/orders?multiStatements=true&parseTime=true&timeout=10s&tls=skip-verify"
+
+	if dsn := db.MigrationDSN(c); dsn != expected {
+		t.Errorf("expected multiple statements to be enabled for migrations %s, got %s", expected, dsn)
+	}
+
+	parsed, err := driver.ParseDSN(c.DSN())
+	if err != nil {
+		t.Fatalf("could not parse data source name - %v", err)
+	}
+
+	if parsed.Passwd != c.Password() || parsed.DBName != c.Database() {
+		t.Errorf("expected the password and database to survive parsing, got %s %s", parsed.Passwd, parsed.DBName)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("database.driver", "mysql")
+	viper.Set("database.host", "db.local")
+	viper.Set("database.user", "orders")
+	viper.Set("database.name", "orders")
+	viper.Set("database.params", map[string]string{"time_zone": "'+00:00'"})
+
+	cfg, err := db.FromConfig()
+	if err != nil {
+		t.Fatalf("could not read configuration - %v", err)
+	}
+
+	c, ok := cfg.(mysql.Configuration)
+	if !ok {
+		t.Fatalf("expected a mysql configuration, got %T", cfg)
+	}
+
+	if c.Port() != mysql.DefaultPort || c.Dialect() != db.MySQL || c.Driver() != "mysql" {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+
+	expected := "orders@tcp(db.local:3306)/orders?parseTime=true&time_zone=%27%2B00%3A00%27"
+
+	if dsn := c.DSN(); dsn != expected {
+		t.Errorf("expected %s, got %s", expected, dsn)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &driver.MySQLError{Number: 1213, Message: "Deadlock found"}, retryable: true},
+		{err: fmt.Errorf("transfer: %w", &driver.MySQLError{Number: 1213}), retryable: true},
+		{err: &driver.MySQLError{Number: 1062, Message: "Duplicate entry"}, retryable: false},
+		{err: fmt.Errorf("connection refused"), retryable: false},
+	}
+
+	for _, test := range tests {
+		if mysql.IsRetryable(test.err) != test.retryable {
+			t.Errorf("expected %v to be retryable: %v", test.err, test.retryable)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+// DefaultTable is the table events are written to
+const DefaultTable = "outbox"
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Execer executes statements, it is implemented by *sql.DB, *sql.Tx, *sql.Conn and *db.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Message is an event waiting in the outbox to be published
+type Message struct {
+	// Sequence is the position of the message in the outbox, messages for an aggregate are published in sequence order
+	Sequence int64
+	// Aggregate identifies the entity the event belongs to, e.g. the id of the state machine that produced it
+	Aggregate string
+	// Event is the event to publish
+	Event fsm.Event
+	// CreatedAt is the time the event was added to the outbox as nanoseconds past epoch
+	CreatedAt int64
+	// Attempts is the number of times publishing the event has failed
+	Attempts int
+}
+
+// Outbox writes events to a table in the same transaction as the changes that produced them, so the events are
+// published if, and only if, the transaction commits. A Relay reads the events from the table and publishes them
+type Outbox struct {
+	table   string
+	dialect db.Dialect
+	codec   fsm.EventCodec
+}
+
+// Option sets an optional setting on the outbox
+type Option func(*Outbox)
+
+// WithTable sets the table events are written to
+func WithTable(table string) Option {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// New creates an outbox for a database with the given dialect, using the codec to encode the events, e.g. an
+// fsm.Registry
+func New(dialect db.Dialect, codec fsm.EventCodec, opts ...Option) (*Outbox, error) {
+	if codec == nil {
+		return nil, errors.New("a codec is required to write events to the outbox")
+	}
+
+	o := &Outbox{table: DefaultTable, dialect: dialect, codec: codec}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if !tableName.MatchString(o.table) {
+		return nil, fmt.Errorf("invalid outbox table name %s", o.table)
+	}
+
+	return o, nil
+}
+
+// Table returns the name of the table events are written to
+func (o *Outbox) Table() string {
+	return o.table
+}
+
+// Schema returns the statements that create the outbox table and the index the relay uses to keep the messages for an
+// aggregate in order, they can be added to your migrations or run with CreateTable
+func (o *Outbox) Schema() string {
+	return strings.Join(o.statements(), ";\n\n") + ";"
+}
+
+func (o *Outbox) statements() []string {
+	schema, table := "", o.table
+	if i := strings.Index(o.table, "."); i >= 0 {
+		schema, table = o.table[:i+1], o.table[i+1:]
+	}
+
+	name := table + "_aggregate_idx"
+
+	// postgres creates the index in the table's schema
+	id, payload, inline := "BIGSERIAL PRIMARY KEY", "BYTEA", ""
+	index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (aggregate, id)", name, o.table)
+
+	switch o.dialect.Name() {
+	case db.MySQL.Name():
+		// MySQL cannot create an index only if it does not exist, so it is declared with the table
+		id, payload, index = "BIGINT AUTO_INCREMENT PRIMARY KEY", "LONGBLOB", ""
+		inline = fmt.Sprintf(",\n\tINDEX %s (aggregate, id)", name)
+	case db.SQLite.Name():
+		// SQLite names the schema on the index rather than the table
+		id, payload = "INTEGER PRIMARY KEY AUTOINCREMENT", "BLOB"
+		index = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s%s ON %s (aggregate, id)", schema, name, table)
+	}
+
+	statements := []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	event_id VARCHAR(36) NOT NULL,
+	aggregate VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	payload %s NOT NULL,
+	created_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	delivered_at BIGINT%s
+)`, o.table, id, payload, inline)}
+
+	if index != "" {
+		statements = append(statements, index)
+	}
+
+	return statements
+}
+
+// CreateTable creates the outbox table and its index if they do not exist
+func (o *Outbox) CreateTable(ctx context.Context, exec Execer) error {
+	for _, statement := range o.statements() {
+		if _, err := exec.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("could not create outbox table %s: %w", o.table, err)
+		}
+	}
+
+	return nil
+}
+
+// Add writes the events for the aggregate to the outbox, exec should be the transaction that writes the changes
+// that produced the events. The events are published in the order they are added
+func (o *Outbox) Add(ctx context.Context, exec Execer, aggregate string, events ...fsm.Event) error {
+	insert := fmt.Sprintf("INSERT INTO %s (event_id, aggregate, name, payload, created_at) VALUES (%s, %s, %s, %s, %s)",
+		o.table, o.placeholder(1), o.placeholder(2), o.placeholder(3), o.placeholder(4), o.placeholder(5))
+
+	for _, e := range events {
+		payload, err := o.codec.Encode(e)
+		if err != nil {
+			return fmt.Errorf("could not encode event %s: %w", e.ID(), err)
+		}
+
+		if _, err := exec.ExecContext(ctx, insert, e.ID().String(), aggregate, e.Name(), payload, time.Now().UnixNano()); err != nil {
+			return fmt.Errorf("could not add event %s to the outbox: %w", e.ID(), err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Outbox) placeholder(n int) string {
+	return o.dialect.Placeholder(n)
+}
+
+// row is a message as it is stored in the outbox table
+type row struct {
+	sequence  int64
+	eventID   uuid.UUID
+	aggregate string
+	payload   []byte
+	createdAt int64
+	attempts  int
+	// err is set if the row cannot be published, e.g. because its event id is malformed
+	err error
+}
+
+func (o *Outbox) message(r row) (Message, error) {
+	if r.err != nil {
+		return Message{}, r.err
+	}
+
+	event, err := o.codec.Decode(r.payload)
+	if err != nil {
+		return Message{}, fmt.Errorf("could not decode event %s: %w", r.eventID, err)
+	}
+
+	return Message{
+		Sequence:  r.sequence,
+		Aggregate: r.aggregate,
+		Event:     event,
+		CreatedAt: r.createdAt,
+		Attempts:  r.attempts,
+	}, nil
+}
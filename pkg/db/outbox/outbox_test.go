@@ -0,0 +1,338 @@
+//go:build cgo
+// +build cgo
+
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	"github.com/birchwood-langham/bootstrap/pkg/db/outbox"
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+type orderPlaced struct {
+	id      uuid.UUID
+	OrderID string `json:"order-id"`
+}
+
+func (e orderPlaced) ID() uuid.UUID                   { return e.id }
+func (e orderPlaced) Source() string                  { return "orders" }
+func (e orderPlaced) Name() string                    { return "order-placed" }
+func (e orderPlaced) Timestamp() int64                { return 0 }
+func (e orderPlaced) MarshalPayload() ([]byte, error) { return json.Marshal(e) }
+
+func placed(order string) fsm.Event {
+	return orderPlaced{id: uuid.New(), OrderID: order}
+}
+
+func setup(t *testing.T) (*sql.DB, *outbox.Outbox) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatalf("could not open database - %v", err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	registry := fsm.NewRegistry(fsm.JSONCodec)
+
+	err = registry.Register("order-placed", 1, func(env fsm.Envelope) (fsm.Event, error) {
+		e := orderPlaced{id: env.ID}
+		if err := json.Unmarshal(env.Payload, &e); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	})
+
+	if err != nil {
+		t.Fatalf("could not register event - %v", err)
+	}
+
+	o, err := outbox.New(db.SQLite, registry)
+	if err != nil {
+		t.Fatalf("could not create outbox - %v", err)
+	}
+
+	if err := o.CreateTable(context.Background(), conn); err != nil {
+		t.Fatalf("could not create outbox table - %v", err)
+	}
+
+	return conn, o
+}
+
+func count(t *testing.T, conn *sql.DB, where string) int {
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM outbox WHERE " + where).Scan(&n); err != nil {
+		t.Fatalf("could not count outbox messages - %v", err)
+	}
+
+	return n
+}
+
+func TestOutbox_Add(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	// events added in a transaction that rolls back are never published
+	_ = db.WithTx(ctx, conn, nil, func(ctx context.Context, tx *db.Tx) error {
+		if err := o.Add(ctx, tx, "order-1", placed("1")); err != nil {
+			return err
+		}
+
+		return errors.New("payment declined")
+	})
+
+	err := db.WithTx(ctx, conn, nil, func(ctx context.Context, tx *db.Tx) error {
+		return o.Add(ctx, tx, "order-2", placed("2"))
+	})
+
+	if err != nil {
+		t.Fatalf("could not add event - %v", err)
+	}
+
+	if n := count(t, conn, "1 = 1"); n != 1 {
+		t.Errorf("expected only the committed event to be in the outbox, got %d", n)
+	}
+}
+
+func TestRelay_Poll(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	events := []fsm.Event{placed("1a"), placed("2a"), placed("1b"), placed("2b")}
+
+	for i, e := range events {
+		aggregate := []string{"order-1", "order-2"}[i%2]
+
+		if err := o.Add(ctx, conn, aggregate, e); err != nil {
+			t.Fatalf("could not add event - %v", err)
+		}
+	}
+
+	published := make([]string, 0)
+	failing := "2a"
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(_ context.Context, m outbox.Message) error {
+		order := m.Event.(orderPlaced).OrderID
+
+		if order == failing {
+			return errors.New("broker unavailable")
+		}
+
+		published = append(published, order)
+		return nil
+	}))
+
+	n, err := relay.Poll(ctx)
+	if err != nil {
+		t.Fatalf("could not poll outbox - %v", err)
+	}
+
+	// the failed message holds back the later message for its aggregate
+	if want := []string{"1a", "1b"}; n != 2 || !reflect.DeepEqual(published, want) {
+		t.Fatalf("expected %v to be published, got %v", want, published)
+	}
+
+	if n := count(t, conn, "attempts = 1 AND last_error = 'broker unavailable'"); n != 1 {
+		t.Errorf("expected the failure to be recorded")
+	}
+
+	failing = ""
+
+	if _, err := relay.Poll(ctx); err != nil {
+		t.Fatalf("could not poll outbox - %v", err)
+	}
+
+	if want := []string{"1a", "1b", "2a", "2b"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("expected %v to be published, got %v", want, published)
+	}
+
+	if n := count(t, conn, "1 = 1"); n != 0 {
+		t.Errorf("expected published messages to be deleted, got %d", n)
+	}
+}
+
+func TestRelay_FailingAggregate(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	for _, e := range []struct{ aggregate, order string }{{"order-1", "1a"}, {"order-1", "1b"}, {"order-2", "2a"}, {"order-2", "2b"}} {
+		if err := o.Add(ctx, conn, e.aggregate, placed(e.order)); err != nil {
+			t.Fatalf("could not add event - %v", err)
+		}
+	}
+
+	published := make([]string, 0)
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(_ context.Context, m outbox.Message) error {
+		order := m.Event.(orderPlaced).OrderID
+
+		if order == "1a" {
+			return errors.New("broker unavailable")
+		}
+
+		published = append(published, order)
+		return nil
+	}), outbox.WithBatchSize(1))
+
+	for i := 0; i < 4; i++ {
+		if _, err := relay.Poll(ctx); err != nil {
+			t.Fatalf("could not poll outbox - %v", err)
+		}
+	}
+
+	// the failing message holds back its own aggregate but not the others
+	if want := []string{"2a", "2b"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("expected %v to be published, got %v", want, published)
+	}
+}
+
+func TestRelay_MaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	if _, err := conn.Exec("INSERT INTO outbox (event_id, aggregate, name, payload, created_at) VALUES (?, ?, ?, ?, ?)",
+		uuid.New().String(), "order-1", "order-placed", []byte("not an event"), time.Now().UnixNano()); err != nil {
+		t.Fatalf("could not add undecodable message - %v", err)
+	}
+
+	_ = o.Add(ctx, conn, "order-1", placed("1"))
+
+	published := make([]string, 0)
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(_ context.Context, m outbox.Message) error {
+		published = append(published, m.Event.(orderPlaced).OrderID)
+		return nil
+	}), outbox.WithMaxAttempts(2))
+
+	for i := 0; i < 3; i++ {
+		if _, err := relay.Poll(ctx); err != nil {
+			t.Fatalf("could not poll outbox - %v", err)
+		}
+	}
+
+	if want := []string{"1"}; !reflect.DeepEqual(published, want) {
+		t.Errorf("expected the parked message to stop holding back its aggregate, published %v", published)
+	}
+
+	if n := count(t, conn, "attempts = 2 AND delivered_at IS NULL"); n != 1 {
+		t.Errorf("expected the undecodable message to be parked after 2 attempts")
+	}
+}
+
+func TestRelay_MalformedEventID(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	if _, err := conn.Exec("INSERT INTO outbox (event_id, aggregate, name, payload, created_at) VALUES (?, ?, ?, ?, ?)",
+		"not-a-uuid", "order-1", "order-placed", []byte("{}"), time.Now().UnixNano()); err != nil {
+		t.Fatalf("could not add message with a malformed id - %v", err)
+	}
+
+	_ = o.Add(ctx, conn, "order-1", placed("1"))
+
+	published := 0
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(context.Context, outbox.Message) error {
+		published++
+		return nil
+	}), outbox.WithMaxAttempts(1))
+
+	for i := 0; i < 2; i++ {
+		if _, err := relay.Poll(ctx); err != nil {
+			t.Fatalf("could not poll outbox - %v", err)
+		}
+	}
+
+	if published != 1 {
+		t.Errorf("expected only the valid message to be published, got %d", published)
+	}
+
+	if n := count(t, conn, "event_id = 'not-a-uuid' AND attempts = 1 AND last_error LIKE 'invalid event id%'"); n != 1 {
+		t.Errorf("expected the message with a malformed id to be parked")
+	}
+}
+
+func TestOutbox_Schema(t *testing.T) {
+	conn, _ := setup(t)
+
+	var name string
+	if err := conn.QueryRow("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'outbox'").Scan(&name); err != nil {
+		t.Errorf("expected the outbox table to be indexed by aggregate - %v", err)
+	}
+
+	o, _ := outbox.New(db.MySQL, fsm.NewRegistry(fsm.JSONCodec), outbox.WithTable("events.outbox"))
+
+	if schema := o.Schema(); !strings.Contains(schema, "INDEX outbox_aggregate_idx (aggregate, id)") {
+		t.Errorf("expected the MySQL table to declare its index, got %s", schema)
+	}
+}
+
+func TestRelay_Retention(t *testing.T) {
+	ctx := context.Background()
+	conn, o := setup(t)
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(context.Context, outbox.Message) error { return nil }),
+		outbox.WithRetention(50*time.Millisecond),
+	)
+
+	_ = o.Add(ctx, conn, "order-1", placed("1"))
+
+	if n, err := relay.Poll(ctx); n != 1 || err != nil {
+		t.Fatalf("expected 1 message to be published, got %d - %v", n, err)
+	}
+
+	if n := count(t, conn, "delivered_at IS NOT NULL"); n != 1 {
+		t.Errorf("expected the published message to be kept")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := relay.Poll(ctx); err != nil {
+		t.Fatalf("could not poll outbox - %v", err)
+	}
+
+	if n := count(t, conn, "1 = 1"); n != 0 {
+		t.Errorf("expected the published message to be deleted after the retention period, got %d", n)
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	conn, o := setup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan outbox.Message, 1)
+
+	relay := outbox.NewRelay(o, conn, outbox.PublisherFunc(func(_ context.Context, m outbox.Message) error {
+		received <- m
+		return nil
+	}), outbox.WithPollInterval(10*time.Millisecond))
+
+	go relay.Run(ctx)
+
+	e := placed("1")
+	_ = o.Add(ctx, conn, "order-1", e)
+
+	select {
+	case m := <-received:
+		if m.Event.ID() != e.ID() || m.Aggregate != "order-1" {
+			t.Errorf("unexpected message %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the relay to publish the event")
+	}
+}
@@ -0,0 +1,286 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+const (
+	// DefaultBatchSize is the maximum number of messages the relay reads from the outbox at a time
+	DefaultBatchSize = 100
+	// DefaultPollInterval is the time the relay waits before polling an outbox that had no messages to publish
+	DefaultPollInterval = time.Second
+)
+
+// Publisher forwards messages from the outbox to a message broker or other consumers. A message is removed from the
+// outbox once it has been published, a message that fails to publish is retried when the outbox is next polled
+type Publisher interface {
+	Publish(ctx context.Context, m Message) error
+}
+
+// PublisherFunc is a function that implements Publisher
+type PublisherFunc func(ctx context.Context, m Message) error
+
+func (f PublisherFunc) Publish(ctx context.Context, m Message) error {
+	return f(ctx, m)
+}
+
+// Relay polls the outbox and publishes its messages. Delivery is at-least-once, a message can be published again if
+// the relay stops after publishing it but before recording that it was published, so consumers should ignore events
+// whose ids they have already seen. Messages for an aggregate are published in the order they were added, a message
+// that fails to publish holds back the later messages for its aggregate until it succeeds or is parked, messages for
+// other aggregates are not held back. Messages that have not been tried are read before those being retried, and
+// messages held back by a failure are read last.
+// Relays hold a lock while polling, so more than one instance of a service can run a relay for the same outbox
+type Relay struct {
+	outbox    *Outbox
+	db        *sql.DB
+	publisher Publisher
+
+	batchSize   int
+	interval    time.Duration
+	retention   time.Duration
+	maxAttempts int
+}
+
+// RelayOption sets an optional setting on the relay
+type RelayOption func(*Relay)
+
+// WithBatchSize sets the maximum number of messages read from the outbox at a time
+func WithBatchSize(n int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithPollInterval sets the time the relay waits before polling an outbox that had no messages to publish
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.interval = d
+	}
+}
+
+// WithRetention keeps published messages in the outbox for the given time before they are deleted, they are
+// deleted as soon as they are published if it is zero
+func WithRetention(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.retention = d
+	}
+}
+
+// WithMaxAttempts parks a message once it has failed to publish the given number of times, such as a message that can
+// no longer be decoded. Parked messages are kept in the outbox with their last error but are not published, and no
+// longer hold back the later messages for their aggregate. Messages are retried until they succeed if it is zero
+func WithMaxAttempts(n int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// NewRelay creates a relay that publishes the messages in the outbox table of the database
+func NewRelay(o *Outbox, conn *sql.DB, p Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		outbox:    o,
+		db:        conn,
+		publisher: p,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultPollInterval,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Run polls the outbox until the context ends, errors are logged and polling continues
+func (r *Relay) Run(ctx context.Context) {
+	l := logger.Logger()
+
+	for {
+		published, err := r.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			l.Error("Could not relay outbox messages", zap.String("table", r.outbox.table), zap.Error(err))
+		}
+
+		// a full batch means there are probably more messages waiting
+		wait := r.interval
+		if err == nil && published == r.batchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Poll publishes a batch of messages from the outbox, deletes published messages that are older than the retention
+// period and returns the number of messages that were published
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	lock := "outbox:" + r.outbox.table
+
+	if err := r.outbox.dialect.Lock(ctx, conn, lock); err != nil {
+		return 0, fmt.Errorf("could not lock outbox: %w", err)
+	}
+
+	defer func() {
+		if err := r.outbox.dialect.Unlock(context.Background(), conn, lock); err != nil {
+			logger.Logger().Error("Could not unlock outbox", zap.Error(err))
+		}
+	}()
+
+	rows, err := r.pending(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	blocked := make(map[string]bool)
+
+	for _, row := range rows {
+		if blocked[row.aggregate] {
+			continue
+		}
+
+		if err := r.publish(ctx, row); err != nil {
+			blocked[row.aggregate] = true
+
+			logger.Logger().Warn("Could not publish outbox message", zap.Int64("sequence", row.sequence),
+				zap.String("event-id", row.eventID.String()), zap.String("aggregate", row.aggregate), zap.Error(err))
+
+			if ferr := r.failed(ctx, conn, row, err); ferr != nil {
+				return published, ferr
+			}
+
+			if r.maxAttempts > 0 && row.attempts+1 >= r.maxAttempts {
+				logger.Logger().Error("Parked outbox message", zap.Int64("sequence", row.sequence),
+					zap.String("event-id", row.eventID.String()), zap.String("aggregate", row.aggregate),
+					zap.Int("attempts", row.attempts+1), zap.Error(err))
+			}
+
+			continue
+		}
+
+		if err := r.delivered(ctx, conn, row); err != nil {
+			return published, err
+		}
+
+		published++
+	}
+
+	return published, r.cleanup(ctx, conn)
+}
+
+// pending reads the messages that can be published. Messages held back by an earlier failed message for their
+// aggregate are read last, so a message that keeps failing cannot fill every batch and stall the other aggregates
+func (r *Relay) pending(ctx context.Context, conn *sql.Conn) ([]row, error) {
+	retrying := "p.attempts > 0"
+	unparked := ""
+
+	if r.maxAttempts > 0 {
+		retrying = fmt.Sprintf("p.attempts > 0 AND p.attempts < %d", r.maxAttempts)
+		unparked = fmt.Sprintf(" AND o.attempts < %d", r.maxAttempts)
+	}
+
+	query := fmt.Sprintf("SELECT o.id, o.event_id, o.aggregate, o.payload, o.created_at, o.attempts FROM %s o "+
+		"WHERE o.delivered_at IS NULL%s ORDER BY CASE WHEN EXISTS (SELECT 1 FROM %s p WHERE p.aggregate = o.aggregate "+
+		"AND p.delivered_at IS NULL AND p.id < o.id AND %s) THEN 1 ELSE 0 END, o.attempts, o.id LIMIT %d",
+		r.outbox.table, unparked, r.outbox.table, retrying, r.batchSize)
+
+	result, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not read outbox: %w", err)
+	}
+	defer result.Close()
+
+	rows := make([]row, 0)
+
+	for result.Next() {
+		var rw row
+		var eventID string
+
+		if err := result.Scan(&rw.sequence, &eventID, &rw.aggregate, &rw.payload, &rw.createdAt, &rw.attempts); err != nil {
+			return nil, fmt.Errorf("could not read outbox: %w", err)
+		}
+
+		// a row with a malformed id fails to publish, so it holds back its aggregate until it is parked
+		if rw.eventID, err = uuid.Parse(eventID); err != nil {
+			rw.err = fmt.Errorf("invalid event id %q in outbox message %d: %w", eventID, rw.sequence, err)
+		}
+
+		rows = append(rows, rw)
+	}
+
+	return rows, result.Err()
+}
+
+func (r *Relay) publish(ctx context.Context, rw row) error {
+	m, err := r.outbox.message(rw)
+	if err != nil {
+		return err
+	}
+
+	return r.publisher.Publish(ctx, m)
+}
+
+func (r *Relay) failed(ctx context.Context, conn *sql.Conn, rw row, cause error) error {
+	update := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s WHERE id = %s",
+		r.outbox.table, r.outbox.placeholder(1), r.outbox.placeholder(2))
+
+	if _, err := conn.ExecContext(ctx, update, cause.Error(), rw.sequence); err != nil {
+		return fmt.Errorf("could not record failure to publish message %d: %w", rw.sequence, err)
+	}
+
+	return nil
+}
+
+func (r *Relay) delivered(ctx context.Context, conn *sql.Conn, rw row) error {
+	var err error
+
+	if r.retention > 0 {
+		update := fmt.Sprintf("UPDATE %s SET delivered_at = %s WHERE id = %s",
+			r.outbox.table, r.outbox.placeholder(1), r.outbox.placeholder(2))
+		_, err = conn.ExecContext(ctx, update, time.Now().UnixNano(), rw.sequence)
+	} else {
+		remove := fmt.Sprintf("DELETE FROM %s WHERE id = %s", r.outbox.table, r.outbox.placeholder(1))
+		_, err = conn.ExecContext(ctx, remove, rw.sequence)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not record message %d as published: %w", rw.sequence, err)
+	}
+
+	return nil
+}
+
+func (r *Relay) cleanup(ctx context.Context, conn *sql.Conn) error {
+	if r.retention <= 0 {
+		return nil
+	}
+
+	remove := fmt.Sprintf("DELETE FROM %s WHERE delivered_at IS NOT NULL AND delivered_at < %s",
+		r.outbox.table, r.outbox.placeholder(1))
+
+	if _, err := conn.ExecContext(ctx, remove, time.Now().Add(-r.retention).UnixNano()); err != nil {
+		return fmt.Errorf("could not delete published messages: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package pg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+// NewComponent creates a db.Component that connects to the postgres database
+func NewComponent(cfg Configuration, opts ...db.ComponentOption) *db.Component {
+	return db.NewComponent(cfg, opts...)
+}
+
+// ComponentFromConfig creates a db.Component using the postgres connection settings and the pool settings under the given
+// configuration path, the settings are read from the database section if no path is given. An error is returned if the
+// settings name a driver other than postgres
+func ComponentFromConfig(path ...string) (*db.Component, error) {
+	section := path
+	if len(section) == 0 {
+		section = []string{config.DatabaseKey}
+	}
+
+	if driver := config.Get(append(append([]string{}, section...), "driver")...).String(DefaultDriver); driver != DefaultDriver {
+		return nil, fmt.Errorf("database driver %s configured in %s is not %s", driver, strings.Join(section, "."), DefaultDriver)
+	}
+
+	cfg, err := FromConfig(path...)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.NewComponent(cfg, db.ComponentOptionsFromConfig(path...)...), nil
+}
@@ -1,24 +1,348 @@
 package pg
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+const (
+	// DefaultDriver is the database/sql driver used to connect, it is registered by lib/pq
+	DefaultDriver = "postgres"
+	// DefaultHost is the host used when no host has been configured
+	DefaultHost = "localhost"
+	// DefaultPort is the port used when no port has been configured
+	DefaultPort = 5432
+	// DefaultSslMode is the ssl mode used when no ssl mode has been configured, it matches the default used by lib/pq
+	DefaultSslMode = "require"
+)
+
+var _ db.Configuration = Configuration{}
+
+// pq errors report their SQLSTATE, so db.WithTx retries serialization failures and deadlocks
+var _ db.SQLStateError = (*pq.Error)(nil)
+
+func init() {
+	db.RegisterDriver(DefaultDriver, func(path ...string) (db.Configuration, error) {
+		return FromConfig(path...)
+	})
+}
 
 type Configuration struct {
+	driver   string
 	host     string
 	port     int
 	user     string
 	password string
 	database string
 	sslMode  string
+
+	connectTimeout   time.Duration
+	applicationName  string
+	searchPath       []string
+	statementTimeout time.Duration
+	sslCert          string
+	sslKey           string
+	sslRootCert      string
+	params           map[string]string
+}
+
+// Option sets an optional connection setting on the configuration
+type Option func(*Configuration)
+
+// WithDriver sets the database/sql driver used to connect, the driver must accept postgres:// URLs and be registered
+func WithDriver(driver string) Option {
+	return func(c *Configuration) {
+		c.driver = driver
+	}
+}
+
+// WithConnectTimeout sets the maximum time to wait while connecting, it is rounded up to whole seconds
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.connectTimeout = d
+	}
+}
+
+// WithApplicationName sets the application name reported to the server
+func WithApplicationName(name string) Option {
+	return func(c *Configuration) {
+		c.applicationName = name
+	}
+}
+
+// WithSearchPath sets the schemas searched for unqualified names
+func WithSearchPath(schemas ...string) Option {
+	return func(c *Configuration) {
+		c.searchPath = schemas
+	}
+}
+
+// WithStatementTimeout sets the maximum time a statement may run before the server cancels it
+func WithStatementTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.statementTimeout = d
+	}
+}
+
+// WithSSLCert sets the paths of the client certificate and its key
+func WithSSLCert(cert, key string) Option {
+	return func(c *Configuration) {
+		c.sslCert = cert
+		c.sslKey = key
+	}
+}
+
+// WithSSLRootCert sets the path of the root certificate used to verify the server
+func WithSSLRootCert(path string) Option {
+	return func(c *Configuration) {
+		c.sslRootCert = path
+	}
 }
 
+// WithParam adds a connection parameter that has no option of its own
+func WithParam(key, value string) Option {
+	return func(c *Configuration) {
+		if c.params == nil {
+			c.params = make(map[string]string)
+		}
+
+		c.params[key] = value
+	}
+}
+
+func NewConfiguration(host string, port int, user string, password string, database string, sslMode string, opts ...Option) Configuration {
+	c := Configuration{
+		driver:   DefaultDriver,
+		host:     host,
+		port:     port,
+		user:     user,
+		password: password,
+		database: database,
+		sslMode:  sslMode,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// FromConfig creates the configuration from the settings under the given configuration path, the settings are read from
+// the database section if no path is given. E.g.
+//
+//	database:
+//	    host: localhost
+//	    port: 5432
+//	    user: postgres
+//	    password: secret
+//	    name: orders
+//	    sslmode: verify-full
+//	    connect-timeout: 10s
+//	    application-name: orders-service
+//	    search-path: [orders, public]
+//	    statement-timeout: 30s
+//	    sslcert: /etc/orders/client.crt
+//	    sslkey: /etc/orders/client.key
+//	    sslrootcert: /etc/orders/root.crt
+//	    params:
+//	        target_session_attrs: read-write
+//
+// An error is returned if a password is configured without a user, as the password cannot be sent without one
+func FromConfig(path ...string) (Configuration, error) {
+	if len(path) == 0 {
+		path = []string{config.DatabaseKey}
+	}
+
+	get := func(key string) *config.Config {
+		return config.Get(append(append([]string{}, path...), key)...)
+	}
+
+	c := NewConfiguration(
+		get("host").String(DefaultHost),
+		get("port").Int(DefaultPort),
+		get("user").String(""),
+		get("password").String(""),
+		get("name").String(""),
+		get("sslmode").String(DefaultSslMode),
+		WithConnectTimeout(get("connect-timeout").Duration(0)),
+		WithApplicationName(get("application-name").String(config.Get(config.ServiceNameKey).String(""))),
+		WithSearchPath(get("search-path").StringSlice(nil)...),
+		WithStatementTimeout(get("statement-timeout").Duration(0)),
+		WithSSLCert(get("sslcert").String(""), get("sslkey").String("")),
+		WithSSLRootCert(get("sslrootcert").String("")),
+	)
+
+	for k, v := range get("params").StringMapString(nil) {
+		WithParam(k, v)(&c)
+	}
+
+	if c.user == "" && c.password != "" {
+		return Configuration{}, fmt.Errorf("database password configured in %s without a user", strings.Join(path, "."))
+	}
+
+	return c, nil
+}
+
+// Driver returns the name of the database/sql driver used to connect
+func (c Configuration) Driver() string {
+	return c.driver
+}
+
+// DSN returns the configuration as a postgres:// URL
+func (c Configuration) DSN() string {
+	return c.PgConnectionString()
+}
+
+// Dialect returns the postgres dialect
+func (c Configuration) Dialect() db.Dialect {
+	return db.Postgres
+}
+
+// PgConnectionString returns the configuration as a postgres:// URL, with every component escaped. The password is
+// only included along with a user
 func (c Configuration) PgConnectionString() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s", c.user, c.password, c.host, c.port, c.database, c.sslMode)
+	u := url.URL{
+		Scheme: "postgres",
+		Path:   "/" + c.database,
+		// the database name is escaped as a single path segment so a / in the name is preserved
+		RawPath: "/" + url.PathEscape(c.database),
+	}
+
+	if c.user != "" {
+		if c.password != "" {
+			u.User = url.UserPassword(c.user, c.password)
+		} else {
+			u.User = url.User(c.user)
+		}
+	}
+
+	q := url.Values{}
+
+	// unix socket directories cannot be part of the URL's host
+	if strings.HasPrefix(c.host, "/") {
+		q.Set("host", c.host)
+		q.Set("port", strconv.Itoa(c.port))
+	} else {
+		u.Host = net.JoinHostPort(c.host, strconv.Itoa(c.port))
+	}
+
+	for _, p := range c.parameters() {
+		q.Set(p[0], p[1])
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String()
 }
 
-func NewConfiguration(host string, port int, user string, password string, database string, sslMode string) Configuration {
-	return Configuration{
-		host, port, user, password, database, sslMode,
+// PgKeywordValueString returns the configuration as a keyword/value connection string, e.g.
+// host=localhost port=5432 user=postgres password='a secret' dbname=orders sslmode=require
+func (c Configuration) PgKeywordValueString() string {
+	pairs := [][2]string{
+		{"host", c.host},
+		{"port", strconv.Itoa(c.port)},
+		{"user", c.user},
+		{"password", c.password},
+		{"dbname", c.database},
 	}
+
+	parts := make([]string, 0, len(pairs))
+
+	for _, p := range append(pairs, c.parameters()...) {
+		if p[1] == "" {
+			continue
+		}
+
+		parts = append(parts, p[0]+"="+quoteValue(p[1]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// parameters returns the connection parameters other than the host, port, user, password and database, extra
+// parameters are sorted by key and cannot override the configured settings
+func (c Configuration) parameters() [][2]string {
+	params := make([][2]string, 0)
+
+	add := func(k, v string) {
+		if v != "" {
+			params = append(params, [2]string{k, v})
+		}
+	}
+
+	add("sslmode", c.sslMode)
+	add("sslcert", c.sslCert)
+	add("sslkey", c.sslKey)
+	add("sslrootcert", c.sslRootCert)
+
+	if c.connectTimeout > 0 {
+		add("connect_timeout", strconv.Itoa(int(math.Ceil(c.connectTimeout.Seconds()))))
+	}
+
+	add("application_name", c.applicationName)
+
+	// the search path and statement timeout are set as server options when the session starts
+	options := make([]string, 0)
+
+	if len(c.searchPath) > 0 {
+		options = append(options, "-c search_path="+escapeOption(strings.Join(c.searchPath, ",")))
+	}
+
+	if c.statementTimeout > 0 {
+		options = append(options, fmt.Sprintf("-c statement_timeout=%d", c.statementTimeout.Milliseconds()))
+	}
+
+	add("options", strings.Join(options, " "))
+
+	reserved := make(map[string]bool)
+	for _, p := range params {
+		reserved[p[0]] = true
+	}
+
+	for _, k := range []string{"host", "port", "user", "password", "dbname"} {
+		reserved[k] = true
+	}
+
+	keys := make([]string, 0, len(c.params))
+	for k := range c.params {
+		if !reserved[k] {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		add(k, c.params[k])
+	}
+
+	return params
+}
+
+// quoteValue quotes a keyword/value connection string value if it is empty or contains spaces, quotes or backslashes
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " '\\\t\n") {
+		return v
+	}
+
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// escapeOption escapes the spaces and backslashes in a server option value
+func escapeOption(v string) string {
+	return strings.NewReplacer(`\`, `\\`, ` `, `\ `).Replace(v)
 }
 
 func (c Configuration) Host() string {
@@ -44,3 +368,36 @@ func (c Configuration) Database() string {
 func (c Configuration) SslMode() string {
 	return c.sslMode
 }
+
+func (c Configuration) ConnectTimeout() time.Duration {
+	return c.connectTimeout
+}
+
+func (c Configuration) ApplicationName() string {
+	return c.applicationName
+}
+
+func (c Configuration) SearchPath() []string {
+	return c.searchPath
+}
+
+func (c Configuration) StatementTimeout() time.Duration {
+	return c.statementTimeout
+}
+
+func (c Configuration) SslCert() string {
+	return c.sslCert
+}
+
+func (c Configuration) SslKey() string {
+	return c.sslKey
+}
+
+func (c Configuration) SslRootCert() string {
+	return c.sslRootCert
+}
+
+// Params returns the extra connection parameters
+func (c Configuration) Params() map[string]string {
+	return c.params
+}
@@ -0,0 +1,51 @@
+//go:build cgo
+// +build cgo
+
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+	_ "github.com/birchwood-langham/bootstrap/pkg/db/sqlite"
+)
+
+func TestComponentFromConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("database.driver", "sqlite")
+	viper.Set("database.path", filepath.Join(t.TempDir(), "orders.db"))
+	viper.Set("database.max-open-conns", 1)
+
+	c, err := db.ComponentFromConfig()
+	if err != nil {
+		t.Fatalf("could not create component - %v", err)
+	}
+
+	if err := c.Init(context.Background(), nil); err != nil {
+		t.Fatalf("could not initialize component - %v", err)
+	}
+
+	defer func() { _ = c.Cleanup(nil) }()
+
+	if stats, _ := c.Stats(); stats.MaxOpenConnections != 1 {
+		t.Errorf("expected the pool settings to be read from the configuration, got %+v", stats)
+	}
+
+	err = db.WithTx(context.Background(), c.DB(), nil, func(ctx context.Context, tx *db.Tx) error {
+		if _, err := tx.ExecContext(ctx, "CREATE TABLE orders (id INTEGER PRIMARY KEY)"); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO orders (id) VALUES (1)")
+		return err
+	})
+
+	if err != nil {
+		t.Fatalf("could not write to the database - %v", err)
+	}
+}
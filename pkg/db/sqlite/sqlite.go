@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+const (
+	// DefaultDriver is the database/sql driver used to connect, it is registered by mattn/go-sqlite3
+	DefaultDriver = "sqlite3"
+	// DriverName is the name the configuration is registered under, it is the driver setting used to select SQLite
+	DriverName = "sqlite"
+	// DefaultBusyTimeout is the time a connection waits for a lock held by another connection
+	DefaultBusyTimeout = 5 * time.Second
+)
+
+var _ db.Configuration = Configuration{}
+
+func init() {
+	loader := func(path ...string) (db.Configuration, error) {
+		return FromConfig(path...), nil
+	}
+
+	db.RegisterDriver(DriverName, loader)
+	db.RegisterDriver(DefaultDriver, loader)
+}
+
+// Configuration is the connection configuration for a SQLite database file
+type Configuration struct {
+	path        string
+	memory      bool
+	busyTimeout time.Duration
+	foreignKeys bool
+	params      map[string]string
+}
+
+// Option sets an optional connection setting on the configuration
+type Option func(*Configuration)
+
+// WithBusyTimeout sets the time a connection waits for a lock held by another connection
+func WithBusyTimeout(d time.Duration) Option {
+	return func(c *Configuration) {
+		c.busyTimeout = d
+	}
+}
+
+// WithForeignKeys enables or disables foreign key constraints, they are enabled by default
+func WithForeignKeys(enabled bool) Option {
+	return func(c *Configuration) {
+		c.foreignKeys = enabled
+	}
+}
+
+// WithParam adds a connection parameter that has no option of its own
+func WithParam(key, value string) Option {
+	return func(c *Configuration) {
+		if c.params == nil {
+			c.params = make(map[string]string)
+		}
+
+		c.params[key] = value
+	}
+}
+
+// NewConfiguration creates the configuration for the database file at the given path, the file is created if it
+// does not exist
+func NewConfiguration(path string, opts ...Option) Configuration {
+	c := Configuration{path: path, busyTimeout: DefaultBusyTimeout, foreignKeys: true}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// InMemory creates the configuration for an in-memory database with the given name, the database is shared by
+// every connection in the pool and removed when the last connection is closed. In-memory databases are useful for
+// tests, as they need no files or network
+func InMemory(name string, opts ...Option) Configuration {
+	c := NewConfiguration(name, opts...)
+	c.memory = true
+
+	return c
+}
+
+// FromConfig creates the configuration from the settings under the given configuration path, the settings are read from
+// the database section if no path is given. E.g.
+//
+//	database:
+//	    driver: sqlite
+//	    path: ./data/orders.db
+//	    busy-timeout: 5s
+//	    foreign-keys: true
+//	    params:
+//	        _journal_mode: WAL
+//
+// The database is held in memory if the path is :memory:
+func FromConfig(path ...string) Configuration {
+	if len(path) == 0 {
+		path = []string{config.DatabaseKey}
+	}
+
+	get := func(key string) *config.Config {
+		return config.Get(append(append([]string{}, path...), key)...)
+	}
+
+	opts := []Option{
+		WithBusyTimeout(get("busy-timeout").Duration(DefaultBusyTimeout)),
+		WithForeignKeys(get("foreign-keys").Bool(true)),
+	}
+
+	for k, v := range get("params").StringMapString(nil) {
+		opts = append(opts, WithParam(k, v))
+	}
+
+	if file := get("path").String(":memory:"); file != ":memory:" {
+		return NewConfiguration(file, opts...)
+	}
+
+	return InMemory(config.Get(config.ServiceNameKey).String("bootstrap"), opts...)
+}
+
+// Driver returns the name of the database/sql driver used to connect
+func (c Configuration) Driver() string {
+	return DefaultDriver
+}
+
+// DSN returns the configuration as a file: URI, with the path and parameters escaped
+func (c Configuration) DSN() string {
+	q := url.Values{}
+
+	for k, v := range c.params {
+		q.Set(k, v)
+	}
+
+	q.Set("_busy_timeout", strconv.FormatInt(c.busyTimeout.Milliseconds(), 10))
+	q.Set("_foreign_keys", strconv.FormatBool(c.foreignKeys))
+
+	if c.memory {
+		q.Set("mode", "memory")
+		q.Set("cache", "shared")
+	}
+
+	u := url.URL{Scheme: "file", Opaque: (&url.URL{Path: c.path}).EscapedPath(), RawQuery: q.Encode()}
+
+	return u.String()
+}
+
+// Dialect returns the SQLite dialect
+func (c Configuration) Dialect() db.Dialect {
+	return db.SQLite
+}
+
+// Path returns the path of the database file, or the name of an in-memory database
+func (c Configuration) Path() string {
+	return c.path
+}
+
+// InMemory returns true if the database is held in memory
+func (c Configuration) InMemory() bool {
+	return c.memory
+}
@@ -0,0 +1,35 @@
+package sqlite_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db/sqlite"
+)
+
+func TestConfiguration_DSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   sqlite.Configuration
+		expected string
+	}{
+		{
+			name:     "file",
+			config:   sqlite.NewConfiguration("./data/my orders.db", sqlite.WithBusyTimeout(time.Second)),
+			expected: "file:./data/my%20orders.db?_busy_timeout=1000&_foreign_keys=true",
+		},
+		{
+			name:     "in memory",
+			config:   sqlite.InMemory("orders", sqlite.WithForeignKeys(false), sqlite.WithParam("_journal_mode", "WAL")),
+			expected: "file:orders?_busy_timeout=5000&_foreign_keys=false&_journal_mode=WAL&cache=shared&mode=memory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if dsn := test.config.DSN(); dsn != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, dsn)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+const (
+	// SerializationFailure is the SQLSTATE returned when a serializable transaction conflicts with another transaction
+	SerializationFailure = "40001"
+	// DeadlockDetected is the SQLSTATE returned when a transaction is chosen as the victim of a deadlock
+	DeadlockDetected = "40P01"
+)
+
+const (
+	// DefaultTxAttempts is the number of times a transaction is attempted when no options are given
+	DefaultTxAttempts = 3
+	// DefaultTxBackoff is the delay before retrying a transaction when no options are given
+	DefaultTxBackoff = 10 * time.Millisecond
+)
+
+// SQLStateError is implemented by driver errors that report their SQLSTATE code, e.g. *pq.Error
+type SQLStateError interface {
+	error
+	SQLState() string
+}
+
+// TxOptions controls how WithTx runs a transaction
+type TxOptions struct {
+	// Isolation is the isolation level of the transaction, the driver's default is used if it is zero
+	Isolation sql.IsolationLevel
+	// ReadOnly starts a read only transaction
+	ReadOnly bool
+	// Attempts is the maximum number of times the transaction is run, including the first attempt
+	Attempts int
+	// Backoff is the delay before the first retry, it is doubled for every following retry
+	Backoff time.Duration
+	// MaxBackoff limits the delay between retries, there is no limit if it is zero
+	MaxBackoff time.Duration
+	// Retryable decides whether a failed transaction is run again, IsRetryable is used if it is nil
+	Retryable func(error) bool
+}
+
+// DefaultTxOptions returns the options used when WithTx is not given any
+func DefaultTxOptions() *TxOptions {
+	return &TxOptions{Attempts: DefaultTxAttempts, Backoff: DefaultTxBackoff}
+}
+
+// IsRetryable returns true if the error is a serialization failure or a deadlock, which succeed when the
+// transaction is run again
+func IsRetryable(err error) bool {
+	var se SQLStateError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	switch se.SQLState() {
+	case SerializationFailure, DeadlockDetected:
+		return true
+	}
+
+	return false
+}
+
+// Tx is a transaction started by WithTx. A Tx created by a nested call to WithTx shares its parent's transaction
+// and is scoped by a savepoint
+type Tx struct {
+	*sql.Tx
+	depth int
+}
+
+// Depth returns 0 for a transaction and the nesting depth for a savepoint
+func (tx *Tx) Depth() int {
+	return tx.depth
+}
+
+// TxFunc is the work done in a transaction, it must use the context it is given so nested calls to WithTx
+// create savepoints in the same transaction
+type TxFunc func(ctx context.Context, tx *Tx) error
+
+type txKey struct{}
+
+// TxFromContext returns the transaction started by the WithTx call the context was given to
+func TxFromContext(ctx context.Context) (*Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*Tx)
+	return tx, ok
+}
+
+// WithTx runs the function in a transaction, committing it if the function succeeds and rolling it back if
+// the function returns an error or panics. Transactions that fail with a retryable error are run again with an
+// exponential backoff, so the function must be safe to run more than once.
+//
+// If the context already holds a transaction, the function runs in a savepoint of that transaction instead: the
+// savepoint is rolled back if the function fails, leaving the rest of the transaction intact. Savepoints are not
+// retried, the error is returned to the outermost call, which retries the whole transaction.
+// The default options are used if opts is nil
+func WithTx(ctx context.Context, conn *sql.DB, opts *TxOptions, fn TxFunc) error {
+	if opts == nil {
+		opts = DefaultTxOptions()
+	}
+
+	if parent, ok := TxFromContext(ctx); ok {
+		return savepoint(ctx, parent, fn)
+	}
+
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	backoff := opts.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := run(ctx, conn, opts, fn)
+
+		if err == nil || attempt >= opts.Attempts || !retryable(err) {
+			return err
+		}
+
+		logger.Logger().Info("Retrying transaction", zap.Int("attempt", attempt+1), zap.Error(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+func run(ctx context.Context, conn *sql.DB, opts *TxOptions, fn TxFunc) (err error) {
+	sqlTx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	tx := &Tx{Tx: sqlTx}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx), tx); err != nil {
+		if rerr := sqlTx.Rollback(); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			return fmt.Errorf("%w, and could not roll back the transaction: %v", err, rerr)
+		}
+
+		return err
+	}
+
+	return sqlTx.Commit()
+}
+
+func savepoint(ctx context.Context, parent *Tx, fn TxFunc) (err error) {
+	tx := &Tx{Tx: parent.Tx, depth: parent.depth + 1}
+	name := fmt.Sprintf("sp_%d", tx.depth)
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("could not create savepoint: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx), tx); err != nil {
+		if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			return fmt.Errorf("%w, and could not roll back to the savepoint: %v", err, rerr)
+		}
+
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("could not release savepoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+//go:build cgo
+// +build cgo
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/db"
+)
+
+// sqlStateError is a driver error reporting a SQLSTATE code
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "sqlstate " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+func accounts(t *testing.T) *sql.DB {
+	conn := openSQLite(t)
+
+	if _, err := conn.Exec("CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER)"); err != nil {
+		t.Fatalf("could not create accounts table - %v", err)
+	}
+
+	return conn
+}
+
+func count(t *testing.T, conn *sql.DB) int {
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM accounts").Scan(&n); err != nil {
+		t.Fatalf("could not count accounts - %v", err)
+	}
+
+	return n
+}
+
+func insert(ctx context.Context, tx *db.Tx, id int) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO accounts (id, balance) VALUES (?, 0)", id)
+	return err
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name     string
+		fn       db.TxFunc
+		err      error
+		expected int
+	}{
+		{
+			name:     "commits",
+			fn:       func(ctx context.Context, tx *db.Tx) error { return insert(ctx, tx, 1) },
+			expected: 1,
+		},
+		{
+			name: "rolls back on error",
+			fn: func(ctx context.Context, tx *db.Tx) error {
+				_ = insert(ctx, tx, 1)
+				return failed
+			},
+			err:      failed,
+			expected: 0,
+		},
+		{
+			name: "rolls back failed savepoints",
+			fn: func(ctx context.Context, tx *db.Tx) error {
+				if err := insert(ctx, tx, 1); err != nil {
+					return err
+				}
+
+				err := db.WithTx(ctx, nil, nil, func(ctx context.Context, sp *db.Tx) error {
+					if sp.Depth() != 1 {
+						return fmt.Errorf("expected a savepoint, got depth %d", sp.Depth())
+					}
+
+					_ = insert(ctx, sp, 2)
+					return failed
+				})
+
+				if !errors.Is(err, failed) {
+					return fmt.Errorf("expected the savepoint to fail, got %v", err)
+				}
+
+				return db.WithTx(ctx, nil, nil, func(ctx context.Context, sp *db.Tx) error {
+					return insert(ctx, sp, 3)
+				})
+			},
+			expected: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := accounts(t)
+
+			if err := db.WithTx(ctx, conn, nil, test.fn); !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if n := count(t, conn); n != test.expected {
+				t.Errorf("expected %d accounts, got %d", test.expected, n)
+			}
+		})
+	}
+}
+
+func TestWithTx_Panic(t *testing.T) {
+	conn := accounts(t)
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("expected the panic to be re-raised, got %v", p)
+		}
+
+		if n := count(t, conn); n != 0 {
+			t.Errorf("expected the transaction to be rolled back, got %d accounts", n)
+		}
+	}()
+
+	_ = db.WithTx(context.Background(), conn, nil, func(ctx context.Context, tx *db.Tx) error {
+		_ = insert(ctx, tx, 1)
+		panic("boom")
+	})
+}
+
+func TestWithTx_Retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		attempts int
+	}{
+		{name: "serialization failure", failures: 2, err: sqlStateError(db.SerializationFailure), attempts: 3},
+		{name: "deadlock", failures: 1, err: fmt.Errorf("insert: %w", sqlStateError(db.DeadlockDetected)), attempts: 2},
+		{name: "exhausted", failures: 5, err: sqlStateError(db.SerializationFailure), attempts: 3},
+		{name: "not retryable", failures: 1, err: sqlStateError("23505"), attempts: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := accounts(t)
+			attempts := 0
+
+			opts := &db.TxOptions{Isolation: sql.LevelSerializable, Attempts: 3, Backoff: time.Millisecond}
+
+			err := db.WithTx(context.Background(), conn, opts, func(ctx context.Context, tx *db.Tx) error {
+				attempts++
+
+				if err := insert(ctx, tx, 1); err != nil {
+					return err
+				}
+
+				if attempts <= test.failures {
+					return test.err
+				}
+
+				return nil
+			})
+
+			if attempts != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, attempts)
+			}
+
+			succeeded := test.failures < test.attempts
+
+			if succeeded != (err == nil) || succeeded != (count(t, conn) == 1) {
+				t.Errorf("expected the transaction to succeed: %v - %v", succeeded, err)
+			}
+		})
+	}
+}
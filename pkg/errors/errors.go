@@ -1,9 +1,369 @@
 package errors
 
 import (
+	"context"
 	ge "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var NotImplementedError error = New(Unimplemented, "NotImplemented")
+var NoServiceInitializersError error = New(FailedPrecondition, "no service initializers have been defined")
+var ServicePropertiesNotDefinedError error = New(FailedPrecondition, "service properties have not been defined")
+
+// Code classifies an error so it can be handled, reported and mapped to HTTP statuses and exit codes consistently.
+// A Code is also an error, so errors.Is(err, NotFound) checks whether err carries the NotFound code
+type Code int
+
+const (
+	// Unknown is the code of errors that have not been classified
+	Unknown Code = iota
+	// Canceled means the operation was canceled by the caller
+	Canceled
+	// InvalidArgument means the caller gave an invalid argument
+	InvalidArgument
+	// NotFound means something that was requested does not exist
+	NotFound
+	// AlreadyExists means something the caller tried to create already exists
+	AlreadyExists
+	// PermissionDenied means the caller is not allowed to perform the operation
+	PermissionDenied
+	// Unauthenticated means the caller could not be identified
+	Unauthenticated
+	// FailedPrecondition means the system is not in the state the operation requires
+	FailedPrecondition
+	// Conflict means the operation conflicted with another operation, e.g. a concurrent update
+	Conflict
+	// ResourceExhausted means a limit or quota has been reached
+	ResourceExhausted
+	// DeadlineExceeded means the operation did not complete in time
+	DeadlineExceeded
+	// Unimplemented means the operation is not implemented
+	Unimplemented
+	// Unavailable means a dependency is unavailable, the operation may succeed if it is retried
+	Unavailable
+	// Internal means something that should never happen has happened
+	Internal
 )
 
-var NotImplementedError = ge.New("NotImplemented")
-var NoServiceInitializersError = ge.New("no service initializers have been defined")
-var ServicePropertiesNotDefinedError = ge.New("service properties have not been defined")
+var codeNames = map[Code]string{
+	Unknown:            "unknown",
+	Canceled:           "canceled",
+	InvalidArgument:    "invalid-argument",
+	NotFound:           "not-found",
+	AlreadyExists:      "already-exists",
+	PermissionDenied:   "permission-denied",
+	Unauthenticated:    "unauthenticated",
+	FailedPrecondition: "failed-precondition",
+	Conflict:           "conflict",
+	ResourceExhausted:  "resource-exhausted",
+	DeadlineExceeded:   "deadline-exceeded",
+	Unimplemented:      "unimplemented",
+	Unavailable:        "unavailable",
+	Internal:           "internal",
+}
+
+// httpStatuses maps codes to the status an HTTP service responds with
+var httpStatuses = map[Code]int{
+	Unknown:            http.StatusInternalServerError,
+	Canceled:           499, // client closed request
+	InvalidArgument:    http.StatusBadRequest,
+	NotFound:           http.StatusNotFound,
+	AlreadyExists:      http.StatusConflict,
+	PermissionDenied:   http.StatusForbidden,
+	Unauthenticated:    http.StatusUnauthorized,
+	FailedPrecondition: http.StatusPreconditionFailed,
+	Conflict:           http.StatusConflict,
+	ResourceExhausted:  http.StatusTooManyRequests,
+	DeadlineExceeded:   http.StatusGatewayTimeout,
+	Unimplemented:      http.StatusNotImplemented,
+	Unavailable:        http.StatusServiceUnavailable,
+	Internal:           http.StatusInternalServerError,
+}
+
+// exitCodes maps codes to the status a process exits with, following the conventions of sysexits.h
+var exitCodes = map[Code]int{
+	Unknown:            1,
+	Canceled:           130, // terminated by Ctrl-C
+	InvalidArgument:    64,  // EX_USAGE
+	NotFound:           66,  // EX_NOINPUT
+	AlreadyExists:      73,  // EX_CANTCREAT
+	PermissionDenied:   77,  // EX_NOPERM
+	Unauthenticated:    77,  // EX_NOPERM
+	FailedPrecondition: 78,  // EX_CONFIG
+	Conflict:           75,  // EX_TEMPFAIL
+	ResourceExhausted:  75,  // EX_TEMPFAIL
+	DeadlineExceeded:   75,  // EX_TEMPFAIL
+	Unimplemented:      70,  // EX_SOFTWARE
+	Unavailable:        69,  // EX_UNAVAILABLE
+	Internal:           70,  // EX_SOFTWARE
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("code(%d)", int(c))
+}
+
+// Error returns the name of the code, so a code can be the target of errors.Is
+func (c Code) Error() string {
+	return c.String()
+}
+
+// HTTPStatus returns the HTTP status for the code
+func (c Code) HTTPStatus() int {
+	if status, ok := httpStatuses[c]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// ExitCode returns the process exit code for the code
+func (c Code) ExitCode() int {
+	if code, ok := exitCodes[c]; ok {
+		return code
+	}
+
+	return 1
+}
+
+// Error is an error carrying a code, a message, key/value details and, optionally, the stack where it was created
+type Error struct {
+	// Code classifies the error
+	Code Code
+	// Message describes what went wrong
+	Message string
+	// Details are key/value pairs describing the context of the error
+	Details map[string]interface{}
+	// Err is the error that caused this error
+	Err error
+
+	stack []uintptr
+}
+
+// New creates an error with the given code and message, details are given as alternating keys and values, e.g.
+//
+//	errors.New(errors.NotFound, "order not found", "order-id", id)
+func New(code Code, message string, details ...interface{}) *Error {
+	return &Error{Code: code, Message: message, Details: detailMap(details)}
+}
+
+// Newf creates an error with the given code and a formatted message
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// Wrap creates an error with the given code and message caused by err, it returns nil if err is nil
+func Wrap(err error, code Code, message string, details ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	e := New(code, message, details...)
+	e.Err = err
+
+	return e
+}
+
+// With adds a key/value detail to the error
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+
+	e.Details[key] = value
+
+	return e
+}
+
+// WithStack records the stack of the caller, it is included when the error is logged or formatted with %+v
+func (e *Error) WithStack() *Error {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	e.stack = pcs[:n]
+
+	return e
+}
+
+// Stack returns the recorded stack as function file:line entries, starting from the caller of WithStack
+func (e *Error) Stack() []string {
+	if len(e.stack) == 0 {
+		return nil
+	}
+
+	stack := make([]string, 0, len(e.stack))
+	frames := runtime.CallersFrames(e.stack)
+
+	for {
+		f, more := frames.Next()
+		stack = append(stack, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+
+		if !more {
+			break
+		}
+	}
+
+	return stack
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches a Code target against the error's code
+func (e *Error) Is(target error) bool {
+	c, ok := target.(Code)
+	return ok && e.Code == c
+}
+
+// Format prints the error's message for %s and %v, %+v also prints its code, details and stack
+func (e *Error) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		_, _ = fmt.Fprintf(s, "[%s] %s", e.Code, e.Error())
+
+		for _, k := range e.keys() {
+			_, _ = fmt.Fprintf(s, "\n    %s: %v", k, e.Details[k])
+		}
+
+		for _, f := range e.Stack() {
+			_, _ = io.WriteString(s, "\n    at "+f)
+		}
+	case verb == 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	default:
+		_, _ = io.WriteString(s, e.Error())
+	}
+}
+
+func (e *Error) keys() []string {
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// CodeOf returns the code of the first Error in the chain of err. Context cancellation and deadlines are reported as
+// Canceled and DeadlineExceeded, other errors are Unknown
+func CodeOf(err error) Code {
+	var e *Error
+	if ge.As(err, &e) {
+		return e.Code
+	}
+
+	var c Code
+	if ge.As(err, &c) {
+		return c
+	}
+
+	switch {
+	case ge.Is(err, context.Canceled):
+		return Canceled
+	case ge.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	}
+
+	return Unknown
+}
+
+// HTTPStatus returns the HTTP status for err, 200 if err is nil
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	return CodeOf(err).HTTPStatus()
+}
+
+// ExitCode returns the process exit code for err, 0 if err is nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return CodeOf(err).ExitCode()
+}
+
+// Fields returns zap fields describing err, its code, details and the stack of the first Error in its chain, or each of
+// the errors of a MultiError, e.g.
+//
+//	logger.Logger().Error("Could not place order", errors.Fields(err)...)
+func Fields(err error) []zap.Field {
+	if err == nil {
+		return nil
+	}
+
+	fields := []zap.Field{zap.String("error", err.Error()), zap.String("code", CodeOf(err).String())}
+
+	// the members of a MultiError are listed with their details and stacks rather than picking the first one
+	var m *MultiError
+	if ge.As(err, &m) {
+		members := make([]string, 0, m.Len())
+		for _, member := range m.errs {
+			members = append(members, fmt.Sprintf("%+v", member))
+		}
+
+		return append(fields, zap.Strings("errors", members))
+	}
+
+	var e *Error
+	if !ge.As(err, &e) {
+		return fields
+	}
+
+	for _, k := range e.keys() {
+		fields = append(fields, zap.Any(k, e.Details[k]))
+	}
+
+	if stack := e.Stack(); len(stack) > 0 {
+		fields = append(fields, zap.String("stack", strings.Join(stack, "\n")))
+	}
+
+	return fields
+}
+
+// detailMap converts alternating keys and values into a map, a key without a value is recorded with a nil value
+func detailMap(details []interface{}) map[string]interface{} {
+	if len(details) == 0 {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(details)/2)
+
+	for i := 0; i < len(details); i += 2 {
+		k := fmt.Sprint(details[i])
+
+		if i+1 < len(details) {
+			m[k] = details[i+1]
+		} else {
+			m[k] = nil
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,152 @@
+package errors_test
+
+import (
+	"context"
+	ge "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+)
+
+func TestError_Is(t *testing.T) {
+	cause := ge.New("connection refused")
+	err := fmt.Errorf("loading order: %w", errors.Wrap(cause, errors.Unavailable, "orders database unavailable"))
+
+	if !ge.Is(err, errors.Unavailable) {
+		t.Errorf("expected the error to have the unavailable code")
+	}
+
+	if ge.Is(err, errors.NotFound) {
+		t.Errorf("did not expect the error to have the not found code")
+	}
+
+	if !ge.Is(err, cause) {
+		t.Errorf("expected the error to wrap its cause")
+	}
+
+	var e *errors.Error
+	if !ge.As(err, &e) || e.Message != "orders database unavailable" {
+		t.Errorf("expected to find the error in the chain, got %+v", e)
+	}
+
+	if err.Error() != "loading order: orders database unavailable: connection refused" {
+		t.Errorf("unexpected error message %s", err.Error())
+	}
+
+	if errors.Wrap(nil, errors.Internal, "nothing went wrong") != nil {
+		t.Errorf("expected wrapping a nil error to return nil")
+	}
+
+	if !ge.Is(errors.NotImplementedError, errors.Unimplemented) || errors.NotImplementedError.Error() != "NotImplemented" {
+		t.Errorf("expected the existing errors to keep their messages and carry codes")
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   errors.Code
+		status int
+		exit   int
+	}{
+		{err: nil, code: errors.Unknown, status: http.StatusOK, exit: 0},
+		{err: ge.New("boom"), code: errors.Unknown, status: http.StatusInternalServerError, exit: 1},
+		{err: errors.New(errors.NotFound, "order not found"), code: errors.NotFound, status: http.StatusNotFound, exit: 66},
+		{err: errors.New(errors.InvalidArgument, "bad total"), code: errors.InvalidArgument, status: http.StatusBadRequest, exit: 64},
+		{err: fmt.Errorf("query: %w", context.DeadlineExceeded), code: errors.DeadlineExceeded, status: http.StatusGatewayTimeout, exit: 75},
+		{err: errors.Unavailable, code: errors.Unavailable, status: http.StatusServiceUnavailable, exit: 69},
+	}
+
+	for _, test := range tests {
+		if test.err != nil && errors.CodeOf(test.err) != test.code {
+			t.Errorf("expected %v to have code %s, got %s", test.err, test.code, errors.CodeOf(test.err))
+		}
+
+		if status := errors.HTTPStatus(test.err); status != test.status {
+			t.Errorf("expected %v to map to status %d, got %d", test.err, test.status, status)
+		}
+
+		if exit := errors.ExitCode(test.err); exit != test.exit {
+			t.Errorf("expected %v to map to exit code %d, got %d", test.err, test.exit, exit)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := zap.New(core)
+
+	err := errors.New(errors.NotFound, "order not found", "order-id", 42).With("customer", "bob").WithStack()
+	l.Error("Could not load order", errors.Fields(fmt.Errorf("handler: %w", err))...)
+
+	fields := logs.All()[0].ContextMap()
+
+	if fields["code"] != "not-found" || fields["order-id"] != int64(42) || fields["customer"] != "bob" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	if stack, _ := fields["stack"].(string); !strings.Contains(stack, "TestFields") {
+		t.Errorf("expected the stack to start in the test, got %s", stack)
+	}
+
+	if verbose := fmt.Sprintf("%+v", err); !strings.HasPrefix(verbose, "[not-found] order not found\n    customer: bob\n    order-id: 42") {
+		t.Errorf("unexpected verbose format %s", verbose)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	closed := ge.New("connection already closed")
+
+	if errors.Append(nil, nil, nil) != nil {
+		t.Errorf("expected appending nil errors to return nil")
+	}
+
+	if err := errors.Append(nil, closed); err != closed {
+		t.Errorf("expected a single error to be returned as it is, got %v", err)
+	}
+
+	var err error
+	err = errors.Append(err, closed)
+	err = errors.Append(err, errors.New(errors.Unavailable, "broker unavailable", "broker", "events"))
+	err = errors.Append(err, nil, fmt.Errorf("flushing audit log: %w", errors.New(errors.Internal, "disk full")))
+
+	var m *errors.MultiError
+	if !ge.As(err, &m) || m.Len() != 3 {
+		t.Fatalf("expected 3 errors to be collected, got %v", err)
+	}
+
+	if err.Error() != "3 errors occurred: connection already closed; broker unavailable; flushing audit log: disk full" {
+		t.Errorf("unexpected error message %s", err.Error())
+	}
+
+	if !ge.Is(err, closed) || !ge.Is(err, errors.Unavailable) || !ge.Is(err, errors.Internal) || ge.Is(err, errors.NotFound) {
+		t.Errorf("expected errors.Is to match each of the collected errors")
+	}
+
+	var e *errors.Error
+	if !ge.As(err, &e) || e.Message != "broker unavailable" {
+		t.Errorf("expected errors.As to find the first matching error, got %v", e)
+	}
+
+	if errors.ExitCode(err) != errors.Unavailable.ExitCode() {
+		t.Errorf("expected the exit code of the first error with a code, got %d", errors.ExitCode(err))
+	}
+
+	if err := errors.Append(err, errors.Append(closed, closed)); err.(*errors.MultiError).Len() != 5 {
+		t.Errorf("expected collected errors to be flattened, got %d", err.(*errors.MultiError).Len())
+	}
+
+	want := "3 errors occurred:\n  * connection already closed\n  * [unavailable] broker unavailable\n        broker: events\n" +
+		"  * flushing audit log: disk full"
+
+	if verbose := fmt.Sprintf("%+v", err); verbose != want {
+		t.Errorf("unexpected verbose format %s", verbose)
+	}
+}
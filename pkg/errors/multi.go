@@ -0,0 +1,126 @@
+package errors
+
+import (
+	ge "errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MultiError collects the errors of operations that should all run even when some of them fail, such as cleaning up
+// the resources of an application. errors.Is and errors.As match any of the collected errors
+type MultiError struct {
+	errs []error
+}
+
+// Append adds errors to err and returns the combined error, nil errors are ignored and collected errors are
+// flattened, so
+//
+//	err = errors.Append(err, cleanup())
+//
+// can be called in a loop. Append returns nil if there are no errors and the error itself if there is only one
+func Append(err error, errs ...error) error {
+	m := &MultiError{}
+	m.Append(err)
+	m.Append(errs...)
+
+	return m.ErrorOrNil()
+}
+
+// Append adds errors to the collection, nil errors are ignored and the members of a MultiError are added individually
+func (m *MultiError) Append(errs ...error) {
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if other, ok := err.(*MultiError); ok {
+			m.errs = append(m.errs, other.errs...)
+			continue
+		}
+
+		m.errs = append(m.errs, err)
+	}
+}
+
+// Errors returns the collected errors in the order they were added
+func (m *MultiError) Errors() []error {
+	if m == nil {
+		return nil
+	}
+
+	return append([]error(nil), m.errs...)
+}
+
+// Len returns the number of collected errors
+func (m *MultiError) Len() int {
+	if m == nil {
+		return 0
+	}
+
+	return len(m.errs)
+}
+
+// ErrorOrNil returns nil if no errors have been collected, the error if only one has and the MultiError otherwise
+func (m *MultiError) ErrorOrNil() error {
+	switch m.Len() {
+	case 0:
+		return nil
+	case 1:
+		return m.errs[0]
+	default:
+		return m
+	}
+}
+
+func (m *MultiError) Error() string {
+	if m.Len() == 1 {
+		return m.errs[0].Error()
+	}
+
+	msgs := make([]string, 0, m.Len())
+	for _, err := range m.errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("%d errors occurred: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the collected errors matches target
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.errs {
+		if ge.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first collected error that matches target and sets target to it
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.errs {
+		if ge.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Format prints the error's message for %s and %v, %+v prints each collected error on its own line with %+v
+func (m *MultiError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		_, _ = fmt.Fprintf(s, "%d errors occurred:", m.Len())
+
+		for _, err := range m.errs {
+			member := strings.ReplaceAll(fmt.Sprintf("%+v", err), "\n", "\n    ")
+			_, _ = io.WriteString(s, "\n  * "+member)
+		}
+	case verb == 'q':
+		_, _ = fmt.Fprintf(s, "%q", m.Error())
+	default:
+		_, _ = io.WriteString(s, m.Error())
+	}
+}
@@ -0,0 +1,642 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// stateNode is a state in the compiled definition, the root node is an unnamed composite state
+// containing all the top level states
+type stateNode struct {
+	name     string
+	parent   *stateNode
+	children []*stateNode
+	parallel bool
+	history  bool
+	order    int
+	depth    int
+}
+
+func (n *stateNode) atomic() bool {
+	return len(n.children) == 0
+}
+
+// descendantOf checks if the node is nested, at any depth, inside the given node
+func (n *stateNode) descendantOf(a *stateNode) bool {
+	for p := n.parent; p != nil; p = p.parent {
+		if p == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chart is the compiled form of a Definition used by the states at runtime
+type chart struct {
+	root        *stateNode
+	nodes       map[string]*stateNode
+	order       []*stateNode
+	transitions map[string][]*transitionDef
+	enter       map[string]ActionFn
+	exit        map[string]ActionFn
+}
+
+func newChart(d *Definition) *chart {
+	c := &chart{
+		root:        &stateNode{order: -1},
+		nodes:       make(map[string]*stateNode),
+		order:       make([]*stateNode, 0, len(d.states)),
+		transitions: make(map[string][]*transitionDef),
+		enter:       d.enter,
+		exit:        d.exit,
+	}
+
+	for i, s := range d.states {
+		n := &stateNode{
+			name:     s,
+			parallel: d.parallel[s],
+			history:  d.history[s],
+			order:    i,
+		}
+
+		c.nodes[s] = n
+		c.order = append(c.order, n)
+	}
+
+	for _, n := range c.order {
+		if p, ok := d.parents[n.name]; ok {
+			n.parent = c.nodes[p]
+		} else {
+			n.parent = c.root
+			c.root.children = append(c.root.children, n)
+		}
+	}
+
+	for _, n := range c.order {
+		for _, child := range d.children[n.name] {
+			n.children = append(n.children, c.nodes[child])
+		}
+
+		for p := n.parent; p != nil; p = p.parent {
+			n.depth++
+		}
+	}
+
+	return c
+}
+
+// handlesEvents checks if there are any transitions from the node or the states it is nested in
+func (c *chart) handlesEvents(n *stateNode) bool {
+	for ; n != c.root; n = n.parent {
+		if len(c.transitions[n.name]) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reachable finds every state that can become active starting from the initial state
+func (c *chart) reachable(initial *stateNode) map[string]bool {
+	reached := make(map[string]bool)
+	queue := make([]*stateNode, 0)
+
+	visit := func(target *stateNode) {
+		for name := range c.entryClosure(target, nil) {
+			if !reached[name] {
+				reached[name] = true
+				queue = append(queue, c.nodes[name])
+			}
+		}
+	}
+
+	visit(initial)
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for _, t := range c.transitions[n.name] {
+			if target, ok := c.nodes[t.to]; ok {
+				visit(target)
+			}
+		}
+	}
+
+	return reached
+}
+
+// entryClosure returns the states that are active when the target state is entered from outside
+// of any state: its parents, the state itself and the default children it enters
+func (c *chart) entryClosure(target *stateNode, history map[string]string) map[string]bool {
+	active := make(map[string]bool)
+
+	for n := target; n != c.root; n = n.parent {
+		active[n.name] = true
+	}
+
+	c.complete(c.root, active, history)
+
+	return active
+}
+
+// complete enters the default children of every active composite and parallel state that does not have
+// any active children. Composite states with history enter the child recorded in the history
+func (c *chart) complete(n *stateNode, active map[string]bool, history map[string]string) {
+	if n.atomic() {
+		return
+	}
+
+	if n.parallel {
+		for _, child := range n.children {
+			active[child.name] = true
+			c.complete(child, active, history)
+		}
+
+		return
+	}
+
+	for _, child := range n.children {
+		if active[child.name] {
+			c.complete(child, active, history)
+			return
+		}
+	}
+
+	child := n.children[0]
+
+	if h, ok := history[n.name]; ok && n.history {
+		child = c.nodes[h]
+	}
+
+	active[child.name] = true
+	c.complete(child, active, history)
+}
+
+// domain returns the state containing both the source and target of the transition, which is not exited or
+// entered by the transition. Parallel states are skipped so a transition between regions leaves the parallel state
+func (c *chart) domain(t *transitionDef) *stateNode {
+	target := c.nodes[t.to]
+
+	for a := c.nodes[t.from].parent; a != nil; a = a.parent {
+		if !a.parallel && target.descendantOf(a) {
+			return a
+		}
+	}
+
+	return c.root
+}
+
+// start creates the state where the machine has just entered the given state
+func (c *chart) start(n *stateNode) *chartState {
+	active := c.entryClosure(n, nil)
+
+	return &chartState{
+		id:      uuid.New(),
+		chart:   c,
+		active:  active,
+		history: make(map[string]string),
+		entered: c.sorted(active, false),
+	}
+}
+
+// chartSnapshot is the data saved in snapshots for states created from a Definition
+type chartSnapshot struct {
+	Active  []string          `json:"active"`
+	History map[string]string `json:"history,omitempty"`
+}
+
+// restore recreates the state from the data saved in a snapshot
+func (c *chart) restore(data []byte) (*chartState, error) {
+	var snap chartSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]bool)
+	history := make(map[string]string)
+
+	for _, name := range snap.Active {
+		if _, ok := c.nodes[name]; !ok {
+			return nil, fmt.Errorf("state %q has not been defined", name)
+		}
+
+		active[name] = true
+	}
+
+	for parent, child := range snap.History {
+		if _, ok := c.nodes[parent]; !ok {
+			return nil, fmt.Errorf("state %q has not been defined", parent)
+		}
+
+		if _, ok := c.nodes[child]; !ok {
+			return nil, fmt.Errorf("state %q has not been defined", child)
+		}
+
+		history[parent] = child
+	}
+
+	return &chartState{
+		id:      uuid.New(),
+		chart:   c,
+		active:  active,
+		history: history,
+	}, nil
+}
+
+// sorted returns the named states from the outermost to the innermost state, or the reverse
+func (c *chart) sorted(names map[string]bool, innermostFirst bool) []*stateNode {
+	nodes := make([]*stateNode, 0, len(names))
+
+	for name := range names {
+		nodes = append(nodes, c.nodes[name])
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].depth != nodes[j].depth {
+			return (nodes[i].depth < nodes[j].depth) != innermostFirst
+		}
+
+		return (nodes[i].order < nodes[j].order) != innermostFirst
+	})
+
+	return nodes
+}
+
+// chartState is the State created from a Definition, it holds every state that is active in the
+// machine, the composite states it is nested in and the regions of any parallel states
+type chartState struct {
+	id       uuid.UUID
+	chart    *chart
+	active   map[string]bool
+	history  map[string]string
+	entered  []*stateNode
+	event    Event
+	selected []*transitionDef
+	exiting  []*stateNode
+	next     *chartState
+}
+
+func (s *chartState) ID() uuid.UUID {
+	return s.id
+}
+
+// Description returns the names of the innermost active states, separated by commas
+func (s *chartState) Description() string {
+	return strings.Join(s.leaves(), ",")
+}
+
+// Active returns the names of every active state, including the composite and parallel states
+// the innermost states are nested in
+func (s *chartState) Active() []string {
+	names := make([]string, 0, len(s.active))
+
+	for _, n := range s.chart.order {
+		if s.active[n.name] {
+			names = append(names, n.name)
+		}
+	}
+
+	return names
+}
+
+// SnapshotData saves the active states and the history of composite states
+func (s *chartState) SnapshotData() ([]byte, error) {
+	return json.Marshal(chartSnapshot{
+		Active:  s.Active(),
+		History: s.history,
+	})
+}
+
+// In checks if the named state is active
+func (s *chartState) In(name string) bool {
+	return s.active[name]
+}
+
+func (s *chartState) leaves() []string {
+	names := make([]string, 0)
+
+	for _, n := range s.chart.order {
+		if s.active[n.name] && n.atomic() {
+			names = append(names, n.name)
+		}
+	}
+
+	return names
+}
+
+// Execute selects the transitions triggered by the event. Each active innermost state looks for a transition
+// for the event, and if it does not have one whose guards pass the event bubbles up to its parents.
+// Events that no active state has a transition for are rejected
+func (s *chartState) Execute(event Event) error {
+	s.event = event
+	s.selected = nil
+	s.exiting = nil
+	s.next = nil
+
+	handled := false
+	exits := make(map[string]bool)
+
+	for _, n := range s.chart.order {
+		if !s.active[n.name] || !n.atomic() {
+			continue
+		}
+
+		for p := n; p != s.chart.root; p = p.parent {
+			t, matched := s.match(p, event)
+			handled = handled || matched
+
+			if t == nil {
+				continue
+			}
+
+			if !s.isSelected(t) {
+				exit := s.exitSet(t)
+
+				// transitions in different regions that would exit the same states conflict, the first one wins
+				if !overlaps(exits, exit) {
+					s.selected = append(s.selected, t)
+
+					for name := range exit {
+						exits[name] = true
+					}
+				}
+			}
+
+			break
+		}
+	}
+
+	if !handled {
+		return UnexpectedEventError(event)
+	}
+
+	if len(s.selected) > 0 {
+		s.plan(exits)
+	}
+
+	return nil
+}
+
+// match returns the first transition from the state for the event whose guards pass, and whether
+// the state has any transitions for the event at all
+func (s *chartState) match(n *stateNode, event Event) (*transitionDef, bool) {
+	matched := false
+
+	for _, t := range s.chart.transitions[n.name] {
+		if t.event != event.Name() {
+			continue
+		}
+
+		matched = true
+		passed := true
+
+		for _, g := range t.guards {
+			if !g(event) {
+				passed = false
+				break
+			}
+		}
+
+		if passed {
+			return t, true
+		}
+	}
+
+	return nil, matched
+}
+
+func (s *chartState) isSelected(t *transitionDef) bool {
+	for _, sel := range s.selected {
+		if sel == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exitSet returns the active states that are left when the transition takes place
+func (s *chartState) exitSet(t *transitionDef) map[string]bool {
+	domain := s.chart.domain(t)
+	exit := make(map[string]bool)
+
+	for name := range s.active {
+		if s.chart.nodes[name].descendantOf(domain) {
+			exit[name] = true
+		}
+	}
+
+	return exit
+}
+
+// plan works out the states that are exited and entered by the selected transitions, and creates
+// the state the machine will be in once they have taken place
+func (s *chartState) plan(exits map[string]bool) {
+	active := make(map[string]bool)
+	history := make(map[string]string)
+
+	for name := range s.active {
+		if !exits[name] {
+			active[name] = true
+		}
+	}
+
+	for k, v := range s.history {
+		history[k] = v
+	}
+
+	for name := range exits {
+		n := s.chart.nodes[name]
+
+		if !n.history {
+			continue
+		}
+
+		for _, child := range n.children {
+			if s.active[child.name] {
+				history[n.name] = child.name
+			}
+		}
+	}
+
+	remaining := make(map[string]bool)
+	for name := range active {
+		remaining[name] = true
+	}
+
+	for _, t := range s.selected {
+		domain := s.chart.domain(t)
+
+		for n := s.chart.nodes[t.to]; n != domain; n = n.parent {
+			active[n.name] = true
+		}
+	}
+
+	s.chart.complete(s.chart.root, active, history)
+
+	entered := make(map[string]bool)
+	for name := range active {
+		if !remaining[name] {
+			entered[name] = true
+		}
+	}
+
+	s.exiting = s.chart.sorted(exits, true)
+	s.next = &chartState{
+		id:      uuid.New(),
+		chart:   s.chart,
+		active:  active,
+		history: history,
+		entered: s.chart.sorted(entered, false),
+	}
+}
+
+// Next returns the state the machine will be in once the selected transitions have taken place
+func (s *chartState) Next() State {
+	if s.next == nil {
+		return s
+	}
+
+	return s.next
+}
+
+// WithTransitions is not supported by states created from a Definition, their transitions are declared
+// in the Definition, so the state is returned unchanged
+func (s *chartState) WithTransitions(...Transition) State {
+	return s
+}
+
+// Transitions returns a single transition that moves the machine to the next state and executes the actions
+// of every selected transition, or no transitions if the event did not trigger any
+func (s *chartState) Transitions() []Transition {
+	if s.next == nil {
+		return nil
+	}
+
+	next := s.next
+	selected := s.selected
+
+	return []Transition{
+		{
+			Next: func(State) State {
+				return next
+			},
+			Action: func(ctx context.Context, event Event) error {
+				for _, t := range selected {
+					if t.action == nil {
+						continue
+					}
+
+					if err := t.action(ctx, event); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+}
+
+// Accepts returns the names of the events the active states, or the states they are nested in, have transitions for.
+// Timer events are not included as they are sent by the machine itself
+func (s *chartState) Accepts() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	for _, n := range s.chart.order {
+		if !s.active[n.name] {
+			continue
+		}
+
+		for _, t := range s.chart.transitions[n.name] {
+			if t.after > 0 || seen[t.event] {
+				continue
+			}
+
+			seen[t.event] = true
+			names = append(names, t.event)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Timeouts returns the timeouts of every active state
+func (s *chartState) Timeouts() []Timeout {
+	timeouts := make([]Timeout, 0)
+
+	for _, n := range s.chart.order {
+		if !s.active[n.name] {
+			continue
+		}
+
+		declared := make(map[string]bool)
+
+		for _, t := range s.chart.transitions[n.name] {
+			if t.after > 0 && !declared[t.event] {
+				declared[t.event] = true
+				timeouts = append(timeouts, Timeout{State: n.name, After: t.after, Event: t.event})
+			}
+		}
+	}
+
+	return timeouts
+}
+
+// reentered checks if the named state was entered by the transition that created this state,
+// so its timers are restarted even though it was already active
+func (s *chartState) reentered(name string) bool {
+	for _, n := range s.entered {
+		if n.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OnExit executes the exit hooks of the states being left, from the innermost state outwards
+func (s *chartState) OnExit(ctx context.Context, event Event) error {
+	for _, n := range s.exiting {
+		if fn := s.chart.exit[n.name]; fn != nil {
+			if err := fn(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OnEnter executes the entry hooks of the states that were entered, from the outermost state inwards
+func (s *chartState) OnEnter(ctx context.Context, event Event) error {
+	for _, n := range s.entered {
+		if fn := s.chart.enter[n.name]; fn != nil {
+			if err := fn(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func overlaps(a, b map[string]bool) bool {
+	for k := range b {
+		if a[k] {
+			return true
+		}
+	}
+
+	return false
+}
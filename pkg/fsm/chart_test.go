@@ -0,0 +1,155 @@
+package fsm_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+func worker() *fsm.Definition {
+	return fsm.Define().
+		Initial("idle").
+		From("idle").On("start").To("running").
+		Children("running", "fetching", "processing").
+		History("running").
+		From("fetching").On("fetched").To("processing").
+		From("processing").On("processed").To("fetching").
+		From("running").On("pause").To("paused").
+		From("running").On("stop").To("idle").
+		From("paused").On("resume").To("running")
+}
+
+func player() *fsm.Definition {
+	return fsm.Define().
+		Parallel("player", "playback", "volume").
+		Children("playback", "playing", "paused").
+		Children("volume", "loud", "muted").
+		From("playing").On("toggle").To("paused").
+		From("paused").On("toggle").To("playing").
+		From("loud").On("mute").To("muted").
+		From("muted").On("mute").To("loud").
+		From("playing").On("quiet").To("paused").
+		From("loud").On("quiet").To("muted").
+		From("player").On("reset").To("player")
+}
+
+// states sends each event to a new machine built from the definition and returns the state after each event
+func states(t *testing.T, d *fsm.Definition, events ...string) []string {
+	t.Helper()
+
+	got := make([]string, 0, len(events))
+
+	for i := range events {
+		m, errCh, err := d.Build(uuid.New(), "test")
+		if err != nil {
+			t.Fatalf("could not build state machine - %v", err)
+		}
+
+		sent := make([]fsm.Event, 0, i+1)
+		for _, e := range events[:i+1] {
+			sent = append(sent, event(e))
+		}
+
+		if errs := runMachine(m, errCh, sent...); len(errs) != 0 {
+			t.Fatalf("unexpected errors - %v", errs)
+		}
+
+		got = append(got, m.Current())
+	}
+
+	return got
+}
+
+func TestChart_NestedStates(t *testing.T) {
+	got := states(t, worker(), "start", "fetched", "pause", "resume", "processed", "stop", "start")
+	want := []string{"fetching", "processing", "paused", "processing", "fetching", "idle", "fetching"}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want states %v, got %v", want, got)
+	}
+}
+
+func TestChart_ParallelStates(t *testing.T) {
+	got := states(t, player(), "toggle", "mute", "toggle", "reset", "quiet")
+	want := []string{"paused,loud", "paused,muted", "playing,muted", "playing,loud", "paused,muted"}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want states %v, got %v", want, got)
+	}
+}
+
+func TestChart_Hooks(t *testing.T) {
+	calls := make([]string, 0)
+	hook := func(name string) fsm.ActionFn {
+		return func(context.Context, fsm.Event) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	m, errCh, err := worker().
+		OnEnter("running", hook("enter running")).
+		OnEnter("fetching", hook("enter fetching")).
+		OnExit("fetching", hook("exit fetching")).
+		OnExit("running", hook("exit running")).
+		OnEnter("idle", hook("enter idle")).
+		Build(uuid.New(), "worker")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if errs := runMachine(m, errCh, event("start"), event("stop")); len(errs) != 0 {
+		t.Fatalf("unexpected errors - %v", errs)
+	}
+
+	want := []string{"enter running", "enter fetching", "exit fetching", "exit running", "enter idle"}
+
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want hooks %v, got %v", want, calls)
+	}
+}
+
+func TestChart_Validate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		definition *fsm.Definition
+		want       string
+	}{
+		{
+			name:       "nested inside itself",
+			definition: worker().Children("fetching", "running"),
+			want:       `is nested inside itself`,
+		},
+		{
+			name:       "history on atomic state",
+			definition: worker().History("idle"),
+			want:       `history can only be added to composite states`,
+		},
+		{
+			name:       "nested initial state",
+			definition: worker().Initial("fetching"),
+			want:       `initial state "fetching" must be a top level state`,
+		},
+		{
+			name:       "unreachable nested state",
+			definition: worker().Children("running", "retrying").From("retrying").On("retry").To("fetching"),
+			want:       `state "retrying" is unreachable`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.definition.Validate()
+
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error to contain %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package fsm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is an event the machine could not process, along with the error it caused
+type DeadLetter struct {
+	// MachineID is the unique id of the machine that rejected the event
+	MachineID uuid.UUID
+	// Event is the event that was rejected
+	Event Event
+	// State is the description of the state the machine was in when it rejected the event
+	State string
+	// Error is the error the event caused
+	Error string
+	// Attempts is the number of times the machine tried to process the event
+	Attempts int
+	// Timestamp is the time the event was dead-lettered as nanoseconds past epoch
+	Timestamp int64
+}
+
+// DeadLetterSink receives the events rejected by machines with a dead-letter policy
+type DeadLetterSink interface {
+	Put(context.Context, DeadLetter) error
+}
+
+// DeadLetterStore is a DeadLetterSink that keeps dead letters so they can be listed and re-injected.
+// A dead letter replaces any dead letter already stored for the same event
+type DeadLetterStore interface {
+	DeadLetterSink
+	List(context.Context) ([]DeadLetter, error)
+	Remove(ctx context.Context, eventID uuid.UUID) error
+}
+
+// DeadLetterSinkFullError is returned by a ChannelSink when the channel cannot take the dead letter
+var DeadLetterSinkFullError = errors.New("dead-letter channel is full")
+
+// ChannelSink is a DeadLetterSink that sends dead letters to a channel. The machine is locked while the dead letter
+// is sent, so the sink does not wait for the channel, if it is full the dead letter is dropped and
+// DeadLetterSinkFullError is returned, which the machine logs. Use a buffered channel to hold dead letters until
+// they are read
+type ChannelSink chan<- DeadLetter
+
+func (s ChannelSink) Put(_ context.Context, dl DeadLetter) error {
+	select {
+	case s <- dl:
+		return nil
+	default:
+		return DeadLetterSinkFullError
+	}
+}
+
+// Reinject sends dead-lettered events back to the machine, every dead letter in the store for the machine is
+// sent if no event ids are given. Events the machine processes successfully are removed from the store,
+// the results of the events that were sent are returned along with any errors they caused
+func Reinject(ctx context.Context, store DeadLetterStore, m Machine, eventIDs ...uuid.UUID) ([]Result, error) {
+	letters, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[uuid.UUID]bool)
+	for _, id := range eventIDs {
+		selected[id] = true
+	}
+
+	results := make([]Result, 0)
+	var errs []string
+
+	for _, dl := range letters {
+		if dl.MachineID != m.ID() || (len(selected) > 0 && !selected[dl.Event.ID()]) {
+			continue
+		}
+
+		res, err := m.Send(ctx, dl.Event)
+		results = append(results, res)
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("event %s: %v", dl.Event.ID(), err))
+			continue
+		}
+
+		if err := store.Remove(ctx, dl.Event.ID()); err != nil {
+			return results, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("could not reinject %d events: %v", len(errs), errs)
+	}
+
+	return results, nil
+}
+
+// MemoryDeadLetters is a DeadLetterStore that keeps dead letters in memory
+type MemoryDeadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// NewMemoryDeadLetters creates an empty in-memory dead-letter store
+func NewMemoryDeadLetters() *MemoryDeadLetters {
+	return &MemoryDeadLetters{}
+}
+
+func (s *MemoryDeadLetters) Put(_ context.Context, dl DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters = append(removeLetter(s.letters, dl.Event.ID()), dl)
+
+	return nil
+}
+
+func (s *MemoryDeadLetters) List(context.Context) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]DeadLetter{}, s.letters...), nil
+}
+
+func (s *MemoryDeadLetters) Remove(_ context.Context, eventID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters = removeLetter(s.letters, eventID)
+
+	return nil
+}
+
+func removeLetter(letters []DeadLetter, eventID uuid.UUID) []DeadLetter {
+	kept := make([]DeadLetter, 0, len(letters))
+
+	for _, dl := range letters {
+		if dl.Event.ID() != eventID {
+			kept = append(kept, dl)
+		}
+	}
+
+	return kept
+}
+
+// fileDeadLetter is a dead letter written to a file, with the event encoded by the store's codec
+type fileDeadLetter struct {
+	MachineID uuid.UUID `json:"machine-id"`
+	EventID   uuid.UUID `json:"event-id"`
+	Event     []byte    `json:"event"`
+	State     string    `json:"state"`
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	Timestamp int64     `json:"timestamp"`
+	Removed   bool      `json:"removed,omitempty"`
+}
+
+// FileDeadLetters is a DeadLetterStore that appends dead letters to a file as JSON lines, using the codec to
+// encode the events. Removing a dead letter appends a marker, so the file is never rewritten
+type FileDeadLetters struct {
+	mu    sync.Mutex
+	path  string
+	codec EventCodec
+}
+
+// OpenFileDeadLetters opens the dead-letter file at the given path, creating its directory if necessary
+func OpenFileDeadLetters(path string, codec EventCodec) (*FileDeadLetters, error) {
+	if codec == nil {
+		return nil, errors.New("a codec is required to write events to a dead-letter file")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create dead-letter directory: %w", err)
+	}
+
+	return &FileDeadLetters{path: path, codec: codec}, nil
+}
+
+func (s *FileDeadLetters) Put(_ context.Context, dl DeadLetter) error {
+	data, err := s.codec.Encode(dl.Event)
+	if err != nil {
+		return fmt.Errorf("could not encode event %s: %w", dl.Event.ID(), err)
+	}
+
+	return s.append(fileDeadLetter{
+		MachineID: dl.MachineID,
+		EventID:   dl.Event.ID(),
+		Event:     data,
+		State:     dl.State,
+		Error:     dl.Error,
+		Attempts:  dl.Attempts,
+		Timestamp: dl.Timestamp,
+	})
+}
+
+func (s *FileDeadLetters) Remove(_ context.Context, eventID uuid.UUID) error {
+	return s.append(fileDeadLetter{EventID: eventID, Removed: true})
+}
+
+func (s *FileDeadLetters) List(context.Context) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	letters := make([]DeadLetter, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var fdl fileDeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &fdl); err != nil {
+			return nil, fmt.Errorf("corrupt dead letter in %s: %w", s.path, err)
+		}
+
+		letters = removeLetter(letters, fdl.EventID)
+
+		if fdl.Removed {
+			continue
+		}
+
+		event, err := s.codec.Decode(fdl.Event)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode dead-lettered event %s: %w", fdl.EventID, err)
+		}
+
+		letters = append(letters, DeadLetter{
+			MachineID: fdl.MachineID,
+			Event:     event,
+			State:     fdl.State,
+			Error:     fdl.Error,
+			Attempts:  fdl.Attempts,
+			Timestamp: fdl.Timestamp,
+		})
+	}
+
+	return letters, scanner.Err()
+}
+
+func (s *FileDeadLetters) append(fdl fileDeadLetter) error {
+	line, err := json.Marshal(fdl)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open dead-letter file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write dead letter: %w", err)
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,421 @@
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GuardFn determines whether a transition can take place for the event that has been received
+type GuardFn func(Event) bool
+
+// DefinitionError is returned when a state machine definition fails validation, it contains
+// every problem found with the definition
+type DefinitionError struct {
+	Problems []string
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("invalid state machine definition: %s", strings.Join(e.Problems, "; "))
+}
+
+type transitionDef struct {
+	from   string
+	event  string
+	after  time.Duration
+	to     string
+	guards []GuardFn
+	action ActionFn
+}
+
+// Definition is a declarative description of a state machine. States are identified by name and
+// transitions are triggered by events with a matching name, for example:
+//
+//	fsm.Define().
+//		Initial("locked").
+//		From("locked").On("coin").To("unlocked").
+//		From("unlocked").On("push").To("locked").
+//		Build(uuid.New(), "turnstile")
+//
+// States can be nested inside composite states using Children, or run side by side in the regions of a
+// Parallel state. An event that is not handled by a nested state bubbles up to its parents, so a transition
+// defined on a composite state applies to every state nested within it.
+//
+// The definition is validated when the machine is built, so problems with the shape of the machine
+// such as unreachable states or ambiguous transitions are reported before any event is processed.
+type Definition struct {
+	initial     string
+	states      []string
+	final       map[string]bool
+	enter       map[string]ActionFn
+	exit        map[string]ActionFn
+	parents     map[string]string
+	children    map[string][]string
+	parallel    map[string]bool
+	history     map[string]bool
+	transitions []*transitionDef
+	current     *transitionDef
+	problems    []string
+}
+
+// Define starts a new state machine definition
+func Define() *Definition {
+	return &Definition{
+		final:    make(map[string]bool),
+		enter:    make(map[string]ActionFn),
+		exit:     make(map[string]ActionFn),
+		parents:  make(map[string]string),
+		children: make(map[string][]string),
+		parallel: make(map[string]bool),
+		history:  make(map[string]bool),
+	}
+}
+
+// Initial sets the state the machine starts in, if no initial state is set the first
+// top level state declared in the definition is used
+func (d *Definition) Initial(name string) *Definition {
+	d.initial = name
+	d.declare(name)
+	return d
+}
+
+// State declares a state in the definition, states are declared automatically when they are
+// used in a transition
+func (d *Definition) State(names ...string) *Definition {
+	for _, n := range names {
+		d.declare(n)
+	}
+
+	return d
+}
+
+// Final declares terminal states, which are allowed to have no outgoing transitions
+func (d *Definition) Final(names ...string) *Definition {
+	for _, n := range names {
+		d.declare(n)
+		d.final[n] = true
+	}
+
+	return d
+}
+
+// Children nests the child states inside the parent, making the parent a composite state.
+// When the machine enters the parent it also enters the first child, unless the parent
+// has history and has been entered before
+func (d *Definition) Children(parent string, children ...string) *Definition {
+	d.declare(parent)
+
+	for _, c := range children {
+		d.declare(c)
+
+		if p, ok := d.parents[c]; ok && p != parent {
+			d.problems = append(d.problems, fmt.Sprintf("state %q cannot be a child of both %q and %q", c, p, parent))
+			continue
+		}
+
+		if _, ok := d.parents[c]; !ok {
+			d.parents[c] = parent
+			d.children[parent] = append(d.children[parent], c)
+		}
+	}
+
+	return d
+}
+
+// Parallel nests the regions inside the parent and makes the parent a parallel state, when the machine
+// enters the parent every region is entered and each region handles events independently
+func (d *Definition) Parallel(parent string, regions ...string) *Definition {
+	d.parallel[parent] = true
+	return d.Children(parent, regions...)
+}
+
+// History gives the composite states a shallow history, when the machine re-enters the state it resumes
+// in the child state that was active when it last left the state rather than the first child
+func (d *Definition) History(names ...string) *Definition {
+	for _, n := range names {
+		d.declare(n)
+		d.history[n] = true
+	}
+
+	return d
+}
+
+// OnEnter sets the function executed when the machine enters the named state
+func (d *Definition) OnEnter(name string, fn ActionFn) *Definition {
+	d.declare(name)
+	d.enter[name] = fn
+	return d
+}
+
+// OnExit sets the function executed when the machine leaves the named state
+func (d *Definition) OnExit(name string, fn ActionFn) *Definition {
+	d.declare(name)
+	d.exit[name] = fn
+	return d
+}
+
+// From starts a new transition from the named state
+func (d *Definition) From(name string) *Definition {
+	d.declare(name)
+	d.current = &transitionDef{from: name}
+	d.transitions = append(d.transitions, d.current)
+	return d
+}
+
+// On sets the name of the event that triggers the current transition
+func (d *Definition) On(event string) *Definition {
+	if d.current == nil {
+		d.problems = append(d.problems, fmt.Sprintf("On(%q) called before From", event))
+		return d
+	}
+
+	d.current.event = event
+	return d
+}
+
+// After makes the current transition take place when the machine has been in the state for the given duration,
+// rather than when an event is received. The timer is cancelled if the machine leaves the state before it fires
+func (d *Definition) After(timeout time.Duration) *Definition {
+	if d.current == nil {
+		d.problems = append(d.problems, fmt.Sprintf("After(%s) called before From", timeout))
+		return d
+	}
+
+	if timeout <= 0 {
+		d.problems = append(d.problems, fmt.Sprintf("timeout from %q must be positive", d.current.from))
+		return d
+	}
+
+	d.current.event = TimeoutEventName(timeout)
+	d.current.after = timeout
+	return d
+}
+
+// To sets the state the current transition moves the machine to
+func (d *Definition) To(name string) *Definition {
+	if d.current == nil {
+		d.problems = append(d.problems, fmt.Sprintf("To(%q) called before From", name))
+		return d
+	}
+
+	d.declare(name)
+	d.current.to = name
+	return d
+}
+
+// Guard adds guards to the current transition, the transition only takes place if every guard passes.
+// Guarded transitions for the same state and event are evaluated in the order they were defined
+func (d *Definition) Guard(fns ...GuardFn) *Definition {
+	if d.current == nil {
+		d.problems = append(d.problems, "Guard called before From")
+		return d
+	}
+
+	d.current.guards = append(d.current.guards, fns...)
+	return d
+}
+
+// Action sets the function executed when the current transition takes place
+func (d *Definition) Action(fn ActionFn) *Definition {
+	if d.current == nil {
+		d.problems = append(d.problems, "Action called before From")
+		return d
+	}
+
+	d.current.action = fn
+	return d
+}
+
+// Validate checks the definition for incomplete transitions, invalid nesting, unreachable states,
+// states without any transitions that have not been declared final and ambiguous transitions
+func (d *Definition) Validate() error {
+	_, err := d.compile()
+	return err
+}
+
+// Build validates the definition and creates a state machine in the initial state,
+// along with the channel where errors generated by the state machine will be published
+func (d *Definition) Build(id uuid.UUID, name string, opts ...Option) (*machine, chan error, error) {
+	init, err := d.NewState(d.initialState())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, errCh := New(id, name, init, opts...)
+
+	return m, errCh, nil
+}
+
+// Restore validates the definition and recreates a state machine from the snapshot
+func (d *Definition) Restore(snap Snapshot, opts ...Option) (*machine, chan error, error) {
+	return Restore(snap, d.RestoreState, opts...)
+}
+
+// Rebuild validates the definition and recreates a state machine by replaying its journal,
+// starting from its latest snapshot if one has been saved
+func (d *Definition) Rebuild(ctx context.Context, id uuid.UUID, name string, opts ...Option) (*machine, chan error, error) {
+	init, err := d.NewState(d.initialState())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return Rebuild(ctx, id, name, init, d.RestoreState, opts...)
+}
+
+// RestoreState is a StateFactory that recreates the states of the definition from a snapshot
+func (d *Definition) RestoreState(state string, data []byte) (State, error) {
+	if len(data) == 0 {
+		return d.NewState(state)
+	}
+
+	c, err := d.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.restore(data)
+}
+
+// NewState validates the definition and creates a state where the machine is in the named state.
+// The named state's parents are also entered, and if it is a composite or parallel state so are its children
+func (d *Definition) NewState(name string) (State, error) {
+	c, err := d.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := c.nodes[name]
+	if !ok {
+		return nil, fmt.Errorf("state %q has not been defined", name)
+	}
+
+	return c.start(n), nil
+}
+
+// compile validates the definition and converts it into the chart used by the states at runtime
+func (d *Definition) compile() (*chart, error) {
+	problems := append([]string{}, d.problems...)
+
+	if len(d.states) == 0 {
+		return nil, &DefinitionError{Problems: append(problems, "no states have been defined")}
+	}
+
+	for _, s := range d.states {
+		for p := d.parents[s]; p != ""; p = d.parents[p] {
+			if p == s {
+				problems = append(problems, fmt.Sprintf("state %q is nested inside itself", s))
+				return nil, &DefinitionError{Problems: problems}
+			}
+		}
+	}
+
+	c := newChart(d)
+
+	for _, t := range d.transitions {
+		switch {
+		case t.event == "":
+			problems = append(problems, fmt.Sprintf("transition from %q has no event", t.from))
+		case t.to == "":
+			problems = append(problems, fmt.Sprintf("transition from %q on %q has no target state", t.from, t.event))
+		default:
+			c.transitions[t.from] = append(c.transitions[t.from], t)
+		}
+	}
+
+	if p, ok := d.parents[d.initial]; ok {
+		problems = append(problems, fmt.Sprintf("initial state %q must be a top level state, it is nested inside %q", d.initial, p))
+	}
+
+	reachable := c.reachable(c.nodes[d.initialState()])
+
+	for _, s := range d.states {
+		n := c.nodes[s]
+
+		if !reachable[s] {
+			problems = append(problems, fmt.Sprintf("state %q is unreachable from the initial state %q", s, d.initialState()))
+		}
+
+		if n.atomic() && !d.final[s] && !c.handlesEvents(n) {
+			problems = append(problems, fmt.Sprintf("state %q has no transitions and has not been declared final", s))
+		}
+
+		if d.final[s] && (len(c.transitions[s]) > 0 || !n.atomic()) {
+			problems = append(problems, fmt.Sprintf("final state %q cannot have transitions or child states", s))
+		}
+
+		if d.history[s] && (n.atomic() || n.parallel) {
+			problems = append(problems, fmt.Sprintf("history can only be added to composite states, %q is not a composite state", s))
+		}
+
+		byEvent := make(map[string][]*transitionDef)
+		events := make([]string, 0)
+
+		for _, t := range c.transitions[s] {
+			if _, ok := byEvent[t.event]; !ok {
+				events = append(events, t.event)
+			}
+
+			byEvent[t.event] = append(byEvent[t.event], t)
+		}
+
+		sort.Strings(events)
+
+		// an unguarded transition always fires, so any transition defined after it for the same
+		// state and event can never be taken
+		for _, e := range events {
+			ts := byEvent[e]
+			for i, t := range ts[:len(ts)-1] {
+				if len(t.guards) == 0 {
+					problems = append(problems, fmt.Sprintf("ambiguous transitions from %q on %q: transition %d has no guard and shadows %d later transitions", s, e, i+1, len(ts)-i-1))
+					break
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &DefinitionError{Problems: problems}
+	}
+
+	return c, nil
+}
+
+// initialState returns the state the machine starts in
+func (d *Definition) initialState() string {
+	if d.initial != "" {
+		return d.initial
+	}
+
+	for _, s := range d.states {
+		if _, ok := d.parents[s]; !ok {
+			return s
+		}
+	}
+
+	return ""
+}
+
+func (d *Definition) declare(name string) {
+	if name == "" {
+		d.problems = append(d.problems, "states must have a name")
+		return
+	}
+
+	if !d.hasState(name) {
+		d.states = append(d.states, name)
+	}
+}
+
+func (d *Definition) hasState(name string) bool {
+	for _, s := range d.states {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+type testEvent struct {
+	id   uuid.UUID
+	name string
+}
+
+func event(name string) testEvent {
+	return testEvent{id: uuid.New(), name: name}
+}
+
+func (e testEvent) ID() uuid.UUID {
+	return e.id
+}
+
+func (e testEvent) Source() string {
+	return "TEST"
+}
+
+func (e testEvent) Name() string {
+	return e.name
+}
+
+func (e testEvent) Timestamp() int64 {
+	return time.Now().UnixNano()
+}
+
+func turnstile() *fsm.Definition {
+	return fsm.Define().
+		Initial("locked").
+		From("locked").On("coin").To("unlocked").
+		From("unlocked").On("push").To("locked")
+}
+
+func TestDefinition_Validate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		definition *fsm.Definition
+		want       []string
+	}{
+		{
+			name:       "valid definition",
+			definition: turnstile(),
+		},
+		{
+			name:       "unreachable state",
+			definition: turnstile().From("broken").On("fix").To("locked"),
+			want:       []string{`state "broken" is unreachable`},
+		},
+		{
+			name:       "missing handlers",
+			definition: turnstile().From("unlocked").On("kick").To("broken"),
+			want:       []string{`state "broken" has no transitions`},
+		},
+		{
+			name:       "final state",
+			definition: turnstile().From("unlocked").On("kick").To("broken").Final("broken"),
+		},
+		{
+			name: "ambiguous transitions",
+			definition: turnstile().
+				From("locked").On("coin").To("locked"),
+			want: []string{`ambiguous transitions from "locked" on "coin"`},
+		},
+		{
+			name: "guarded transitions",
+			definition: fsm.Define().
+				From("locked").On("coin").Guard(func(fsm.Event) bool { return false }).To("locked").
+				From("locked").On("coin").To("unlocked").
+				From("unlocked").On("push").To("locked"),
+		},
+		{
+			name:       "incomplete transition",
+			definition: turnstile().From("locked").On("kick"),
+			want:       []string{`transition from "locked" on "kick" has no target state`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.definition.Validate()
+
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Errorf("expected definition to be valid - %v", err)
+				}
+
+				return
+			}
+
+			var defErr *fsm.DefinitionError
+			if !errors.As(err, &defErr) {
+				t.Fatalf("expected a definition error, got %v", err)
+			}
+
+			for _, w := range tc.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("expected error to contain %q, got %q", w, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestDefinition_Build(t *testing.T) {
+	coins := 0
+	countCoins := func(context.Context, fsm.Event) error {
+		coins++
+		return nil
+	}
+
+	m, errCh, err := fsm.Define().
+		Initial("locked").
+		From("locked").On("coin").To("unlocked").Action(countCoins).
+		From("unlocked").On("push").To("locked").
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	runMachine(m, errCh, event("push"), event("coin"), event("coin"), event("push"))
+
+	if m.Current() != "locked" {
+		t.Errorf("expected machine to be locked, but was %s", m.Current())
+	}
+
+	if coins != 1 {
+		t.Errorf("expected the coin action to run once, ran %d times", coins)
+	}
+}
@@ -0,0 +1,260 @@
+// Package fsmtest drives state machines with scripted or random sequences of events so their behaviour can be
+// checked in ordinary Go tests
+package fsmtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+// AnyError can be used as the expected error of a step that should fail with an error of any kind
+var AnyError = errors.New("any error")
+
+// TestEvent is a simple event that only has a name, it can be sent to machines whose states select transitions
+// using the name of the event, such as the machines built from a Definition
+type TestEvent struct {
+	id        uuid.UUID
+	name      string
+	timestamp int64
+}
+
+// Event creates an event with the given name and a new id
+func Event(name string) TestEvent {
+	return TestEvent{id: uuid.New(), name: name, timestamp: time.Now().UnixNano()}
+}
+
+func (e TestEvent) ID() uuid.UUID {
+	return e.id
+}
+
+func (e TestEvent) Source() string {
+	return "fsmtest"
+}
+
+func (e TestEvent) Name() string {
+	return e.name
+}
+
+func (e TestEvent) Timestamp() int64 {
+	return e.timestamp
+}
+
+// Step is an event sent to the machine and the outcome expected once it has been processed
+type Step struct {
+	// Event is sent to the machine
+	Event fsm.Event
+	// State is the expected description of the machine's state after the event, it is not checked if empty
+	State string
+	// Err is the error expected from the event, matched using errors.Is. Use AnyError to expect any error
+	Err error
+	// Transitions expects the event to move the machine to a different state
+	Transitions bool
+	// Stays expects the machine to remain in the same state
+	Stays bool
+}
+
+// Script is a table-driven test case, a fresh machine is created for each script and driven through its steps
+type Script struct {
+	Name    string
+	Machine func() fsm.Machine
+	Steps   []Step
+}
+
+// Run sends the events of the steps to the machine in order and fails the test if the outcome of any step
+// is not what was expected. The results of the steps that were run are returned so further assertions can be
+// made about the transitions taken. Run stops at the first step that fails
+func Run(t testing.TB, m fsm.Machine, steps ...Step) []fsm.Result {
+	t.Helper()
+
+	results := make([]fsm.Result, 0, len(steps))
+
+	for i, step := range steps {
+		res, err := m.Send(context.Background(), step.Event)
+		results = append(results, res)
+
+		if problem := check(step, res, err); problem != "" {
+			t.Fatalf("step %d (%s): %s\n%s", i+1, step.Event.Name(), problem, transcript(results))
+			return results
+		}
+	}
+
+	return results
+}
+
+// RunScripts runs each script as a subtest, the machine created for a script is closed once the script has finished
+func RunScripts(t *testing.T, scripts ...Script) {
+	t.Helper()
+
+	for _, s := range scripts {
+		s := s
+
+		t.Run(s.Name, func(t *testing.T) {
+			m := s.Machine()
+			defer closeMachine(m)
+
+			Run(t, m, s.Steps...)
+		})
+	}
+}
+
+// closeMachine closes the machine if it can be closed, so the timers of machines created for a test stop
+func closeMachine(m fsm.Machine) {
+	if c, ok := m.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// check compares the outcome of the step with what was expected, returning a description of any difference
+func check(step Step, res fsm.Result, err error) string {
+	switch {
+	case step.Err == nil && err != nil:
+		return fmt.Sprintf("unexpected error - %v", err)
+	case step.Err == AnyError && err == nil:
+		return "expected an error, but the event was accepted"
+	case step.Err != nil && step.Err != AnyError && !errors.Is(err, step.Err):
+		return fmt.Sprintf("expected error %v, got %v", step.Err, err)
+	case step.State != "" && res.Current != step.State:
+		return fmt.Sprintf("expected state %s, got %s", step.State, res.Current)
+	case step.Transitions && !res.Transitioned:
+		return fmt.Sprintf("expected a transition from %s, but the machine stayed", res.Previous)
+	case step.Stays && res.Transitioned:
+		return fmt.Sprintf("expected the machine to stay in %s, but it moved to %s", res.Previous, res.Current)
+	}
+
+	return ""
+}
+
+func transcript(results []fsm.Result) string {
+	lines := make([]string, 0, len(results))
+
+	for i, r := range results {
+		lines = append(lines, fmt.Sprintf("  %d: %s -> %s", i+1, r.Previous, r.Current))
+	}
+
+	return "transitions:\n" + strings.Join(lines, "\n")
+}
+
+// Invariant is checked after every event sent during a property test, it returns an error if the invariant does not hold
+type Invariant func(m fsm.Machine, res fsm.Result, err error) error
+
+// OneOf is an invariant that checks the machine is always in one of the given states
+func OneOf(states ...string) Invariant {
+	return func(m fsm.Machine, res fsm.Result, _ error) error {
+		for _, s := range states {
+			if res.Current == s {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("machine is in unexpected state %s", res.Current)
+	}
+}
+
+// Property describes a property test that sends random sequences of events to fresh machines
+type Property struct {
+	// Machine creates the machine for each run
+	Machine func() fsm.Machine
+	// Events generates a random event, for example by choosing one of a set of events
+	Events func(*rand.Rand) fsm.Event
+	// Invariants are checked after every event
+	Invariants []Invariant
+	// Runs is the number of sequences sent, the default is 100
+	Runs int
+	// Length is the number of events in each sequence, the default is 20
+	Length int
+	// Seed seeds the random sequences, the current time is used if it is zero. The seed is reported
+	// when a test fails so the failure can be reproduced
+	Seed int64
+}
+
+// Choose returns an event generator that picks one of the named events at random
+func Choose(names ...string) func(*rand.Rand) fsm.Event {
+	return func(r *rand.Rand) fsm.Event {
+		return Event(names[r.Intn(len(names))])
+	}
+}
+
+// Check runs the property test, failing the test with the shortest sequence of events it can find that breaks an invariant
+func Check(t testing.TB, p Property) {
+	t.Helper()
+
+	if p.Runs <= 0 {
+		p.Runs = 100
+	}
+
+	if p.Length <= 0 {
+		p.Length = 20
+	}
+
+	if p.Seed == 0 {
+		p.Seed = time.Now().UnixNano()
+	}
+
+	r := rand.New(rand.NewSource(p.Seed))
+
+	for run := 0; run < p.Runs; run++ {
+		events := make([]fsm.Event, p.Length)
+		for i := range events {
+			events[i] = p.Events(r)
+		}
+
+		if violation := p.violation(events); violation != nil {
+			events = p.shrink(events)
+			violation = p.violation(events)
+
+			names := make([]string, len(events))
+			for i, e := range events {
+				names[i] = e.Name()
+			}
+
+			t.Fatalf("invariant violated on run %d with seed %d after events [%s]: %v",
+				run+1, p.Seed, strings.Join(names, ", "), violation)
+
+			return
+		}
+	}
+}
+
+// violation sends the events to a fresh machine and returns the first invariant that does not hold
+func (p Property) violation(events []fsm.Event) error {
+	m := p.Machine()
+	defer closeMachine(m)
+
+	for _, e := range events {
+		res, err := m.Send(context.Background(), e)
+
+		for _, inv := range p.Invariants {
+			if v := inv(m, res, err); v != nil {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+// shrink removes events from the sequence one at a time for as long as the invariant is still violated
+func (p Property) shrink(events []fsm.Event) []fsm.Event {
+	for i := 0; i < len(events); {
+		candidate := append(append([]fsm.Event{}, events[:i]...), events[i+1:]...)
+
+		if p.violation(candidate) != nil {
+			events = candidate
+			continue
+		}
+
+		i++
+	}
+
+	return events
+}
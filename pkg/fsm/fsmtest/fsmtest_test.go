@@ -0,0 +1,134 @@
+package fsmtest_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+	"github.com/birchwood-langham/bootstrap/pkg/fsm/fsmtest"
+)
+
+// recorder captures failures so the tests can check the harness reports them
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func turnstile() fsm.Machine {
+	m, _, err := fsm.Define().
+		Initial("locked").
+		From("locked").On("coin").To("unlocked").
+		From("unlocked").On("push").To("locked").
+		From("unlocked").On("coin").To("unlocked").
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+func TestRunScripts(t *testing.T) {
+	coin := fsmtest.Event("coin")
+
+	fsmtest.RunScripts(t,
+		fsmtest.Script{
+			Name:    "coin then push",
+			Machine: turnstile,
+			Steps: []fsmtest.Step{
+				{Event: coin, State: "unlocked", Transitions: true},
+				// the same event delivered again is skipped
+				{Event: coin, State: "unlocked", Stays: true},
+				{Event: fsmtest.Event("push"), State: "locked", Transitions: true},
+			},
+		},
+		fsmtest.Script{
+			Name:    "push while locked",
+			Machine: turnstile,
+			Steps: []fsmtest.Step{
+				{Event: fsmtest.Event("push"), State: "locked", Err: fsmtest.AnyError},
+			},
+		},
+	)
+}
+
+func TestRun_Failures(t *testing.T) {
+	testCases := []struct {
+		name string
+		step fsmtest.Step
+		want string
+	}{
+		{
+			name: "wrong state",
+			step: fsmtest.Step{Event: fsmtest.Event("coin"), State: "locked"},
+			want: "expected state locked, got unlocked",
+		},
+		{
+			name: "unexpected error",
+			step: fsmtest.Step{Event: fsmtest.Event("push")},
+			want: "unexpected error",
+		},
+		{
+			name: "missing error",
+			step: fsmtest.Step{Event: fsmtest.Event("coin"), Err: fsmtest.AnyError},
+			want: "expected an error",
+		},
+		{
+			name: "wrong error",
+			step: fsmtest.Step{Event: fsmtest.Event("push"), Err: errors.New("other")},
+			want: "expected error other",
+		},
+		{
+			name: "stays",
+			step: fsmtest.Step{Event: fsmtest.Event("coin"), Stays: true},
+			want: "expected the machine to stay in locked",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &recorder{TB: t}
+
+			fsmtest.Run(r, turnstile(), tc.step)
+
+			if len(r.failures) != 1 || !strings.Contains(r.failures[0], tc.want) {
+				t.Errorf("expected failure containing %q, got %v", tc.want, r.failures)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fsmtest.Check(t, fsmtest.Property{
+		Machine:    turnstile,
+		Events:     fsmtest.Choose("coin", "push"),
+		Invariants: []fsmtest.Invariant{fsmtest.OneOf("locked", "unlocked")},
+		Seed:       1,
+	})
+}
+
+func TestCheck_Shrinks(t *testing.T) {
+	r := &recorder{TB: t}
+
+	fsmtest.Check(r, fsmtest.Property{
+		Machine:    turnstile,
+		Events:     fsmtest.Choose("coin", "push"),
+		Invariants: []fsmtest.Invariant{fsmtest.OneOf("locked")},
+		Seed:       1,
+	})
+
+	if len(r.failures) != 1 || !strings.Contains(r.failures[0], "after events [coin]") {
+		t.Errorf("expected the failing sequence to be shrunk to a single coin, got %v", r.failures)
+	}
+}
@@ -0,0 +1,528 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxWalk is the maximum number of states visited by Walk, it stops states that create new states
+// indefinitely, such as a counter, from being walked forever
+const maxWalk = 1000
+
+var definitionsMu sync.RWMutex
+var definitions = make(map[string]*Definition)
+var walks = make(map[string]State)
+
+// GraphNotRegisteredError is returned by LookupGraph when no machine has been registered with the name
+var GraphNotRegisteredError = errors.New("state machine has not been registered")
+
+// GraphState is a state drawn in a graph
+type GraphState struct {
+	Name     string
+	Parent   string
+	Final    bool
+	Parallel bool
+	History  bool
+}
+
+// Edge is a transition drawn in a graph
+type Edge struct {
+	From  string
+	To    string
+	Label string
+}
+
+// Graph describes the states and transitions of a state machine so it can be exported as a diagram
+type Graph struct {
+	Name    string
+	Initial string
+	States  []GraphState
+	Edges   []Edge
+}
+
+// Graph describes the states and transitions of the definition
+func (d *Definition) Graph(name string) (Graph, error) {
+	if err := d.Validate(); err != nil {
+		return Graph{}, err
+	}
+
+	g := Graph{
+		Name:    name,
+		Initial: d.initialState(),
+	}
+
+	for _, s := range d.states {
+		g.States = append(g.States, GraphState{
+			Name:     s,
+			Parent:   d.parents[s],
+			Final:    d.final[s],
+			Parallel: d.parallel[s],
+			History:  d.history[s],
+		})
+	}
+
+	for _, t := range d.transitions {
+		label := t.event
+
+		if t.after > 0 {
+			label = "after " + t.after.String()
+		}
+
+		if len(t.guards) > 0 {
+			label += " [guarded]"
+		}
+
+		g.Edges = append(g.Edges, Edge{From: t.from, To: t.to, Label: label})
+	}
+
+	return g, nil
+}
+
+// Walk describes a machine built from hand-written states by following the transitions of every state that
+// implements Transitioner, starting from the initial state. States are identified by their description and
+// transitions are labelled with their Name. The Next function of every transition is called to find the state it
+// leads to, regardless of its checks, so it must not have side effects
+func Walk(name string, init State) Graph {
+	g := Graph{
+		Name:    name,
+		Initial: init.Description(),
+	}
+
+	visited := make(map[string]bool)
+	queue := []State{init}
+
+	for len(queue) > 0 && len(visited) < maxWalk {
+		s := queue[0]
+		queue = queue[1:]
+
+		if visited[s.Description()] {
+			continue
+		}
+
+		visited[s.Description()] = true
+
+		t, ok := s.(Transitioner)
+		if !ok {
+			g.States = append(g.States, GraphState{Name: s.Description()})
+			continue
+		}
+
+		transitions := t.Transitions()
+
+		g.States = append(g.States, GraphState{Name: s.Description(), Final: len(transitions) == 0})
+
+		for _, tr := range transitions {
+			if tr.Next == nil {
+				continue
+			}
+
+			next := tr.Next(s)
+			if next == nil {
+				continue
+			}
+
+			g.Edges = append(g.Edges, Edge{From: s.Description(), To: next.Description(), Label: tr.Name})
+			queue = append(queue, next)
+		}
+	}
+
+	return g
+}
+
+// RegisterDefinition makes the definition available to the fsm commands under the given name
+func RegisterDefinition(name string, d *Definition) {
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+
+	delete(walks, name)
+	definitions[name] = d
+}
+
+// RegisterStates makes a machine built from hand-written states available to the fsm commands under the given name,
+// it is drawn using Walk starting from the initial state
+func RegisterStates(name string, init State) {
+	definitionsMu.Lock()
+	defer definitionsMu.Unlock()
+
+	delete(definitions, name)
+	walks[name] = init
+}
+
+// LookupGraph describes the definition or hand-written machine registered with the given name
+func LookupGraph(name string) (Graph, error) {
+	definitionsMu.RLock()
+	d, isDefinition := definitions[name]
+	init, isWalk := walks[name]
+	definitionsMu.RUnlock()
+
+	switch {
+	case isDefinition:
+		return d.Graph(name)
+	case isWalk:
+		return Walk(name, init), nil
+	default:
+		return Graph{}, fmt.Errorf("%w: %s", GraphNotRegisteredError, name)
+	}
+}
+
+// Graphs returns the names of the registered definitions and hand-written machines in alphabetical order
+func Graphs() []string {
+	definitionsMu.RLock()
+	defer definitionsMu.RUnlock()
+
+	names := make([]string, 0, len(definitions)+len(walks))
+	for name := range definitions {
+		names = append(names, name)
+	}
+
+	for name := range walks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// LookupDefinition returns the definition registered with the given name
+func LookupDefinition(name string) (*Definition, bool) {
+	definitionsMu.RLock()
+	defer definitionsMu.RUnlock()
+
+	d, ok := definitions[name]
+	return d, ok
+}
+
+// Definitions returns the names of the registered definitions in alphabetical order
+func Definitions() []string {
+	definitionsMu.RLock()
+	defer definitionsMu.RUnlock()
+
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Render exports the graph in the given format, which can be dot, mermaid or plantuml
+func (g Graph) Render(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "dot", "graphviz":
+		return g.DOT(), nil
+	case "mermaid":
+		return g.Mermaid(), nil
+	case "plantuml", "puml":
+		return g.PlantUML(), nil
+	default:
+		return "", fmt.Errorf("unsupported graph format %s, use dot, mermaid or plantuml", format)
+	}
+}
+
+// DOT exports the graph in the Graphviz DOT language. Composite and parallel states are drawn as clusters,
+// and transitions to or from them are drawn to the edge of the cluster
+func (g Graph) DOT() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %s {\n", quote(g.Name))
+	b.WriteString("\tcompound=true;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+	b.WriteString("\t__start [shape=point];\n")
+
+	var cluster func(parent, indent string)
+	cluster = func(parent, indent string) {
+		for _, s := range g.children(parent) {
+			if !g.composite(s.Name) {
+				shape := ""
+				if s.Final {
+					shape = ", peripheries=2"
+				}
+
+				fmt.Fprintf(&b, "%s%s [label=%s%s];\n", indent, quote(s.Name), quote(s.Name), shape)
+				continue
+			}
+
+			label := s.Name
+			if s.Parallel {
+				label += " (parallel)"
+			}
+
+			if s.History {
+				label += " (H)"
+			}
+
+			fmt.Fprintf(&b, "%ssubgraph %s {\n", indent, quote("cluster_"+s.Name))
+			fmt.Fprintf(&b, "%s\tlabel=%s;\n", indent, quote(label))
+
+			if s.Parallel {
+				fmt.Fprintf(&b, "%s\tstyle=dashed;\n", indent)
+			}
+
+			cluster(s.Name, indent+"\t")
+			fmt.Fprintf(&b, "%s}\n", indent)
+		}
+	}
+
+	cluster("", "\t")
+
+	fmt.Fprintf(&b, "\t__start -> %s%s;\n", quote(g.anchor(g.Initial)), g.clusterAttr("lhead", g.Initial, ""))
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s%s;\n", quote(g.anchor(e.From)), quote(g.anchor(e.To)), g.clusterAttr("ltail", e.From, g.clusterAttr("lhead", e.To, label(e.Label))))
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid exports the graph as a Mermaid state diagram
+func (g Graph) Mermaid() string {
+	var b strings.Builder
+
+	ids := g.identifiers()
+
+	b.WriteString("stateDiagram-v2\n")
+
+	var block func(parent, indent string)
+	block = func(parent, indent string) {
+		children := g.children(parent)
+
+		for i, s := range children {
+			id := ids[s.Name]
+
+			switch {
+			case id != s.Name:
+				fmt.Fprintf(&b, "%sstate \"%s\" as %s\n", indent, s.Name, id)
+			case !g.composite(s.Name):
+				fmt.Fprintf(&b, "%s%s\n", indent, id)
+			}
+
+			if g.composite(s.Name) {
+				fmt.Fprintf(&b, "%sstate %s {\n", indent, id)
+
+				if inner := g.children(s.Name); len(inner) > 0 && !s.Parallel {
+					fmt.Fprintf(&b, "%s\t[*] --> %s\n", indent, ids[inner[0].Name])
+				}
+
+				block(s.Name, indent+"\t")
+				fmt.Fprintf(&b, "%s}\n", indent)
+			}
+
+			if s.Final {
+				fmt.Fprintf(&b, "%s%s --> [*]\n", indent, id)
+			}
+
+			// the regions of a parallel state are separated by --
+			if p, ok := g.state(parent); ok && p.Parallel && i < len(children)-1 {
+				fmt.Fprintf(&b, "%s--\n", indent)
+			}
+		}
+	}
+
+	block("", "\t")
+
+	fmt.Fprintf(&b, "\t[*] --> %s\n", ids[g.Initial])
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s --> %s", ids[e.From], ids[e.To])
+
+		if e.Label != "" {
+			fmt.Fprintf(&b, " : %s", e.Label)
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// PlantUML exports the graph as a PlantUML state diagram
+func (g Graph) PlantUML() string {
+	var b strings.Builder
+
+	ids := g.identifiers()
+
+	b.WriteString("@startuml\n")
+
+	if g.Name != "" {
+		fmt.Fprintf(&b, "title %s\n", g.Name)
+	}
+
+	var block func(parent, indent string)
+	block = func(parent, indent string) {
+		children := g.children(parent)
+
+		for i, s := range children {
+			id := ids[s.Name]
+
+			if !g.composite(s.Name) {
+				fmt.Fprintf(&b, "%sstate \"%s\" as %s\n", indent, s.Name, id)
+			} else {
+				fmt.Fprintf(&b, "%sstate \"%s\" as %s {\n", indent, s.Name, id)
+
+				if inner := g.children(s.Name); len(inner) > 0 && !s.Parallel {
+					fmt.Fprintf(&b, "%s\t[*] --> %s\n", indent, ids[inner[0].Name])
+				}
+
+				block(s.Name, indent+"\t")
+				fmt.Fprintf(&b, "%s}\n", indent)
+			}
+
+			if s.Final {
+				fmt.Fprintf(&b, "%s%s --> [*]\n", indent, id)
+			}
+
+			if p, ok := g.state(parent); ok && p.Parallel && i < len(children)-1 {
+				fmt.Fprintf(&b, "%s--\n", indent)
+			}
+		}
+	}
+
+	block("", "")
+
+	fmt.Fprintf(&b, "[*] --> %s\n", ids[g.Initial])
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "%s --> %s", ids[e.From], ids[e.To])
+
+		if e.Label != "" {
+			fmt.Fprintf(&b, " : %s", e.Label)
+		}
+
+		b.WriteString("\n")
+	}
+
+	b.WriteString("@enduml\n")
+
+	return b.String()
+}
+
+func (g Graph) state(name string) (GraphState, bool) {
+	for _, s := range g.States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return GraphState{}, false
+}
+
+func (g Graph) children(parent string) []GraphState {
+	children := make([]GraphState, 0)
+
+	for _, s := range g.States {
+		if s.Parent == parent {
+			children = append(children, s)
+		}
+	}
+
+	return children
+}
+
+func (g Graph) composite(name string) bool {
+	for _, s := range g.States {
+		if s.Parent == name && name != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anchor returns the node DOT edges are drawn to for the state, composite states are clusters
+// so edges are drawn to their first innermost state and clipped at the edge of the cluster
+func (g Graph) anchor(name string) string {
+	for g.composite(name) {
+		name = g.children(name)[0].Name
+	}
+
+	return name
+}
+
+func (g Graph) clusterAttr(attr, name, rest string) string {
+	if !g.composite(name) {
+		return rest
+	}
+
+	a := fmt.Sprintf("%s=%s", attr, quote("cluster_"+name))
+
+	if rest == "" {
+		return " [" + a + "]"
+	}
+
+	return " [" + a + ", " + strings.TrimPrefix(rest, " [")
+}
+
+func label(l string) string {
+	if l == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" [label=%s]", quote(l))
+}
+
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// identifiers assigns every state in the graph an identifier that can be used in Mermaid and PlantUML diagrams.
+// Characters that cannot be used in an identifier are replaced with underscores, and names that would end up with
+// the same identifier, such as "a b" and "a-b", are numbered so each state keeps its own identifier
+func (g Graph) identifiers() map[string]string {
+	ids := make(map[string]string)
+	taken := make(map[string]bool)
+
+	names := []string{g.Initial}
+	for _, s := range g.States {
+		names = append(names, s.Name)
+	}
+
+	for _, e := range g.Edges {
+		names = append(names, e.From, e.To)
+	}
+
+	// names that are already valid identifiers keep them, so only the names that were changed are numbered
+	for _, name := range names {
+		if _, ok := ids[name]; !ok && identifier(name) == name && name != "" {
+			ids[name] = name
+			taken[name] = true
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := ids[name]; ok {
+			continue
+		}
+
+		base := identifier(name)
+		id := base
+
+		for i := 2; id == "" || taken[id]; i++ {
+			id = fmt.Sprintf("%s_%d", base, i)
+		}
+
+		ids[name] = id
+		taken[id] = true
+	}
+
+	return ids
+}
+
+// identifier converts a state name into an identifier by replacing the characters that cannot be used in one
+func identifier(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
@@ -0,0 +1,202 @@
+package fsm_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+// light is a hand-written state that cycles through the colours of a traffic light
+type light struct {
+	colour string
+}
+
+func (l light) ID() uuid.UUID                               { return uuid.NewSHA1(uuid.NameSpaceOID, []byte(l.colour)) }
+func (l light) Description() string                         { return l.colour }
+func (l light) Execute(fsm.Event) error                     { return nil }
+func (l light) Next() fsm.State                             { return fsm.Next(l, l.Transitions()...) }
+func (l light) WithTransitions(...fsm.Transition) fsm.State { return l }
+
+func (l light) Transitions() []fsm.Transition {
+	next := map[string]string{"red": "green", "green": "amber", "amber": "red"}
+
+	return []fsm.Transition{
+		{
+			Name: "change",
+			Next: func(fsm.State) fsm.State { return light{colour: next[l.colour]} },
+		},
+	}
+}
+
+func TestDefinition_Graph(t *testing.T) {
+	g, err := worker().
+		From("running").After(time.Minute).To("paused").
+		Graph("worker")
+
+	if err != nil {
+		t.Fatalf("could not create graph - %v", err)
+	}
+
+	testCases := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: "dot",
+			want: []string{
+				`digraph "worker" {`,
+				`subgraph "cluster_running" {`,
+				`label="running (H)";`,
+				`__start -> "idle";`,
+				`"idle" -> "fetching" [lhead="cluster_running", label="start"];`,
+				`"fetching" -> "paused" [ltail="cluster_running", label="after 1m0s"];`,
+			},
+		},
+		{
+			format: "mermaid",
+			want: []string{
+				"stateDiagram-v2",
+				"state running {",
+				"[*] --> fetching",
+				"[*] --> idle",
+				"idle --> running : start",
+				"running --> paused : after 1m0s",
+			},
+		},
+		{
+			format: "plantuml",
+			want: []string{
+				"@startuml",
+				`state "running" as running {`,
+				"[*] --> idle",
+				"paused --> running : resume",
+				"@enduml",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			got, err := g.Render(tc.format)
+			if err != nil {
+				t.Fatalf("could not render graph - %v", err)
+			}
+
+			for _, w := range tc.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("expected %s graph to contain %q, got:\n%s", tc.format, w, got)
+				}
+			}
+		})
+	}
+
+	if _, err := g.Render("svg"); err == nil {
+		t.Errorf("expected an error rendering an unsupported format")
+	}
+}
+
+func TestDefinition_GraphParallel(t *testing.T) {
+	g, err := player().Graph("player")
+	if err != nil {
+		t.Fatalf("could not create graph - %v", err)
+	}
+
+	got := g.Mermaid()
+
+	if !strings.Contains(got, "}\n\t\t--\n\t\tstate volume {") {
+		t.Errorf("expected the regions of the parallel state to be separated, got:\n%s", got)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	g := fsm.Walk("traffic light", light{colour: "red"})
+
+	if len(g.States) != 3 || len(g.Edges) != 3 {
+		t.Fatalf("expected 3 states and 3 transitions, got %+v", g)
+	}
+
+	want := "red --> green : change"
+	if got := g.Mermaid(); !strings.Contains(got, want) {
+		t.Errorf("expected graph to contain %q, got:\n%s", want, got)
+	}
+}
+
+func TestGraph_UniqueIdentifiers(t *testing.T) {
+	g := fsm.Graph{
+		Initial: "a b",
+		States:  []fsm.GraphState{{Name: "a b"}, {Name: "a-b"}, {Name: "a_b"}},
+		Edges:   []fsm.Edge{{From: "a b", To: "a-b"}, {From: "a-b", To: "a_b"}},
+	}
+
+	testCases := []struct {
+		name   string
+		render func() string
+		want   []string
+	}{
+		{
+			name:   "mermaid",
+			render: g.Mermaid,
+			want:   []string{`state "a b" as a_b_2`, `state "a-b" as a_b_3`, "\ta_b\n", "a_b_2 --> a_b_3", "a_b_3 --> a_b"},
+		},
+		{
+			name:   "plantuml",
+			render: g.PlantUML,
+			want:   []string{`state "a b" as a_b_2`, `state "a-b" as a_b_3`, `state "a_b" as a_b`, "a_b_2 --> a_b_3", "a_b_3 --> a_b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.render()
+
+			for _, want := range tc.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected graph to contain %q, got:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterStates(t *testing.T) {
+	fsm.RegisterStates("traffic light", light{colour: "red"})
+
+	g, err := fsm.LookupGraph("traffic light")
+	if err != nil {
+		t.Fatalf("could not look up graph - %v", err)
+	}
+
+	if len(g.States) != 3 {
+		t.Errorf("expected the registered states to be walked, got %+v", g)
+	}
+
+	if _, err := fsm.LookupGraph("unknown"); !errors.Is(err, fsm.GraphNotRegisteredError) {
+		t.Errorf("expected unknown machine not to be registered, got %v", err)
+	}
+
+	found := false
+	for _, name := range fsm.Graphs() {
+		found = found || name == "traffic light"
+	}
+
+	if !found {
+		t.Errorf("expected traffic light to be listed, got %v", fsm.Graphs())
+	}
+}
+
+func TestRegisterDefinition(t *testing.T) {
+	fsm.RegisterDefinition("turnstile", turnstile())
+
+	if _, ok := fsm.LookupDefinition("turnstile"); !ok {
+		t.Errorf("expected turnstile definition to be registered")
+	}
+
+	if _, ok := fsm.LookupDefinition("unknown"); ok {
+		t.Errorf("expected unknown definition not to be registered")
+	}
+}
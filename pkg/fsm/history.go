@@ -0,0 +1,177 @@
+package fsm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultHistorySize is the number of transitions a machine keeps in its history unless WithHistory is used
+const DefaultHistorySize = 100
+
+// TransitionRecord describes a transition taken by the machine
+type TransitionRecord struct {
+	// MachineID is the unique id of the machine
+	MachineID uuid.UUID
+	// From is the description of the state the machine left
+	From string
+	// To is the description of the state the machine entered
+	To string
+	// EventID is the id of the event that triggered the transition
+	EventID uuid.UUID
+	// EventName is the name of the event that triggered the transition
+	EventName string
+	// Timestamp is the time the transition took place
+	Timestamp time.Time
+	// Duration is the time the machine spent in the state it left
+	Duration time.Duration
+}
+
+// Observer is notified of the transitions taken by a machine. Observers are notified once the machine has finished
+// processing the event and has been unlocked, so they can call the machine, including sending it events. They are
+// notified one transition at a time in the order the transitions took place
+type Observer interface {
+	OnTransition(TransitionRecord)
+}
+
+// ObserverFunc allows a function to be used as an Observer
+type ObserverFunc func(TransitionRecord)
+
+func (f ObserverFunc) OnTransition(r TransitionRecord) {
+	f(r)
+}
+
+// Acceptor is implemented by states that can list the names of the events they have transitions for
+type Acceptor interface {
+	Accepts() []string
+}
+
+// State returns the current state of the machine
+func (m *machine) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.current
+}
+
+// Accepts returns the names of the events the current state has transitions for, in alphabetical order.
+// States that do not implement Acceptor do not declare their events, so nil is returned
+func (m *machine) Accepts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.current.(Acceptor)
+	if !ok {
+		return nil
+	}
+
+	return a.Accepts()
+}
+
+// History returns the most recent transitions of the machine, oldest first. Transitions replayed from the journal
+// by Rebuild are not included
+func (m *machine) History() []TransitionRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]TransitionRecord{}, m.history...)
+}
+
+// Subscribe registers an observer that is notified of every transition, the returned function unsubscribes it
+func (m *machine) Subscribe(o Observer) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.observerSeq++
+	id := m.observerSeq
+	m.observers[id] = o
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.observers, id)
+	}
+}
+
+// notification is a transition waiting to be delivered to the observers that were subscribed when it took place
+type notification struct {
+	record    TransitionRecord
+	observers []Observer
+}
+
+// recordTransition adds the transition to the history and queues it for the observers, which are notified by notify
+// once the machine has been unlocked. The caller must hold the machine's lock
+func (m *machine) recordTransition(from, to State, event Event) {
+	now := m.clock.Now()
+
+	r := TransitionRecord{
+		MachineID: m.id,
+		From:      from.Description(),
+		To:        to.Description(),
+		EventID:   event.ID(),
+		EventName: event.Name(),
+		Timestamp: now,
+		Duration:  now.Sub(m.enteredAt),
+	}
+
+	m.enteredAt = now
+
+	if m.historySize > 0 {
+		m.history = append(m.history, r)
+
+		if len(m.history) > m.historySize {
+			m.history = m.history[len(m.history)-m.historySize:]
+		}
+	}
+
+	if len(m.observers) == 0 {
+		return
+	}
+
+	ids := make([]int, 0, len(m.observers))
+	for id := range m.observers {
+		ids = append(ids, id)
+	}
+
+	// observers are notified in the order they subscribed
+	sort.Ints(ids)
+
+	n := notification{record: r, observers: make([]Observer, 0, len(ids))}
+	for _, id := range ids {
+		n.observers = append(n.observers, m.observers[id])
+	}
+
+	m.notifications = append(m.notifications, n)
+}
+
+// notify delivers the queued transitions to the observers, it must be called without holding the machine's lock.
+// Only one goroutine delivers notifications at a time so observers see the transitions in order, if another
+// goroutine is already delivering them, including an observer that sent an event to the machine, it delivers the
+// transitions queued here as well
+func (m *machine) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.notifying {
+		return
+	}
+
+	m.notifying = true
+
+	for len(m.notifications) > 0 {
+		n := m.notifications[0]
+		m.notifications = m.notifications[1:]
+
+		m.mu.Unlock()
+
+		for _, o := range n.observers {
+			o.OnTransition(n.record)
+		}
+
+		m.mu.Lock()
+	}
+
+	m.notifying = false
+}
@@ -0,0 +1,128 @@
+package fsm_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+func TestMachine_History(t *testing.T) {
+	ctx := context.Background()
+	clock := fsm.NewManualClock(time.Now())
+
+	m, _, err := turnstile().Build(uuid.New(), "turnstile", fsm.WithClock(clock), fsm.WithHistory(2))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	for _, name := range []string{"coin", "push", "coin"} {
+		clock.Advance(time.Second)
+
+		if _, err := m.Send(ctx, event(name)); err != nil {
+			t.Fatalf("unexpected error - %v", err)
+		}
+	}
+
+	history := m.History()
+
+	if len(history) != 2 {
+		t.Fatalf("expected the history to be limited to 2 transitions, got %d", len(history))
+	}
+
+	if history[0].From != "unlocked" || history[0].To != "locked" || history[0].EventName != "push" {
+		t.Errorf("unexpected transition %+v", history[0])
+	}
+
+	if history[1].Duration != time.Second {
+		t.Errorf("expected the machine to have spent 1s in the state, got %v", history[1].Duration)
+	}
+}
+
+func TestMachine_Accepts(t *testing.T) {
+	m, _, err := worker().Build(uuid.New(), "worker")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if got, want := m.Accepts(), []string{"start"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected idle to accept %v, got %v", want, got)
+	}
+
+	if _, err := m.Send(context.Background(), event("start")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	// fetching accepts its own events and the events of the running state it is nested in
+	if got, want := m.Accepts(), []string{"fetched", "pause", "stop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected fetching to accept %v, got %v", want, got)
+	}
+
+	if m.State().Description() != "fetching" {
+		t.Errorf("expected the current state to be fetching, got %s", m.State().Description())
+	}
+}
+
+func TestMachine_Subscribe(t *testing.T) {
+	m, _, err := turnstile().Build(uuid.New(), "turnstile")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	observed := make([]string, 0)
+
+	unsubscribe := m.Subscribe(fsm.ObserverFunc(func(r fsm.TransitionRecord) {
+		observed = append(observed, r.From+"->"+r.To)
+	}))
+
+	_, _ = m.Send(context.Background(), event("coin"))
+	unsubscribe()
+	_, _ = m.Send(context.Background(), event("push"))
+
+	if want := []string{"locked->unlocked"}; !reflect.DeepEqual(observed, want) {
+		t.Errorf("expected observed transitions %v, got %v", want, observed)
+	}
+}
+
+func TestMachine_ObserverUsesMachine(t *testing.T) {
+	m, _, err := turnstile().Build(uuid.New(), "turnstile")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	observed := make([]string, 0)
+
+	m.Subscribe(fsm.ObserverFunc(func(r fsm.TransitionRecord) {
+		observed = append(observed, fmt.Sprintf("%s->%s in %s after %d", r.From, r.To, m.Current(), len(m.History())))
+
+		// the push is delivered to the observer once it has returned, after the coin
+		if r.To == "unlocked" {
+			if _, err := m.Send(context.Background(), event("push")); err != nil {
+				t.Errorf("could not send event from observer - %v", err)
+			}
+		}
+	}))
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, _ = m.Send(context.Background(), event("coin"))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an observer using the machine not to deadlock")
+	}
+
+	want := []string{"locked->unlocked in unlocked after 1", "unlocked->locked in locked after 2"}
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("expected observed transitions %v, got %v", want, observed)
+	}
+}
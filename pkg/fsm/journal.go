@@ -0,0 +1,486 @@
+package fsm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	segmentExt = ".log"
+	// DefaultSegmentSize is the size in bytes a journal segment can grow to before a new segment is started
+	DefaultSegmentSize = 64 * 1024 * 1024
+)
+
+type replayingKey struct{}
+
+// Record is an event that has been accepted by a state machine and appended to a journal
+type Record struct {
+	// Sequence is the position of the record in the journal, assigned when the record is appended
+	Sequence uint64 `json:"seq"`
+	// MachineID is the unique id of the machine that accepted the event
+	MachineID uuid.UUID `json:"machine-id"`
+	// EventID is the unique id of the event
+	EventID uuid.UUID `json:"event-id"`
+	// Name is the name of the event
+	Name string `json:"name"`
+	// Source identifies where the event came from
+	Source string `json:"source"`
+	// Timestamp is the time of the event as nanoseconds past epoch
+	Timestamp int64 `json:"timestamp"`
+	// Data is the event encoded by the machine's EventCodec
+	Data []byte `json:"data"`
+}
+
+// Journal is a durable, append-only log of the events accepted by state machines
+type Journal interface {
+	// Append adds the record to the end of the journal and returns the sequence assigned to it
+	Append(context.Context, Record) (uint64, error)
+	// Replay calls the function, in order, for every record of the machine with a sequence after the given sequence
+	Replay(ctx context.Context, machineID uuid.UUID, after uint64, fn func(Record) error) error
+}
+
+// EventCodec converts events to and from bytes so they can be written to a journal
+type EventCodec interface {
+	Encode(Event) ([]byte, error)
+	Decode([]byte) (Event, error)
+}
+
+// IsReplaying checks if the context belongs to a machine that is replaying events from its journal,
+// hooks and actions can use it to avoid repeating side effects that have already taken place
+func IsReplaying(ctx context.Context) bool {
+	replaying, _ := ctx.Value(replayingKey{}).(bool)
+	return replaying
+}
+
+// Rebuild recreates a state machine from its journal. If the machine has a snapshot store and a snapshot
+// has been saved, the machine is restored from the snapshot using the factory and only the events journaled
+// after the snapshot are replayed, otherwise the machine starts in the initial state and every event is replayed.
+// The journal, codec and snapshot store must be provided using the WithJournal and WithSnapshotStore options
+func Rebuild(ctx context.Context, id uuid.UUID, name string, init State, factory StateFactory, opts ...Option) (*machine, chan error, error) {
+	m, errCh := New(id, name, init, opts...)
+
+	if m.journal == nil {
+		return nil, nil, errors.New("a journal is required to rebuild a state machine")
+	}
+
+	if m.snapshots != nil {
+		snap, err := m.snapshots.Load(ctx, id)
+
+		switch {
+		case err == nil:
+			m.stopTimers()
+
+			m, errCh, err = Restore(snap, factory, opts...)
+			if err != nil {
+				return nil, nil, err
+			}
+		case !errors.Is(err, SnapshotNotFoundError):
+			return nil, nil, fmt.Errorf("could not load snapshot: %w", err)
+		}
+	}
+
+	if err := m.replay(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return m, errCh, nil
+}
+
+// replay processes every journaled event after the machine's current sequence, then saves a snapshot
+// so the events do not need to be replayed again
+func (m *machine) replay(ctx context.Context) error {
+	m.replaying = true
+	defer func() {
+		m.replaying = false
+	}()
+
+	replayCtx := context.WithValue(ctx, replayingKey{}, true)
+
+	err := m.journal.Replay(ctx, m.id, m.sequence, func(r Record) error {
+		event, err := m.codec.Decode(r.Data)
+		if err != nil {
+			return fmt.Errorf("could not decode journaled event %d: %w", r.Sequence, err)
+		}
+
+		if _, err := m.handle(replayCtx, event); err != nil {
+			return fmt.Errorf("could not replay journaled event %d: %w", r.Sequence, err)
+		}
+
+		m.sequence = r.Sequence
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.replaying = false
+	m.sinceSnapshot = 0
+
+	if m.snapshots == nil {
+		return nil
+	}
+
+	snap, err := m.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return m.snapshots.Save(ctx, snap)
+}
+
+// journalEvent appends the accepted event to the journal
+func (m *machine) journalEvent(ctx context.Context, event Event) error {
+	if m.journal == nil || m.replaying {
+		return nil
+	}
+
+	data, err := m.codec.Encode(event)
+	if err != nil {
+		return fmt.Errorf("could not encode event %s: %w", event.ID(), err)
+	}
+
+	seq, err := m.journal.Append(ctx, Record{
+		MachineID: m.id,
+		EventID:   event.ID(),
+		Name:      event.Name(),
+		Source:    event.Source(),
+		Timestamp: event.Timestamp(),
+		Data:      data,
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not append event %s to journal: %w", event.ID(), err)
+	}
+
+	m.sequence = seq
+	m.sinceSnapshot++
+
+	return nil
+}
+
+// MemoryJournal is a Journal that keeps records in memory, it is intended for tests
+type MemoryJournal struct {
+	mu      sync.RWMutex
+	records []Record
+}
+
+// NewMemoryJournal creates an empty in-memory journal
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{}
+}
+
+func (j *MemoryJournal) Append(_ context.Context, r Record) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	r.Sequence = uint64(len(j.records)) + 1
+	j.records = append(j.records, r)
+
+	return r.Sequence, nil
+}
+
+func (j *MemoryJournal) Replay(ctx context.Context, machineID uuid.UUID, after uint64, fn func(Record) error) error {
+	j.mu.RLock()
+	records := append([]Record{}, j.records...)
+	j.mu.RUnlock()
+
+	for _, r := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if r.Sequence <= after || r.MachineID != machineID {
+			continue
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FileJournal is a Journal that writes records as JSON lines to segment files in a directory. Each segment is
+// named using the sequence of its first record, and a new segment is started when the current segment reaches
+// its maximum size
+type FileJournal struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	file        *os.File
+	size        int64
+	sequence    uint64
+}
+
+// OpenFileJournal opens the journal in the given directory, creating it if necessary. If the last record
+// in the journal was only partially written, it is removed
+func OpenFileJournal(dir string, segmentSize int64) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create journal directory: %w", err)
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	j := &FileJournal{
+		dir:         dir,
+		segmentSize: segmentSize,
+	}
+
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 {
+		return j, nil
+	}
+
+	last := segments[len(segments)-1]
+
+	if err := j.recover(last); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal segment: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	j.file = f
+	j.size = info.Size()
+
+	return j, nil
+}
+
+// recover reads the last segment to find the last sequence, truncating any partially written record
+func (j *FileJournal) recover(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read journal segment: %w", err)
+	}
+
+	complete := bytes.LastIndexByte(content, '\n') + 1
+
+	if complete < len(content) {
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return fmt.Errorf("could not remove partial journal record: %w", err)
+		}
+	}
+
+	start, err := segmentStart(path)
+	if err != nil {
+		return err
+	}
+
+	j.sequence = start - 1
+
+	// a record larger than the segment size is written to a segment of its own, so lines are split from the
+	// content rather than scanned with a buffer limited by the segment size
+	for _, line := range bytes.Split(content[:complete], []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("corrupt journal record in %s: %w", path, err)
+		}
+
+		j.sequence = r.Sequence
+	}
+
+	return nil
+}
+
+// Append writes the record to the current segment and syncs it to disk before returning
+func (j *FileJournal) Append(_ context.Context, r Record) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	r.Sequence = j.sequence + 1
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	line = append(line, '\n')
+
+	if j.file == nil || j.size+int64(len(line)) > j.segmentSize {
+		if err := j.roll(r.Sequence); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+
+	if err != nil {
+		return 0, fmt.Errorf("could not write journal record: %w", err)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("could not sync journal: %w", err)
+	}
+
+	j.sequence = r.Sequence
+
+	return r.Sequence, nil
+}
+
+// roll closes the current segment and starts a new one beginning with the given sequence
+func (j *FileJournal) roll(start uint64) error {
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(j.dir, fmt.Sprintf("%020d%s", start, segmentExt)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create journal segment: %w", err)
+	}
+
+	j.file = f
+	j.size = 0
+
+	return nil
+}
+
+// Replay reads the segments in order, skipping segments that only contain records before the given sequence
+func (j *FileJournal) Replay(ctx context.Context, machineID uuid.UUID, after uint64, fn func(Record) error) error {
+	segments, err := j.segments()
+	if err != nil {
+		return err
+	}
+
+	for i, path := range segments {
+		if i+1 < len(segments) {
+			next, err := segmentStart(segments[i+1])
+			if err != nil {
+				return err
+			}
+
+			if next <= after+1 {
+				continue
+			}
+		}
+
+		if err := j.replaySegment(ctx, path, machineID, after, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *FileJournal) replaySegment(ctx context.Context, path string, machineID uuid.UUID, after uint64, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := reader.ReadBytes('\n')
+
+		// a line without a newline is a record that is still being written
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("corrupt journal record in %s: %w", path, err)
+		}
+
+		if r.Sequence <= after || r.MachineID != machineID {
+			continue
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the current segment
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+// segments lists the segment files in the journal directory in sequence order
+func (j *FileJournal) segments() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]string, 0, len(entries))
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+
+		segments = append(segments, filepath.Join(j.dir, e.Name()))
+	}
+
+	// segment names are zero padded so they sort in sequence order
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+func segmentStart(path string) (uint64, error) {
+	start, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid journal segment name %s: %w", path, err)
+	}
+
+	return start, nil
+}
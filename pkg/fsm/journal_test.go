@@ -0,0 +1,384 @@
+package fsm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+type testCodec struct{}
+
+type encodedEvent struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+func (testCodec) Encode(e fsm.Event) ([]byte, error) {
+	return json.Marshal(encodedEvent{ID: e.ID(), Name: e.Name()})
+}
+
+func (testCodec) Decode(data []byte) (fsm.Event, error) {
+	var e encodedEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	return testEvent{id: e.ID, name: e.Name}, nil
+}
+
+func TestRebuild(t *testing.T) {
+	journals := map[string]func(t *testing.T) fsm.Journal{
+		"memory": func(*testing.T) fsm.Journal {
+			return fsm.NewMemoryJournal()
+		},
+		"file": func(t *testing.T) fsm.Journal {
+			j, err := fsm.OpenFileJournal(t.TempDir(), 256)
+			if err != nil {
+				t.Fatalf("could not open journal - %v", err)
+			}
+
+			t.Cleanup(func() { _ = j.Close() })
+
+			return j
+		},
+	}
+
+	for name, newJournal := range journals {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			journal := newJournal(t)
+			store := fsm.NewMemorySnapshotStore()
+			id := uuid.New()
+
+			opts := []fsm.Option{
+				fsm.WithJournal(journal, testCodec{}),
+				fsm.WithSnapshotStore(store),
+				fsm.WithSnapshotEvery(2),
+			}
+
+			m, errCh, err := worker().Build(id, "worker", opts...)
+			if err != nil {
+				t.Fatalf("could not build state machine - %v", err)
+			}
+
+			if errs := runMachine(m, errCh, event("start"), event("fetched"), event("pause")); len(errs) != 0 {
+				t.Fatalf("unexpected errors - %v", errs)
+			}
+
+			// only the first two events are covered by the snapshot, the pause must be replayed
+			snap, err := store.Load(ctx, id)
+			if err != nil {
+				t.Fatalf("could not load snapshot - %v", err)
+			}
+
+			if snap.State != "processing" || snap.Sequence != 2 {
+				t.Errorf("unexpected snapshot %+v", snap)
+			}
+
+			rebuilt, errCh, err := worker().Rebuild(ctx, id, "worker", opts...)
+			if err != nil {
+				t.Fatalf("could not rebuild state machine - %v", err)
+			}
+
+			if rebuilt.Current() != "paused" {
+				t.Errorf("expected rebuilt machine to be paused, but was %s", rebuilt.Current())
+			}
+
+			if errs := runMachine(rebuilt, errCh, event("resume")); len(errs) != 0 {
+				t.Fatalf("unexpected errors - %v", errs)
+			}
+
+			if rebuilt.Current() != "processing" {
+				t.Errorf("expected rebuilt machine to resume processing, but was %s", rebuilt.Current())
+			}
+		})
+	}
+}
+
+func TestRebuild_WithoutSnapshot(t *testing.T) {
+	ctx := context.Background()
+	journal := fsm.NewMemoryJournal()
+	id := uuid.New()
+
+	replayed := 0
+
+	def := turnstile().OnEnter("unlocked", func(ctx context.Context, _ fsm.Event) error {
+		if fsm.IsReplaying(ctx) {
+			replayed++
+		}
+
+		return nil
+	})
+
+	m, errCh, err := def.Build(id, "turnstile", fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if errs := runMachine(m, errCh, event("coin"), event("push"), event("coin")); len(errs) != 0 {
+		t.Fatalf("unexpected errors - %v", errs)
+	}
+
+	rebuilt, _, err := def.Rebuild(ctx, id, "turnstile", fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not rebuild state machine - %v", err)
+	}
+
+	if rebuilt.Current() != "unlocked" {
+		t.Errorf("expected rebuilt machine to be unlocked, but was %s", rebuilt.Current())
+	}
+
+	if replayed != 2 {
+		t.Errorf("expected 2 replayed entries into unlocked, got %d", replayed)
+	}
+}
+
+// failingJournal rejects appends while fail is set
+type failingJournal struct {
+	*fsm.MemoryJournal
+	fail bool
+}
+
+func (j *failingJournal) Append(ctx context.Context, r fsm.Record) (uint64, error) {
+	if j.fail {
+		return 0, errors.New("disk full")
+	}
+
+	return j.MemoryJournal.Append(ctx, r)
+}
+
+func TestMachine_JournalFailureAbortsTransition(t *testing.T) {
+	ctx := context.Background()
+	journal := &failingJournal{MemoryJournal: fsm.NewMemoryJournal(), fail: true}
+	id := uuid.New()
+
+	m, _, err := turnstile().Build(id, "turnstile", fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	coin := event("coin")
+
+	res, err := m.Send(ctx, coin)
+	if err == nil || res.Transitioned {
+		t.Fatalf("expected the journal failure to abort the transition, got %+v - %v", res, err)
+	}
+
+	if m.Current() != "locked" || len(m.History()) != 0 {
+		t.Errorf("expected machine to remain locked, but was %s", m.Current())
+	}
+
+	// the event was not applied, so it is processed again rather than skipped once the journal recovers
+	journal.fail = false
+
+	res, err = m.Send(ctx, coin)
+	if err != nil || !res.Transitioned || res.Skipped {
+		t.Fatalf("expected the event to be processed, got %+v - %v", res, err)
+	}
+
+	rebuilt, _, err := turnstile().Rebuild(ctx, id, "turnstile", fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not rebuild state machine - %v", err)
+	}
+
+	if rebuilt.Current() != "unlocked" {
+		t.Errorf("expected rebuilt machine to be unlocked, but was %s", rebuilt.Current())
+	}
+}
+
+func TestFileJournal_OversizedRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	id := uuid.New()
+
+	j, err := fsm.OpenFileJournal(dir, 100)
+	if err != nil {
+		t.Fatalf("could not open journal - %v", err)
+	}
+
+	if _, err := j.Append(ctx, fsm.Record{MachineID: id, EventID: uuid.New(), Name: "large", Data: make([]byte, 100*1024)}); err != nil {
+		t.Fatalf("could not append record - %v", err)
+	}
+
+	_ = j.Close()
+
+	j, err = fsm.OpenFileJournal(dir, 100)
+	if err != nil {
+		t.Fatalf("could not reopen journal with a record larger than a segment - %v", err)
+	}
+	defer j.Close()
+
+	seq, err := j.Append(ctx, fsm.Record{MachineID: id, EventID: uuid.New(), Name: "tick"})
+	if err != nil || seq != 2 {
+		t.Errorf("expected sequence 2, got %d - %v", seq, err)
+	}
+}
+
+func TestFileJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	id := uuid.New()
+	other := uuid.New()
+
+	j, err := fsm.OpenFileJournal(dir, 200)
+	if err != nil {
+		t.Fatalf("could not open journal - %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		machine := id
+		if i%3 == 2 {
+			machine = other
+		}
+
+		if _, err := j.Append(ctx, fsm.Record{MachineID: machine, EventID: uuid.New(), Name: "tick"}); err != nil {
+			t.Fatalf("could not append record - %v", err)
+		}
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatalf("could not close journal - %v", err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(segments) < 2 {
+		t.Errorf("expected the journal to roll over to new segments, got %d segments", len(segments))
+	}
+
+	// simulate a crash part way through writing a record
+	last := segments[len(segments)-1]
+
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("could not open segment - %v", err)
+	}
+
+	_, _ = f.WriteString(`{"seq":7,"machine-id":`)
+	_ = f.Close()
+
+	j, err = fsm.OpenFileJournal(dir, 200)
+	if err != nil {
+		t.Fatalf("could not reopen journal - %v", err)
+	}
+	defer j.Close()
+
+	seq, err := j.Append(ctx, fsm.Record{MachineID: id, EventID: uuid.New(), Name: "tick"})
+	if err != nil {
+		t.Fatalf("could not append record - %v", err)
+	}
+
+	if seq != 7 {
+		t.Errorf("expected the partial record to be discarded and sequence 7 to be reused, got %d", seq)
+	}
+
+	var got []uint64
+
+	err = j.Replay(ctx, id, 2, func(r fsm.Record) error {
+		got = append(got, r.Sequence)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("could not replay journal - %v", err)
+	}
+
+	want := []uint64{4, 5, 7}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected sequences %v, got %v", want, got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected sequences %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestRebuild_DoesNotAuditReplayedTransitions(t *testing.T) {
+	ctx := context.Background()
+	journal := fsm.NewMemoryJournal()
+	id := uuid.New()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	audit, err := logger.OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("could not open audit log - %v", err)
+	}
+	defer audit.Close()
+
+	opts := []fsm.Option{fsm.WithJournal(journal, testCodec{}), fsm.WithAuditLog(audit)}
+
+	m, errCh, err := turnstile().Build(id, "turnstile", opts...)
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if errs := runMachine(m, errCh, event("coin"), event("push")); len(errs) != 0 {
+		t.Fatalf("unexpected errors - %v", errs)
+	}
+
+	last, err := logger.VerifyAuditLog(path)
+	if err != nil || last.Sequence != 2 {
+		t.Fatalf("expected 2 audited transitions, got %d - %v", last.Sequence, err)
+	}
+
+	rebuilt, _, err := turnstile().Rebuild(ctx, id, "turnstile", opts...)
+	if err != nil {
+		t.Fatalf("could not rebuild state machine - %v", err)
+	}
+
+	if last, err := logger.VerifyAuditLog(path); err != nil || last.Sequence != 2 {
+		t.Errorf("expected the replayed transitions not to be audited again, got %d entries - %v", last.Sequence, err)
+	}
+
+	if h := rebuilt.History(); len(h) != 0 {
+		t.Errorf("expected the replayed transitions not to be added to the history, got %+v", h)
+	}
+}
+
+// job is a hand-written state that stops the machine when it finishes
+type job struct{}
+
+func (j job) ID() uuid.UUID                               { return uuid.NewSHA1(uuid.NameSpaceOID, []byte("job")) }
+func (j job) Description() string                         { return "job" }
+func (j job) Execute(fsm.Event) error                     { return nil }
+func (j job) Next() fsm.State                             { return fsm.Next(j, j.Transitions()...) }
+func (j job) WithTransitions(...fsm.Transition) fsm.State { return j }
+
+func (j job) Transitions() []fsm.Transition {
+	return []fsm.Transition{{Name: "finish", Next: func(fsm.State) fsm.State { return nil }}}
+}
+
+func TestRebuild_Terminated(t *testing.T) {
+	ctx := context.Background()
+	journal := fsm.NewMemoryJournal()
+	id := uuid.New()
+	finish := event("finish")
+
+	m, _ := fsm.New(id, "job", job{}, fsm.WithJournal(journal, testCodec{}))
+
+	if res, err := m.Send(ctx, finish); err != nil || !res.Stopped {
+		t.Fatalf("expected the machine to stop, got %+v - %v", res, err)
+	}
+
+	factory := func(string, []byte) (fsm.State, error) { return job{}, nil }
+
+	rebuilt, _, err := fsm.Rebuild(ctx, id, "job", job{}, factory, fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not rebuild state machine - %v", err)
+	}
+
+	if _, err := rebuilt.Send(ctx, finish); err == nil {
+		t.Errorf("expected the rebuilt machine to have stopped")
+	}
+}
@@ -3,12 +3,20 @@ package fsm
 import (
 	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/birchwood-langham/bootstrap/pkg/logger"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// ReentrantSendError is returned by Send when it is called from a hook or action of the machine that is processing
+// an event, the machine processes one event at a time so the event could never be processed
+var ReentrantSendError = errors.New("state machine cannot process an event sent by its own hooks or actions")
+
+// Machine is a state machine. Send, State, Accepts, History and Subscribe have been added to the interface alongside
+// the machines created by this package, types outside the package that implement Machine need to implement them too
 type Machine interface {
 	// ID returns the unique id of the state machine
 	ID() uuid.UUID
@@ -18,27 +26,111 @@ type Machine interface {
 	Current() string
 	// Run executes the state machine
 	Run(context.Context, <-chan Event) error
+	// Send processes a single event and returns its outcome. The machine is locked while the event is processed,
+	// including its hooks, actions, journal and snapshot, so hooks and actions must not call the machine's methods
+	Send(context.Context, Event) (Result, error)
+	// State returns the current state of the machine
+	State() State
+	// Accepts returns the names of the events the current state has transitions for, if the state declares them
+	Accepts() []string
+	// History returns the most recent transitions of the machine, oldest first
+	History() []TransitionRecord
+	// Subscribe registers an observer that is notified of every transition, the returned function unsubscribes it
+	Subscribe(Observer) func()
+}
+
+// processingKey marks the context passed to the hooks and actions of the machine processing an event
+type processingKey struct {
+	m *machine
+}
+
+// errorBuffer is the number of errors Run can publish before errors are dropped because nobody is reading them
+const errorBuffer = 64
+
+// Result is the outcome of processing an event
+type Result struct {
+	// EventID is the id of the event that was processed
+	EventID uuid.UUID
+	// Previous is the description of the state the machine was in before the event was processed
+	Previous string
+	// Current is the description of the state the machine is in after the event was processed
+	Current string
+	// Transitioned is true if the machine moved to a different state
+	Transitioned bool
+	// Skipped is true if the event had already been processed and was ignored
+	Skipped bool
+	// Stopped is true if the machine has stopped and will not process any further events
+	Stopped bool
+	// DeadLettered is true if the event could not be processed and was sent to the dead-letter sink
+	DeadLettered bool
 }
 
 type machine struct {
-	id      uuid.UUID
-	name    string
-	current State
-	errCh   chan error
-	hasRun  bool
+	mu        sync.Mutex
+	id        uuid.UUID
+	name      string
+	current   State
+	errCh     chan error
+	errClosed bool
+	hasRun    bool
+	started   bool
+	stopped   bool
+	snapshots SnapshotStore
+	lastEvent uuid.UUID
+	processed []uuid.UUID
+
+	journal       Journal
+	codec         EventCodec
+	sequence      uint64
+	snapshotEvery int
+	sinceSnapshot int
+	replaying     bool
+
+	clock  Clock
+	timers map[string]*runningTimer
+
+	policies    []matchedPolicy
+	deadLetters DeadLetterSink
+	audit       *logger.AuditLogger
+
+	enteredAt   time.Time
+	history     []TransitionRecord
+	historySize int
+	observers   map[int]Observer
+	observerSeq int
+
+	notifications []notification
+	notifying     bool
+
+	// set by a Manager to forward errors and to learn when timers fire
+	onError func(error)
+	onTimer func()
 }
 
 // New creates a state machine with the given initial state
 // and a channel where any errors generated by the state machine will
-// be published
-func New(id uuid.UUID, name string, init State) (m *machine, errCh chan error) {
+// be published. The channel is buffered, if it is full when Run needs to
+// publish an error the error is logged and dropped so the machine is never blocked.
+// The timers of the initial state are started when the machine is run or sent its first event
+func New(id uuid.UUID, name string, init State, opts ...Option) (m *machine, errCh chan error) {
 	m = new(machine)
 	m.id = id
 	m.name = name
 	m.current = init
 	m.hasRun = false
-	errCh = make(chan error)
+	errCh = make(chan error, errorBuffer)
 	m.errCh = errCh
+	m.clock = SystemClock
+	m.timers = make(map[string]*runningTimer)
+	m.historySize = DefaultHistorySize
+	m.observers = make(map[int]Observer)
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.enteredAt = m.clock.Now()
+
 	return
 }
 
@@ -51,6 +143,9 @@ func (m *machine) Name() string {
 }
 
 func (m *machine) Current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.current.Description()
 }
 
@@ -59,7 +154,9 @@ func (m *machine) Current() string {
 // return an error if Run is called again.
 // Run takes an event channel for events to be passed to the state machine, if the events channel
 // is closed, the state machine will exit.
-// Run will close the error channel automatically when processing has been completed
+// Run will close the error channel automatically when processing has been completed, the machine is stopped
+// and its timers cancelled first so timers cannot fire once the channel has been closed.
+// Events can also be sent using Send while the machine is running, the events are processed one at a time
 func (m *machine) Run(ctx context.Context, eventCh <-chan Event) error {
 	if m.hasRun {
 		return errors.New("state machine has already been run and cannot be re-run, you need to create a new state machine")
@@ -69,7 +166,11 @@ func (m *machine) Run(ctx context.Context, eventCh <-chan Event) error {
 
 	l := logger.Logger()
 
-	defer close(m.errCh)
+	m.mu.Lock()
+	m.start()
+	m.mu.Unlock()
+
+	defer m.closeErrors()
 
 	for {
 		select {
@@ -82,34 +183,282 @@ func (m *machine) Run(ctx context.Context, eventCh <-chan Event) error {
 				return nil
 			}
 
-			l.Info("Received event",
-				zap.String("name", event.Name()),
-				zap.String("source", event.Source()),
-				zap.String("id", event.ID().String()),
-				zap.String("timestamp", TimestampToString(event.Timestamp())),
-			)
-
-			err := m.current.Execute(event)
+			res, err := m.Send(ctx, event)
 
 			if err != nil {
-				l.Error("Processing event resulted in error", zap.Error(err))
-				m.errCh <- err
-				continue
+				m.publishError(err)
 			}
 
-			next := m.current.Next()
-			if next == nil {
+			if res.Stopped {
 				return nil
 			}
+		}
+	}
+}
+
+// start schedules the timers of the current state the first time the machine is run or sent an event, so machines
+// that are created but never used do not start any timers. The caller must hold the machine's lock
+func (m *machine) start() {
+	if m.started {
+		return
+	}
+
+	m.started = true
+	m.scheduleTimers()
+}
+
+// closeErrors stops the machine and closes the error channel once Run has finished
+func (m *machine) closeErrors() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	m.stopTimers()
+
+	m.errClosed = true
+	close(m.errCh)
+}
+
+// Close stops the machine and cancels its timers, it will not process any further events. Machines driven by Send
+// rather than Run, such as those created for a Manager, should be closed once they are no longer needed so their
+// timers do not keep firing
+func (m *machine) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	m.stopTimers()
+
+	return nil
+}
+
+// manage hands the machine to a Manager, errors are passed to onError instead of the error channel and onTimer is
+// called whenever a timer fires
+func (m *machine) manage(onError func(error), onTimer func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onError = onError
+	m.onTimer = onTimer
+}
+
+// closeIfIdle closes the machine unless it has timers running, returning true if it was closed
+func (m *machine) closeIfIdle() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.timers) > 0 {
+		return false
+	}
+
+	m.stopped = true
+	m.stopTimers()
+
+	return true
+}
+
+// Send processes the event and returns the outcome once it has been processed, so the caller can respond
+// deterministically. The machine's error policies are applied to any error the event causes, and the error
+// is returned to the caller rather than published on the error channel.
+// The machine is locked until the event has been processed, including running its hooks and actions and writing
+// its journal and snapshot. Calling Send from one of the machine's own hooks or actions returns ReentrantSendError,
+// the other methods of the machine must not be called from them at all as they would wait for the lock forever
+func (m *machine) Send(ctx context.Context, event Event) (Result, error) {
+	if ctx.Value(processingKey{m}) != nil {
+		return Result{EventID: event.ID()}, ReentrantSendError
+	}
+
+	// observers are notified once the machine has been unlocked
+	defer m.notify()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return Result{EventID: event.ID(), Previous: m.current.Description(), Current: m.current.Description(), Stopped: true},
+			errors.New("state machine has stopped and cannot process any more events")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Result{EventID: event.ID(), Previous: m.current.Description(), Current: m.current.Description()}, err
+	}
+
+	m.start()
+
+	return m.handle(ctx, event)
+}
+
+// process executes the event in the current state and performs any transition that results from it.
+// The caller must hold the machine's lock
+func (m *machine) process(ctx context.Context, event Event) (res Result, err error) {
+	l := logger.Logger()
+
+	res = Result{EventID: event.ID(), Previous: m.current.Description()}
 
-			if next.ID() != m.current.ID() {
-				l.Info("State machine transitioning to new state",
-					zap.String("current", m.current.Description()),
-					zap.String("next", next.Description()),
-				)
+	defer func() {
+		res.Current = m.current.Description()
+		res.Stopped = m.stopped
+	}()
+
+	l.Info("Received event",
+		zap.String("name", event.Name()),
+		zap.String("source", event.Source()),
+		zap.String("id", event.ID().String()),
+		zap.String("timestamp", TimestampToString(event.Timestamp())),
+	)
+
+	if m.hasProcessed(event.ID()) {
+		l.Info("Skipping event that has already been processed", zap.String("id", event.ID().String()))
+		res.Skipped = true
+		return res, nil
+	}
+
+	if err := m.current.Execute(event); err != nil {
+		l.Error("Processing event resulted in error", zap.Error(err))
+		return res, err
+	}
+
+	next, action := m.next()
+
+	if next == nil {
+		// the terminating event is journaled so a rebuilt machine stops as well
+		if err := m.journalEvent(ctx, event); err != nil {
+			l.Error("Could not journal terminating event, the machine has not stopped", zap.Error(err))
+			return res, err
+		}
+
+		m.markProcessed(event.ID())
+
+		m.stopped = true
+		m.stopTimers()
+		return res, nil
+	}
+
+	res.Transitioned = next.ID() != m.current.ID()
+
+	if err := m.transition(ctx, event, next, action); err != nil {
+		l.Error("Transition resulted in error, transition aborted",
+			zap.String("current", m.current.Description()),
+			zap.String("next", next.Description()),
+			zap.Error(err),
+		)
+
+		res.Transitioned = false
+		return res, err
+	}
+
+	if err := m.saveSnapshot(ctx); err != nil {
+		l.Error("Could not save state machine snapshot", zap.Error(err))
+		return res, err
+	}
+
+	return res, nil
+}
+
+// transition moves the machine to the next state. The current state's exit hook, the transition action and
+// the next state's entry hook are executed in that order, if any of them fail the transition is aborted and the
+// machine remains in the current state. Hooks that have already run are not undone, so if the action or the entry
+// hook fails the exit hook has run although the machine has not left the state, and it runs again the next time
+// the machine tries to leave. If the next state is the current state, only the action is executed.
+// The event is journaled before the machine moves, so a journal failure aborts the transition in the same way and
+// the journal never misses an event the machine has applied
+func (m *machine) transition(ctx context.Context, event Event, next State, action ActionFn) error {
+	if next.ID() == m.current.ID() {
+		if action != nil {
+			if err := action(ctx, event); err != nil {
+				return err
 			}
+		}
+
+		if err := m.journalEvent(ctx, event); err != nil {
+			return err
+		}
+
+		m.markProcessed(event.ID())
+
+		return nil
+	}
+
+	l := logger.Logger()
 
-			m.current = next
+	l.Info("State machine transitioning to new state",
+		zap.String("current", m.current.Description()),
+		zap.String("next", next.Description()),
+	)
+
+	if exiter, ok := m.current.(Exiter); ok {
+		if err := exiter.OnExit(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	if action != nil {
+		if err := action(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	if enterer, ok := next.(Enterer); ok {
+		if err := enterer.OnEnter(ctx, event); err != nil {
+			return err
 		}
 	}
+
+	if err := m.journalEvent(ctx, event); err != nil {
+		return err
+	}
+
+	m.markProcessed(event.ID())
+
+	previous := m.current
+
+	m.current = next
+	m.scheduleTimers()
+
+	// replayed transitions have already been audited and took place before the machine was rebuilt
+	if m.replaying {
+		return nil
+	}
+
+	if err := m.auditLog().Record("fsm.transition", map[string]interface{}{
+		"machine-id":   m.id.String(),
+		"machine-name": m.name,
+		"from":         previous.Description(),
+		"to":           next.Description(),
+		"event-id":     event.ID().String(),
+		"event-name":   event.Name(),
+	}); err != nil {
+		l.Error("could not record state machine transition in audit log", zap.Error(err))
+	}
+
+	m.recordTransition(previous, next, event)
+
+	return nil
+}
+
+// auditLog returns the audit log transitions are recorded in, the application's audit log is used unless the
+// machine was given one with WithAuditLog
+func (m *machine) auditLog() *logger.AuditLogger {
+	if m.audit != nil {
+		return m.audit
+	}
+
+	return logger.Audit()
+}
+
+// next determines the state the machine should transition to after processing an event.
+// If the current state exposes its transitions, the machine selects the transition itself and
+// returns its action along with the next state, otherwise the state's Next function is used
+func (m *machine) next() (State, ActionFn) {
+	t, ok := m.current.(Transitioner)
+	if !ok {
+		return m.current.Next(), nil
+	}
+
+	tr, ok := Select(m.current, t.Transitions()...)
+	if !ok {
+		return m.current, nil
+	}
+
+	return tr.Next(m.current), tr.Action
 }
@@ -0,0 +1,238 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+// runMachine sends the events to the machine and returns the errors published by the machine
+func runMachine(m fsm.Machine, errCh <-chan error, events ...fsm.Event) []error {
+	eventCh := make(chan fsm.Event)
+	errs := make([]error, 0)
+	collected := make(chan struct{})
+
+	go func() {
+		defer close(collected)
+		for err := range errCh {
+			errs = append(errs, err)
+		}
+	}()
+
+	go func() {
+		for _, e := range events {
+			eventCh <- e
+		}
+		close(eventCh)
+	}()
+
+	_ = m.Run(context.Background(), eventCh)
+	<-collected
+
+	return errs
+}
+
+func TestMachine_Hooks(t *testing.T) {
+	calls := make([]string, 0)
+	hook := func(name string) fsm.ActionFn {
+		return func(_ context.Context, e fsm.Event) error {
+			calls = append(calls, name+":"+e.Name())
+			return nil
+		}
+	}
+
+	m, errCh, err := turnstile().
+		OnExit("locked", hook("exit locked")).
+		OnEnter("unlocked", hook("enter unlocked")).
+		OnExit("unlocked", hook("exit unlocked")).
+		OnEnter("locked", hook("enter locked")).
+		From("locked").On("pay").To("unlocked").Action(hook("pay")).
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if errs := runMachine(m, errCh, event("pay"), event("push")); len(errs) != 0 {
+		t.Fatalf("unexpected errors - %v", errs)
+	}
+
+	want := []string{"exit locked:pay", "pay:pay", "enter unlocked:pay", "exit unlocked:push", "enter locked:push"}
+
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want hooks %v, got %v", want, calls)
+	}
+}
+
+func TestMachine_HookErrorAbortsTransition(t *testing.T) {
+	refused := errors.New("payment refused")
+
+	m, errCh, err := turnstile().
+		OnEnter("unlocked", func(context.Context, fsm.Event) error {
+			return refused
+		}).
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	errs := runMachine(m, errCh, event("coin"))
+
+	if len(errs) != 1 || !errors.Is(errs[0], refused) {
+		t.Errorf("expected the hook error to be published, got %v", errs)
+	}
+
+	if m.Current() != "locked" {
+		t.Errorf("expected machine to remain locked, but was %s", m.Current())
+	}
+}
+
+func TestMachine_EntryErrorAfterExit(t *testing.T) {
+	exits := 0
+	refuse := true
+
+	m, _, err := turnstile().
+		OnExit("locked", func(context.Context, fsm.Event) error {
+			exits++
+			return nil
+		}).
+		OnEnter("unlocked", func(context.Context, fsm.Event) error {
+			if refuse {
+				return errors.New("gate jammed")
+			}
+
+			return nil
+		}).
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	ctx := context.Background()
+
+	if _, err := m.Send(ctx, event("coin")); err == nil {
+		t.Fatalf("expected the entry hook error to be returned")
+	}
+
+	// the exit hook has run, but the machine has not left the state
+	if m.Current() != "locked" || exits != 1 || len(m.History()) != 0 {
+		t.Errorf("expected machine to remain locked after 1 exit, got %s after %d exits", m.Current(), exits)
+	}
+
+	refuse = false
+
+	if _, err := m.Send(ctx, event("coin")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	if m.Current() != "unlocked" || exits != 2 {
+		t.Errorf("expected the exit hook to run again when leaving, got %s after %d exits", m.Current(), exits)
+	}
+}
+
+func TestMachine_Send(t *testing.T) {
+	m, _, err := turnstile().Build(uuid.New(), "turnstile")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	ctx := context.Background()
+	coin := event("coin")
+
+	res, err := m.Send(ctx, coin)
+	if err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	want := fsm.Result{EventID: coin.ID(), Previous: "locked", Current: "unlocked", Transitioned: true}
+	if res != want {
+		t.Errorf("expected %+v, got %+v", want, res)
+	}
+
+	res, err = m.Send(ctx, coin)
+	if err != nil || !res.Skipped || res.Transitioned {
+		t.Errorf("expected a repeated event to be skipped, got %+v - %v", res, err)
+	}
+
+	res, err = m.Send(ctx, event("coin"))
+	if err == nil || res.Transitioned || res.Current != "unlocked" {
+		t.Errorf("expected an unexpected event to be rejected, got %+v - %v", res, err)
+	}
+}
+
+func TestMachine_SendFromHook(t *testing.T) {
+	var m fsm.Machine
+	var hookErr error
+
+	m, _, err := turnstile().
+		OnEnter("unlocked", func(ctx context.Context, _ fsm.Event) error {
+			_, hookErr = m.Send(ctx, event("push"))
+			return nil
+		}).
+		Build(uuid.New(), "turnstile")
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if _, err := m.Send(context.Background(), event("coin")); err != nil {
+			t.Errorf("unexpected error - %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a send from a hook not to deadlock the machine")
+	}
+
+	if !errors.Is(hookErr, fsm.ReentrantSendError) {
+		t.Errorf("expected the send from the hook to be rejected, got %v", hookErr)
+	}
+
+	if m.Current() != "unlocked" {
+		t.Errorf("expected machine to be unlocked, but was %s", m.Current())
+	}
+}
+
+func TestMachine_SendWhileRunning(t *testing.T) {
+	m, errCh, err := turnstile().Build(uuid.New(), "turnstile")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	eventCh := make(chan fsm.Event)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_ = m.Run(context.Background(), eventCh)
+	}()
+
+	for i := 0; i < 10; i++ {
+		eventCh <- event("coin")
+
+		// the push is rejected if the coin sent to Run has not been processed yet
+		_, _ = m.Send(context.Background(), event("push"))
+	}
+
+	close(eventCh)
+	<-done
+
+	// nobody read the error channel, but the machine must not have been blocked
+	for range errCh {
+	}
+}
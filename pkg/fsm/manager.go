@@ -0,0 +1,349 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+// DefaultIdleTimeout is the time a managed machine can go without receiving events before it is evicted
+const DefaultIdleTimeout = 10 * time.Minute
+
+// KeyFn determines the key of the machine that should process an event, e.g. the id of an order or device
+type KeyFn func(Event) string
+
+// MachineFactory creates the machine for a key when the manager receives the first event for it. The factory
+// should restore the machine if it has been saved before, e.g. using Restore or Rebuild, so a machine that has
+// been evicted continues from where it left off
+type MachineFactory func(ctx context.Context, key string) (Machine, error)
+
+// ManagerOption configures a Manager
+type ManagerOption func(*Manager)
+
+// WithWorkers sets the number of workers processing events, which bounds the number of machines processing
+// events at the same time. The default is the number of CPUs
+func WithWorkers(n int) ManagerOption {
+	return func(mgr *Manager) {
+		if n > 0 {
+			mgr.workers = n
+		}
+	}
+}
+
+// WithIdleTimeout sets the time a machine can go without receiving events before it is evicted from memory,
+// a timeout of zero disables eviction. Machines created by New, Restore or Rebuild are not evicted while their
+// timers are running, and a timer firing counts as using the machine
+func WithIdleTimeout(d time.Duration) ManagerOption {
+	return func(mgr *Manager) {
+		mgr.idleTimeout = d
+	}
+}
+
+type instance struct {
+	mu       sync.Mutex
+	machine  Machine
+	lastUsed time.Time
+}
+
+func (inst *instance) touch() {
+	inst.mu.Lock()
+	inst.lastUsed = time.Now()
+	inst.mu.Unlock()
+}
+
+// managedMachine is implemented by the machines created by New, Restore and Rebuild, so the manager can receive their
+// errors and timer firings and avoid evicting them while their timers are running
+type managedMachine interface {
+	manage(onError func(error), onTimer func())
+	closeIfIdle() bool
+}
+
+type job struct {
+	key   string
+	event Event
+	evict bool
+}
+
+// Manager routes events to many machines by key. Machines are created by the factory when the first event for their
+// key is received and are evicted when they have been idle for the idle timeout. Events are processed by a pool of
+// workers, every event for a key is handled by the same worker so each machine processes its events in order
+type Manager struct {
+	key         KeyFn
+	factory     MachineFactory
+	workers     int
+	idleTimeout time.Duration
+	errCh       chan error
+	jobs        []chan job
+	hasRun      bool
+
+	mu       sync.RWMutex
+	machines map[string]*instance
+}
+
+// NewManager creates a manager that uses the key function to route events to machines created by the factory,
+// along with the channel where any errors generated by the machines will be published. Like the channel returned
+// by New, it is buffered and errors are logged and dropped when it is full, so the workers are never blocked
+func NewManager(key KeyFn, factory MachineFactory, opts ...ManagerOption) (*Manager, chan error) {
+	mgr := &Manager{
+		key:         key,
+		factory:     factory,
+		workers:     runtime.NumCPU(),
+		idleTimeout: DefaultIdleTimeout,
+		errCh:       make(chan error, errorBuffer),
+		machines:    make(map[string]*instance),
+	}
+
+	for _, opt := range opts {
+		opt(mgr)
+	}
+
+	return mgr, mgr.errCh
+}
+
+// Run starts the workers and routes the events from the channel to the machines until the channel is closed or
+// the context ends. Like Machine.Run, a manager can only be run once and closes the error channel when it exits,
+// the machines still in memory are closed before it returns
+func (mgr *Manager) Run(ctx context.Context, eventCh <-chan Event) error {
+	if mgr.hasRun {
+		return errors.New("manager has already been run and cannot be re-run, you need to create a new manager")
+	}
+
+	mgr.hasRun = true
+
+	l := logger.Logger()
+
+	var wg sync.WaitGroup
+
+	mgr.jobs = make([]chan job, mgr.workers)
+
+	for i := range mgr.jobs {
+		mgr.jobs[i] = make(chan job)
+		wg.Add(1)
+
+		go func(jobs <-chan job) {
+			defer wg.Done()
+			mgr.work(ctx, jobs)
+		}(mgr.jobs[i])
+	}
+
+	defer func() {
+		for _, jobs := range mgr.jobs {
+			close(jobs)
+		}
+
+		wg.Wait()
+		mgr.closeAll()
+		close(mgr.errCh)
+	}()
+
+	var evictions <-chan time.Time
+
+	if mgr.idleTimeout > 0 {
+		ticker := time.NewTicker(mgr.idleTimeout / 2)
+		defer ticker.Stop()
+		evictions = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.Warn("Context has ended, stopping state machine manager")
+			return nil
+		case <-evictions:
+			mgr.evictIdle(ctx)
+		case event, ok := <-eventCh:
+			if !ok {
+				l.Warn("Incoming event channel has been closed, terminating state machine manager")
+				return nil
+			}
+
+			mgr.dispatch(ctx, job{key: mgr.key(event), event: event})
+		}
+	}
+}
+
+// Current returns a description of the current state of the machine with the given key, if it is in memory
+func (mgr *Manager) Current(key string) (string, bool) {
+	inst := mgr.instance(key)
+	if inst == nil {
+		return "", false
+	}
+
+	return inst.machine.Current(), true
+}
+
+// Len returns the number of machines in memory
+func (mgr *Manager) Len() int {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	return len(mgr.machines)
+}
+
+// dispatch sends the job to the worker that owns the key
+func (mgr *Manager) dispatch(ctx context.Context, j job) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(j.key))
+
+	select {
+	case mgr.jobs[h.Sum32()%uint32(len(mgr.jobs))] <- j:
+	case <-ctx.Done():
+	}
+}
+
+// evictIdle asks the workers to evict the machines that have been idle for longer than the idle timeout,
+// eviction is carried out by the worker that owns the machine so it never races with an event being processed
+func (mgr *Manager) evictIdle(ctx context.Context) {
+	cutoff := time.Now().Add(-mgr.idleTimeout)
+	keys := make([]string, 0)
+
+	mgr.mu.RLock()
+	for key, inst := range mgr.machines {
+		inst.mu.Lock()
+		if inst.lastUsed.Before(cutoff) {
+			keys = append(keys, key)
+		}
+		inst.mu.Unlock()
+	}
+	mgr.mu.RUnlock()
+
+	for _, key := range keys {
+		mgr.dispatch(ctx, job{key: key, evict: true})
+	}
+}
+
+func (mgr *Manager) work(ctx context.Context, jobs <-chan job) {
+	for j := range jobs {
+		if j.evict {
+			mgr.evict(j.key)
+			continue
+		}
+
+		if err := mgr.process(ctx, j.key, j.event); err != nil {
+			mgr.publishError(fmt.Errorf("machine %s: %w", j.key, err))
+		}
+	}
+}
+
+// publishError sends the error to the error channel without blocking, if the channel is full the error is logged
+func (mgr *Manager) publishError(err error) {
+	select {
+	case mgr.errCh <- err:
+	default:
+		logger.Logger().Error("Error channel is full, dropping state machine manager error", zap.Error(err))
+	}
+}
+
+func (mgr *Manager) process(ctx context.Context, key string, event Event) error {
+	inst := mgr.instance(key)
+
+	if inst == nil {
+		m, err := mgr.factory(ctx, key)
+		if err != nil {
+			return fmt.Errorf("could not create state machine: %w", err)
+		}
+
+		inst = &instance{machine: m}
+
+		if mm, ok := m.(managedMachine); ok {
+			mm.manage(func(err error) {
+				mgr.publishError(fmt.Errorf("machine %s: %w", key, err))
+			}, inst.touch)
+		}
+
+		mgr.mu.Lock()
+		mgr.machines[key] = inst
+		mgr.mu.Unlock()
+	}
+
+	res, err := inst.machine.Send(ctx, event)
+
+	inst.touch()
+
+	if res.Stopped {
+		logger.Logger().Info("Managed state machine has finished", zap.String("key", key))
+		mgr.remove(key)
+		mgr.release(key, inst)
+	}
+
+	return err
+}
+
+// evict removes the machine if it is still idle, it may have received an event since eviction was requested.
+// Machines with timers running are kept, their timeout events would be lost if they were evicted
+func (mgr *Manager) evict(key string) {
+	inst := mgr.instance(key)
+	if inst == nil {
+		return
+	}
+
+	inst.mu.Lock()
+	idle := time.Since(inst.lastUsed) >= mgr.idleTimeout
+	inst.mu.Unlock()
+
+	if !idle {
+		return
+	}
+
+	if mm, ok := inst.machine.(managedMachine); ok {
+		if !mm.closeIfIdle() {
+			return
+		}
+
+		logger.Logger().Info("Evicting idle state machine", zap.String("key", key))
+		mgr.remove(key)
+
+		return
+	}
+
+	logger.Logger().Info("Evicting idle state machine", zap.String("key", key))
+	mgr.remove(key)
+	mgr.release(key, inst)
+}
+
+// release closes a machine that has been removed from memory, so it stops its timers. Machines that do not
+// implement io.Closer have nothing to release
+func (mgr *Manager) release(key string, inst *instance) {
+	c, ok := inst.machine.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := c.Close(); err != nil {
+		mgr.publishError(fmt.Errorf("machine %s: could not close state machine: %w", key, err))
+	}
+}
+
+// closeAll releases every machine in memory once the workers have stopped, the machines are kept so their
+// state can still be read
+func (mgr *Manager) closeAll() {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	for key, inst := range mgr.machines {
+		mgr.release(key, inst)
+	}
+}
+
+func (mgr *Manager) instance(key string) *instance {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	return mgr.machines[key]
+}
+
+func (mgr *Manager) remove(key string) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	delete(mgr.machines, key)
+}
@@ -0,0 +1,279 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+type keyedEvent struct {
+	testEvent
+	key string
+}
+
+func (e keyedEvent) Source() string {
+	return e.key
+}
+
+func keyed(key, name string) fsm.Event {
+	return keyedEvent{testEvent: event(name), key: key}
+}
+
+func bySource(e fsm.Event) string {
+	return e.Source()
+}
+
+// turnstiles creates a factory that restores turnstiles from the snapshot store, keeping count of the machines created
+func turnstiles(store fsm.SnapshotStore, created *int) fsm.MachineFactory {
+	var mu sync.Mutex
+
+	return func(ctx context.Context, key string) (fsm.Machine, error) {
+		mu.Lock()
+		*created++
+		mu.Unlock()
+
+		id := uuid.NewSHA1(uuid.NameSpaceOID, []byte(key))
+
+		snap, err := store.Load(ctx, id)
+		if errors.Is(err, fsm.SnapshotNotFoundError) {
+			m, _, err := turnstile().Build(id, key, fsm.WithSnapshotStore(store))
+			return m, err
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		m, _, err := turnstile().Restore(snap, fsm.WithSnapshotStore(store))
+		return m, err
+	}
+}
+
+func runManager(mgr *fsm.Manager, errCh chan error, events ...fsm.Event) []error {
+	eventCh := make(chan fsm.Event)
+	errs := make([]error, 0)
+	done := make(chan struct{})
+
+	go func() {
+		for err := range errCh {
+			errs = append(errs, err)
+		}
+
+		close(done)
+	}()
+
+	go func() {
+		for _, e := range events {
+			eventCh <- e
+		}
+
+		close(eventCh)
+	}()
+
+	_ = mgr.Run(context.Background(), eventCh)
+	<-done
+
+	return errs
+}
+
+func TestManager(t *testing.T) {
+	created := 0
+	store := fsm.NewMemorySnapshotStore()
+
+	mgr, errCh := fsm.NewManager(bySource, turnstiles(store, &created), fsm.WithWorkers(4))
+
+	errs := runManager(mgr, errCh,
+		keyed("gate-1", "coin"),
+		keyed("gate-2", "coin"),
+		keyed("gate-1", "push"),
+		keyed("gate-3", "push"),
+	)
+
+	if len(errs) != 1 {
+		t.Fatalf("expected an error for the unexpected push at gate-3, got %v", errs)
+	}
+
+	expected := map[string]string{
+		"gate-1": "locked",
+		"gate-2": "unlocked",
+		"gate-3": "locked",
+	}
+
+	for key, want := range expected {
+		got, ok := mgr.Current(key)
+		if !ok || got != want {
+			t.Errorf("expected %s to be %s, got %s", key, want, got)
+		}
+	}
+
+	if _, ok := mgr.Current("gate-4"); ok {
+		t.Errorf("expected gate-4 not to have a machine")
+	}
+
+	if created != 3 {
+		t.Errorf("expected 3 machines to be created, got %d", created)
+	}
+}
+
+func TestManager_EvictIdle(t *testing.T) {
+	created := 0
+	store := fsm.NewMemorySnapshotStore()
+
+	mgr, errCh := fsm.NewManager(bySource, turnstiles(store, &created), fsm.WithWorkers(2), fsm.WithIdleTimeout(20*time.Millisecond))
+
+	eventCh := make(chan fsm.Event)
+	done := make(chan struct{})
+
+	go func() {
+		for err := range errCh {
+			t.Errorf("unexpected error - %v", err)
+		}
+
+		close(done)
+	}()
+
+	go func() {
+		_ = mgr.Run(context.Background(), eventCh)
+	}()
+
+	eventCh <- keyed("gate-1", "coin")
+
+	deadline := time.Now().Add(time.Second)
+	for mgr.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	for mgr.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if mgr.Len() != 0 {
+		t.Fatalf("expected the idle machine to be evicted")
+	}
+
+	// the evicted machine is restored from its snapshot when it receives another event
+	eventCh <- keyed("gate-1", "push")
+	close(eventCh)
+	<-done
+
+	if created != 2 {
+		t.Errorf("expected the machine to be created twice, got %d", created)
+	}
+}
+
+func TestManager_TimersPreventEviction(t *testing.T) {
+	clock := fsm.NewManualClock(time.Now())
+	jammed := errors.New("turnstile jammed")
+
+	factory := func(_ context.Context, key string) (fsm.Machine, error) {
+		m, _, err := turnstile().
+			From("unlocked").After(time.Minute).To("locked").
+			From("locked").After(time.Minute).To("unlocked").
+			OnEnter("unlocked", func(ctx context.Context, e fsm.Event) error {
+				if _, ok := e.(fsm.TimerEvent); ok {
+					return jammed
+				}
+
+				return nil
+			}).
+			Build(uuid.New(), key, fsm.WithClock(clock))
+
+		return m, err
+	}
+
+	mgr, errCh := fsm.NewManager(bySource, factory, fsm.WithWorkers(1), fsm.WithIdleTimeout(20*time.Millisecond))
+
+	eventCh := make(chan fsm.Event)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_ = mgr.Run(context.Background(), eventCh)
+	}()
+
+	eventCh <- keyed("gate-1", "coin")
+
+	deadline := time.Now().Add(time.Second)
+	for clock.Pending() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the machine has been idle for longer than the idle timeout, but its timeout has not fired yet
+	time.Sleep(100 * time.Millisecond)
+
+	if mgr.Len() != 1 || clock.Pending() != 1 {
+		t.Fatalf("expected the machine with a running timer to be kept, %d machines and %d timers", mgr.Len(), clock.Pending())
+	}
+
+	clock.Advance(time.Minute)
+
+	if current, _ := mgr.Current("gate-1"); current != "locked" {
+		t.Errorf("expected the timeout to lock the turnstile, but was %s", current)
+	}
+
+	// the timeout back to unlocked fails, its error is published by the manager
+	clock.Advance(time.Minute)
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, jammed) || !strings.Contains(err.Error(), "gate-1") {
+			t.Errorf("expected the timer error for gate-1, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the manager to publish the timer error")
+	}
+
+	close(eventCh)
+	<-done
+
+	for range errCh {
+	}
+}
+
+func TestManager_ErrorsDoNotBlock(t *testing.T) {
+	created := 0
+	store := fsm.NewMemorySnapshotStore()
+
+	mgr, errCh := fsm.NewManager(bySource, turnstiles(store, &created), fsm.WithWorkers(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	eventCh := make(chan fsm.Event)
+	sent := make(chan struct{})
+
+	go func() {
+		_ = mgr.Run(ctx, eventCh)
+	}()
+
+	// every push is rejected, and nobody reads the error channel
+	go func() {
+		defer close(sent)
+
+		for i := 0; i < 200; i++ {
+			select {
+			case eventCh <- keyed("gate-1", "push"):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the manager not to be blocked by unread errors")
+	}
+
+	close(eventCh)
+
+	for range errCh {
+	}
+}
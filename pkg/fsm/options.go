@@ -0,0 +1,71 @@
+package fsm
+
+import (
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+// Option configures optional behaviour of a state machine when it is created
+type Option func(*machine)
+
+// WithSnapshotStore saves a snapshot of the machine to the store after every event it processes,
+// so the machine can be restored using Restore after a restart
+func WithSnapshotStore(store SnapshotStore) Option {
+	return func(m *machine) {
+		m.snapshots = store
+	}
+}
+
+// WithJournal appends every event accepted by the machine to the journal, using the codec to encode the events.
+// A machine with a journal can be recreated by replaying its events using Rebuild
+func WithJournal(journal Journal, codec EventCodec) Option {
+	return func(m *machine) {
+		m.journal = journal
+		m.codec = codec
+	}
+}
+
+// WithSnapshotEvery limits how often a machine with a journal saves snapshots, a snapshot is saved once
+// the given number of events have been journaled since the last snapshot. The events journaled after the
+// snapshot are replayed when the machine is rebuilt
+func WithSnapshotEvery(events int) Option {
+	return func(m *machine) {
+		m.snapshotEvery = events
+	}
+}
+
+// WithClock sets the clock used to schedule the timers of states with timeouts, the default is the SystemClock
+func WithClock(clock Clock) Option {
+	return func(m *machine) {
+		m.clock = clock
+	}
+}
+
+// WithErrorPolicy applies the policy to events that cause errors matching the matcher. Policies are checked in the
+// order they are added and the first matching policy is applied, events whose errors do not match any policy are dropped
+func WithErrorPolicy(match ErrorMatcher, policy ErrorPolicy) Option {
+	return func(m *machine) {
+		m.policies = append(m.policies, matchedPolicy{match: match, policy: policy})
+	}
+}
+
+// WithDeadLetterSink sets the sink that receives the events sent to the dead letter by an error policy
+func WithDeadLetterSink(sink DeadLetterSink) Option {
+	return func(m *machine) {
+		m.deadLetters = sink
+	}
+}
+
+// WithHistory sets the number of transitions kept in the machine's history, the default is DefaultHistorySize.
+// A size of zero disables the history
+func WithHistory(size int) Option {
+	return func(m *machine) {
+		m.historySize = size
+	}
+}
+
+// WithAuditLog records the machine's transitions in the given audit log instead of the application's audit log
+func WithAuditLog(audit *logger.AuditLogger) Option {
+	return func(m *machine) {
+		m.audit = audit
+	}
+}
@@ -0,0 +1,229 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+// PolicyAction is what the machine does with an event that could not be processed
+type PolicyAction int
+
+const (
+	// Drop reports the error and drops the event, this is what happens to events that do not match any policy
+	Drop PolicyAction = iota
+	// Retry processes the event again after a backoff, until it succeeds or the attempts are exhausted. The machine
+	// is unlocked during the backoff, so other events sent to it in the meantime are processed before the retry
+	Retry
+	// SendToDeadLetter routes the event and the error to the machine's dead-letter sink
+	SendToDeadLetter
+	// Halt stops the machine, it will not process any further events
+	Halt
+	// GoTo moves the machine to an error state
+	GoTo
+)
+
+// ErrorPolicy describes how the machine handles the errors an event matching the policy causes
+type ErrorPolicy struct {
+	// Action is what the machine does with the event
+	Action PolicyAction
+	// Attempts is the maximum number of times the event is processed when retrying, including the first attempt
+	Attempts int
+	// Backoff is the delay before the first retry, it is doubled for every following retry
+	Backoff time.Duration
+	// MaxBackoff limits the delay between retries, there is no limit if it is zero
+	MaxBackoff time.Duration
+	// Exhausted is what the machine does with the event once its retries are exhausted, the default is to drop it
+	Exhausted PolicyAction
+	// State is the state the machine moves to for the GoTo action
+	State State
+}
+
+// RetryPolicy retries events up to the given number of attempts with an exponential backoff, then
+// sends them to the dead-letter sink if the machine has one or drops them if it does not
+func RetryPolicy(attempts int, backoff time.Duration) ErrorPolicy {
+	return ErrorPolicy{Action: Retry, Attempts: attempts, Backoff: backoff, Exhausted: SendToDeadLetter}
+}
+
+// DeadLetterPolicy sends events to the machine's dead-letter sink
+func DeadLetterPolicy() ErrorPolicy {
+	return ErrorPolicy{Action: SendToDeadLetter}
+}
+
+// HaltPolicy stops the machine
+func HaltPolicy() ErrorPolicy {
+	return ErrorPolicy{Action: Halt}
+}
+
+// GoToPolicy moves the machine to the given error state, running the exit hook of the current state and
+// the entry hook of the error state
+func GoToPolicy(state State) ErrorPolicy {
+	return ErrorPolicy{Action: GoTo, State: state}
+}
+
+// ErrorMatcher selects the errors an ErrorPolicy applies to
+type ErrorMatcher func(error) bool
+
+// ErrorIs matches errors that wrap the target error
+func ErrorIs(target error) ErrorMatcher {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// AnyError matches every error
+func AnyError(error) bool {
+	return true
+}
+
+type matchedPolicy struct {
+	match  ErrorMatcher
+	policy ErrorPolicy
+}
+
+// handle processes the event and applies the first error policy that matches any error it causes.
+// The caller must hold the machine's lock
+func (m *machine) handle(ctx context.Context, event Event) (Result, error) {
+	ctx = context.WithValue(ctx, processingKey{m}, true)
+
+	res, err := m.process(ctx, event)
+
+	// errors persisting an event that has been processed cannot be fixed by processing it again
+	if err == nil || m.hasProcessed(event.ID()) {
+		return res, err
+	}
+
+	policy, ok := m.policy(err)
+	if !ok {
+		return res, err
+	}
+
+	l := logger.Logger()
+	attempts := 1
+
+	if policy.Action == Retry {
+		backoff := policy.Backoff
+
+		for attempts < policy.Attempts && err != nil {
+			// events replayed from the journal have been processed before, they are retried straight away
+			if !m.replaying {
+				if werr := m.backoff(ctx, backoff); werr != nil {
+					return res, err
+				}
+			}
+
+			attempts++
+
+			l.Info("Retrying event", zap.String("id", event.ID().String()), zap.Int("attempt", attempts))
+
+			res, err = m.process(ctx, event)
+
+			backoff *= 2
+			if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+
+		if err == nil || m.hasProcessed(event.ID()) {
+			return res, err
+		}
+
+		policy = ErrorPolicy{Action: policy.Exhausted, State: policy.State}
+	}
+
+	switch policy.Action {
+	case SendToDeadLetter:
+		if m.deadLetters == nil || m.replaying {
+			return res, err
+		}
+
+		dl := DeadLetter{
+			MachineID: m.id,
+			Event:     event,
+			State:     m.current.Description(),
+			Error:     err.Error(),
+			Attempts:  attempts,
+			Timestamp: m.clock.Now().UnixNano(),
+		}
+
+		if derr := m.deadLetters.Put(ctx, dl); derr != nil {
+			l.Error("Could not send event to dead-letter sink", zap.Error(derr))
+			return res, fmt.Errorf("%v, and could not be sent to the dead-letter sink: %w", err, derr)
+		}
+
+		res.DeadLettered = true
+	case Halt:
+		l.Error("Halting state machine after error", zap.Error(err))
+
+		m.stopped = true
+		m.stopTimers()
+
+		res.Stopped = true
+		err = fmt.Errorf("state machine halted: %w", err)
+	case GoTo:
+		if policy.State == nil {
+			return res, err
+		}
+
+		// the event is journaled by the transition so rebuilding the machine takes it to the error state again
+		if terr := m.transition(ctx, event, policy.State, nil); terr != nil {
+			return res, fmt.Errorf("%v, and could not move to the error state: %w", err, terr)
+		}
+
+		if serr := m.saveSnapshot(ctx); serr != nil {
+			return res, serr
+		}
+
+		res.Current = m.current.Description()
+		res.Transitioned = true
+	}
+
+	return res, err
+}
+
+func (m *machine) policy(err error) (ErrorPolicy, bool) {
+	for _, p := range m.policies {
+		if p.match(err) {
+			return p.policy, true
+		}
+	}
+
+	return ErrorPolicy{}, false
+}
+
+// backoff releases the machine's lock while it waits before a retry, so the machine can be read and other events can
+// be processed in the meantime. It fails if the machine has stopped while it was waiting
+func (m *machine) backoff(ctx context.Context, d time.Duration) error {
+	m.mu.Unlock()
+	err := m.wait(ctx, d)
+	m.mu.Lock()
+
+	if err == nil && m.stopped {
+		return errors.New("state machine stopped while waiting to retry the event")
+	}
+
+	return err
+}
+
+// wait blocks for the given duration using the machine's clock, or until the context ends
+func (m *machine) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	t := m.clock.AfterFunc(d, func() { close(done) })
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,268 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+var jammedError = errors.New("coin mechanism is jammed")
+
+// jammable creates a turnstile whose coin mechanism fails while jammed is greater than zero,
+// each failure reduces jammed by one
+func jammable(jammed *int) *fsm.Definition {
+	return turnstile().
+		From("locked").On("fault").To("broken").
+		Final("broken").
+		OnEnter("unlocked", func(context.Context, fsm.Event) error {
+			if *jammed > 0 {
+				*jammed--
+				return jammedError
+			}
+
+			return nil
+		})
+}
+
+func TestErrorPolicy_Retry(t *testing.T) {
+	jammed := 2
+
+	m, _, err := jammable(&jammed).Build(uuid.New(), "turnstile",
+		fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.RetryPolicy(3, time.Millisecond)),
+	)
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	res, err := m.Send(context.Background(), event("coin"))
+	if err != nil {
+		t.Fatalf("expected the event to succeed after retrying - %v", err)
+	}
+
+	if res.Current != "unlocked" {
+		t.Errorf("expected the machine to be unlocked, but was %s", res.Current)
+	}
+}
+
+func TestErrorPolicy_RetryReleasesMachine(t *testing.T) {
+	jammed := 1
+	clock := fsm.NewManualClock(time.Now())
+
+	m, _, err := jammable(&jammed).Build(uuid.New(), "turnstile",
+		fsm.WithClock(clock),
+		fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.RetryPolicy(2, time.Minute)),
+	)
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	sent := make(chan fsm.Result)
+
+	go func() {
+		res, _ := m.Send(context.Background(), event("coin"))
+		sent <- res
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Pending() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	current := make(chan string)
+
+	go func() {
+		current <- m.Current()
+	}()
+
+	select {
+	case c := <-current:
+		if c != "locked" {
+			t.Errorf("expected the machine to be locked while waiting to retry, but was %s", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the machine not to be locked while waiting to retry")
+	}
+
+	clock.Advance(time.Minute)
+
+	if res := <-sent; res.Current != "unlocked" {
+		t.Errorf("expected the retry to unlock the machine, but was %s", res.Current)
+	}
+}
+
+func TestErrorPolicy_RetryWhileReplaying(t *testing.T) {
+	ctx := context.Background()
+	journal := fsm.NewMemoryJournal()
+	id := uuid.New()
+	jammed := 0
+
+	m, _, err := jammable(&jammed).Build(id, "turnstile", fsm.WithJournal(journal, testCodec{}))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if _, err := m.Send(ctx, event("coin")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	// the replayed event fails once, it must be retried without waiting for the backoff
+	jammed = 1
+	done := make(chan error)
+
+	go func() {
+		_, _, err := jammable(&jammed).Rebuild(ctx, id, "turnstile",
+			fsm.WithJournal(journal, testCodec{}),
+			fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.RetryPolicy(2, time.Hour)),
+		)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("could not rebuild state machine - %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the replayed event to be retried without a backoff")
+	}
+}
+
+func TestErrorPolicy_DeadLetter(t *testing.T) {
+	ctx := context.Background()
+	jammed := 3
+
+	file, err := fsm.OpenFileDeadLetters(filepath.Join(t.TempDir(), "dead-letters.log"), testCodec{})
+	if err != nil {
+		t.Fatalf("could not open dead-letter file - %v", err)
+	}
+
+	stores := map[string]fsm.DeadLetterStore{
+		"memory": fsm.NewMemoryDeadLetters(),
+		"file":   file,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			jammed = 3
+
+			m, _, err := jammable(&jammed).Build(uuid.New(), "turnstile",
+				fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.RetryPolicy(3, time.Millisecond)),
+				fsm.WithDeadLetterSink(store),
+			)
+
+			if err != nil {
+				t.Fatalf("could not build state machine - %v", err)
+			}
+
+			coin := event("coin")
+
+			res, err := m.Send(ctx, coin)
+			if !errors.Is(err, jammedError) || !res.DeadLettered {
+				t.Fatalf("expected the event to be dead-lettered, got %+v - %v", res, err)
+			}
+
+			letters, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("could not list dead letters - %v", err)
+			}
+
+			if len(letters) != 1 || letters[0].Event.ID() != coin.ID() || letters[0].Attempts != 3 {
+				t.Fatalf("unexpected dead letters %+v", letters)
+			}
+
+			// the mechanism has been fixed, so the event can be re-injected
+			results, err := fsm.Reinject(ctx, store, m)
+			if err != nil {
+				t.Fatalf("could not reinject events - %v", err)
+			}
+
+			if len(results) != 1 || results[0].Current != "unlocked" {
+				t.Errorf("expected the re-injected event to unlock the machine, got %+v", results)
+			}
+
+			if letters, _ := store.List(ctx); len(letters) != 0 {
+				t.Errorf("expected the re-injected event to be removed, got %+v", letters)
+			}
+		})
+	}
+}
+
+func TestErrorPolicy_Halt(t *testing.T) {
+	m, _, err := turnstile().Build(uuid.New(), "turnstile", fsm.WithErrorPolicy(fsm.AnyError, fsm.HaltPolicy()))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	res, err := m.Send(context.Background(), event("push"))
+	if err == nil || !res.Stopped {
+		t.Fatalf("expected the machine to halt, got %+v - %v", res, err)
+	}
+
+	if _, err := m.Send(context.Background(), event("coin")); err == nil {
+		t.Errorf("expected a halted machine to reject events")
+	}
+}
+
+func TestErrorPolicy_GoTo(t *testing.T) {
+	jammed := 1
+	def := jammable(&jammed)
+
+	broken, err := def.NewState("broken")
+	if err != nil {
+		t.Fatalf("could not create error state - %v", err)
+	}
+
+	m, _, err := def.Build(uuid.New(), "turnstile", fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.GoToPolicy(broken)))
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	res, err := m.Send(context.Background(), event("coin"))
+	if !errors.Is(err, jammedError) || res.Current != "broken" || !res.Transitioned {
+		t.Errorf("expected the machine to move to the error state, got %+v - %v", res, err)
+	}
+}
+
+func TestChannelSink_Full(t *testing.T) {
+	jammed := 2
+	letters := make(chan fsm.DeadLetter, 1)
+
+	m, _, err := jammable(&jammed).Build(uuid.New(), "turnstile",
+		fsm.WithErrorPolicy(fsm.ErrorIs(jammedError), fsm.DeadLetterPolicy()),
+		fsm.WithDeadLetterSink(fsm.ChannelSink(letters)),
+	)
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if res, _ := m.Send(context.Background(), event("coin")); !res.DeadLettered {
+		t.Fatalf("expected the event to be dead-lettered, got %+v", res)
+	}
+
+	// nobody reads the channel, so the second dead letter is dropped rather than blocking the machine
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		res, err := m.Send(context.Background(), event("coin"))
+		if res.DeadLettered || !errors.Is(err, fsm.DeadLetterSinkFullError) {
+			t.Errorf("expected the dead letter to be dropped, got %+v - %v", res, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a full dead-letter channel not to block the machine")
+	}
+}
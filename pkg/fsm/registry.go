@@ -0,0 +1,373 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// binaryFormat is the first byte of every envelope encoded by BinaryCodec, it allows the layout to change in future
+const binaryFormat byte = 1
+
+// UnregisteredEventError is returned when encoding or decoding an event whose name has not been registered
+var UnregisteredEventError = errors.New("event has not been registered")
+
+// Envelope is the standard representation of an event that is sent to another process or persisted.
+// The payload contains the event's own data, and the version is the schema version of the payload
+type Envelope struct {
+	ID        uuid.UUID       `json:"id"`
+	Source    string          `json:"source"`
+	Name      string          `json:"name"`
+	Timestamp int64           `json:"timestamp"`
+	Version   int             `json:"version"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// PayloadMarshaler is implemented by events that carry data, events that do not implement it are sent without a payload
+type PayloadMarshaler interface {
+	MarshalPayload() ([]byte, error)
+}
+
+// EventFactory recreates a concrete event from its envelope, the payload has already been upcast to the registered version
+type EventFactory func(Envelope) (Event, error)
+
+// Upcaster converts a payload from one schema version to the next
+type Upcaster func(payload []byte) ([]byte, error)
+
+// EnvelopeCodec converts envelopes to and from bytes
+type EnvelopeCodec interface {
+	Marshal(Envelope) ([]byte, error)
+	Unmarshal([]byte) (Envelope, error)
+}
+
+type eventType struct {
+	version   int
+	factory   EventFactory
+	upcasters map[int]Upcaster
+}
+
+// Registry maps event names to the factories that recreate them, so events can be encoded into envelopes and decoded
+// back into their concrete types. A Registry is an EventCodec, so it can be used to write events to a journal
+type Registry struct {
+	mu    sync.RWMutex
+	codec EnvelopeCodec
+	types map[string]*eventType
+}
+
+// NewRegistry creates an empty registry that encodes envelopes using the given codec, if no codec is given JSONCodec is used
+func NewRegistry(codec EnvelopeCodec) *Registry {
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	return &Registry{
+		codec: codec,
+		types: make(map[string]*eventType),
+	}
+}
+
+// Register adds the event with the given name to the registry. The version is the current schema version of the
+// event's payload, events are always encoded using the current version
+func (r *Registry) Register(name string, version int, factory EventFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("events must be registered with a name and a factory")
+	}
+
+	if version < 1 {
+		return fmt.Errorf("event %s must have a version of at least 1", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.types[name]; ok {
+		return fmt.Errorf("event %s has already been registered", name)
+	}
+
+	r.types[name] = &eventType{
+		version:   version,
+		factory:   factory,
+		upcasters: make(map[int]Upcaster),
+	}
+
+	return nil
+}
+
+// Upcast adds a function that converts the payload of the named event from the given version to the next version.
+// When an envelope with an old version is decoded, the upcasters are applied in turn until the payload reaches
+// the registered version
+func (r *Registry) Upcast(name string, from int, fn Upcaster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.types[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", UnregisteredEventError, name)
+	}
+
+	if from < 1 || from >= t.version {
+		return fmt.Errorf("cannot upcast event %s from version %d, the registered version is %d", name, from, t.version)
+	}
+
+	t.upcasters[from] = fn
+
+	return nil
+}
+
+// Seal wraps the event in an envelope using its registered version
+func (r *Registry) Seal(event Event) (Envelope, error) {
+	t, err := r.lookup(event.Name())
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	env := Envelope{
+		ID:        event.ID(),
+		Source:    event.Source(),
+		Name:      event.Name(),
+		Timestamp: event.Timestamp(),
+		Version:   t.version,
+	}
+
+	if p, ok := event.(PayloadMarshaler); ok {
+		payload, err := p.MarshalPayload()
+		if err != nil {
+			return Envelope{}, fmt.Errorf("could not marshal payload of event %s: %w", event.ID(), err)
+		}
+
+		env.Payload = payload
+	}
+
+	return env, nil
+}
+
+// Open upcasts the envelope's payload to the registered version and recreates the event using its factory
+func (r *Registry) Open(env Envelope) (Event, error) {
+	t, err := r.lookup(env.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.Version > t.version {
+		return nil, fmt.Errorf("event %s has version %d, which is newer than the registered version %d", env.Name, env.Version, t.version)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for env.Version < t.version {
+		up, ok := t.upcasters[env.Version]
+		if !ok {
+			return nil, fmt.Errorf("cannot upcast event %s from version %d, no upcaster has been registered", env.Name, env.Version)
+		}
+
+		payload, err := up(env.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("could not upcast event %s from version %d: %w", env.Name, env.Version, err)
+		}
+
+		env.Payload = payload
+		env.Version++
+	}
+
+	return t.factory(env)
+}
+
+// Encode seals the event in an envelope and marshals it using the registry's codec
+func (r *Registry) Encode(event Event) ([]byte, error) {
+	env, err := r.Seal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.codec.Marshal(env)
+}
+
+// Decode unmarshals the envelope using the registry's codec and recreates the event
+func (r *Registry) Decode(data []byte) (Event, error) {
+	env, err := r.codec.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Open(env)
+}
+
+func (r *Registry) lookup(name string) (*eventType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[name]
+
+	switch {
+	case ok:
+		return t, nil
+	case strings.HasPrefix(name, timeoutPrefix):
+		// timer events are sent by the machine itself, so they never need to be registered
+		return &eventType{version: 1, factory: timerEventFactory}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", UnregisteredEventError, name)
+	}
+}
+
+// JSONCodec encodes envelopes as JSON objects, payloads that are valid JSON are embedded in the object as they are
+var JSONCodec EnvelopeCodec = jsonCodec{}
+
+// BinaryCodec encodes envelopes in a compact binary format: a format byte, the 16 byte event id, the timestamp
+// as a big endian int64, then the version, source, name and payload, each prefixed with their length as a uvarint
+var BinaryCodec EnvelopeCodec = binaryCodec{}
+
+type jsonCodec struct{}
+
+// jsonPayload is used to marshal envelopes whose payload is not valid JSON, so it is encoded as base64 instead
+type jsonPayload struct {
+	Envelope
+	Payload []byte `json:"payload,omitempty"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+func (jsonCodec) Marshal(env Envelope) ([]byte, error) {
+	if len(env.Payload) == 0 || json.Valid(env.Payload) {
+		return json.Marshal(env)
+	}
+
+	return json.Marshal(jsonPayload{Envelope: env, Payload: env.Payload, Binary: true})
+}
+
+func (jsonCodec) Unmarshal(data []byte) (Envelope, error) {
+	var raw struct {
+		Envelope
+		Binary bool `json:"binary"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Envelope{}, fmt.Errorf("could not unmarshal event envelope: %w", err)
+	}
+
+	env := raw.Envelope
+
+	if raw.Binary {
+		var payload []byte
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			return Envelope{}, fmt.Errorf("could not unmarshal event payload: %w", err)
+		}
+
+		env.Payload = payload
+	}
+
+	return env, nil
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(env Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte(binaryFormat)
+	buf.Write(env.ID[:])
+
+	_ = binary.Write(&buf, binary.BigEndian, env.Timestamp)
+
+	writeUvarint(&buf, uint64(env.Version))
+	writeBytes(&buf, []byte(env.Source))
+	writeBytes(&buf, []byte(env.Name))
+	writeBytes(&buf, env.Payload)
+
+	return buf.Bytes(), nil
+}
+
+func (binaryCodec) Unmarshal(data []byte) (Envelope, error) {
+	r := bytes.NewReader(data)
+
+	format, err := r.ReadByte()
+	if err != nil {
+		return Envelope{}, truncated(err)
+	}
+
+	if format != binaryFormat {
+		return Envelope{}, fmt.Errorf("unsupported event envelope format %d", format)
+	}
+
+	var env Envelope
+
+	if _, err := io.ReadFull(r, env.ID[:]); err != nil {
+		return Envelope{}, truncated(err)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &env.Timestamp); err != nil {
+		return Envelope{}, truncated(err)
+	}
+
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Envelope{}, truncated(err)
+	}
+
+	env.Version = int(version)
+
+	source, err := readBytes(r)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	name, err := readBytes(r)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	payload, err := readBytes(r)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	env.Source = string(source)
+	env.Name = string(name)
+
+	if len(payload) > 0 {
+		env.Payload = payload
+	}
+
+	return env, nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+
+	if n > uint64(r.Len()) {
+		return nil, truncated(nil)
+	}
+
+	b := make([]byte, n)
+	_, _ = r.Read(b)
+
+	return b, nil
+}
+
+func truncated(err error) error {
+	if err == nil {
+		return errors.New("event envelope is truncated")
+	}
+
+	return fmt.Errorf("event envelope is truncated: %w", err)
+}
@@ -0,0 +1,130 @@
+package fsm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+type coinInserted struct {
+	id        uuid.UUID
+	timestamp int64
+	Amount    int    `json:"amount"`
+	Currency  string `json:"currency"`
+}
+
+func (e coinInserted) ID() uuid.UUID                   { return e.id }
+func (e coinInserted) Source() string                  { return "coin-slot" }
+func (e coinInserted) Name() string                    { return "coin" }
+func (e coinInserted) Timestamp() int64                { return e.timestamp }
+func (e coinInserted) MarshalPayload() ([]byte, error) { return json.Marshal(e) }
+
+func coinRegistry(t *testing.T, codec fsm.EnvelopeCodec) *fsm.Registry {
+	r := fsm.NewRegistry(codec)
+
+	err := r.Register("coin", 2, func(env fsm.Envelope) (fsm.Event, error) {
+		e := coinInserted{id: env.ID, timestamp: env.Timestamp}
+		if err := json.Unmarshal(env.Payload, &e); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	})
+
+	if err != nil {
+		t.Fatalf("could not register event - %v", err)
+	}
+
+	// version 1 of the payload had no currency
+	err = r.Upcast("coin", 1, func(payload []byte) ([]byte, error) {
+		var v1 map[string]interface{}
+		if err := json.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+
+		v1["currency"] = "GBP"
+
+		return json.Marshal(v1)
+	})
+
+	if err != nil {
+		t.Fatalf("could not register upcaster - %v", err)
+	}
+
+	return r
+}
+
+func TestRegistry_RoundTrip(t *testing.T) {
+	codecs := map[string]fsm.EnvelopeCodec{
+		"json":   fsm.JSONCodec,
+		"binary": fsm.BinaryCodec,
+	}
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			r := coinRegistry(t, codec)
+			want := coinInserted{id: uuid.New(), timestamp: time.Now().UnixNano(), Amount: 50, Currency: "EUR"}
+
+			data, err := r.Encode(want)
+			if err != nil {
+				t.Fatalf("could not encode event - %v", err)
+			}
+
+			got, err := r.Decode(data)
+			if err != nil {
+				t.Fatalf("could not decode event - %v", err)
+			}
+
+			if got != want {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestRegistry_Upcast(t *testing.T) {
+	r := coinRegistry(t, nil)
+
+	got, err := r.Open(fsm.Envelope{ID: uuid.New(), Name: "coin", Version: 1, Payload: []byte(`{"amount":20}`)})
+	if err != nil {
+		t.Fatalf("could not open envelope - %v", err)
+	}
+
+	if coin := got.(coinInserted); coin.Amount != 20 || coin.Currency != "GBP" {
+		t.Errorf("expected payload to be upcast, got %+v", coin)
+	}
+
+	if _, err := r.Open(fsm.Envelope{Name: "coin", Version: 3}); err == nil {
+		t.Errorf("expected an error opening an envelope from a newer version")
+	}
+}
+
+func TestRegistry_Unregistered(t *testing.T) {
+	r := coinRegistry(t, nil)
+
+	if _, err := r.Encode(event("push")); !errors.Is(err, fsm.UnregisteredEventError) {
+		t.Errorf("expected unregistered event error, got %v", err)
+	}
+
+	if err := r.Register("coin", 1, func(fsm.Envelope) (fsm.Event, error) { return nil, nil }); err == nil {
+		t.Errorf("expected an error registering an event twice")
+	}
+}
+
+func TestBinaryCodec_Truncated(t *testing.T) {
+	data, err := fsm.BinaryCodec.Marshal(fsm.Envelope{ID: uuid.New(), Name: "coin", Version: 1, Payload: []byte("payload")})
+	if err != nil {
+		t.Fatalf("could not marshal envelope - %v", err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := fsm.BinaryCodec.Unmarshal(data[:i]); err == nil {
+			t.Errorf("expected an error unmarshalling %d of %d bytes", i, len(data))
+		}
+	}
+}
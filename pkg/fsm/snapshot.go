@@ -0,0 +1,236 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// processedWindow is the number of processed event IDs kept by the machine to detect events
+// that are delivered again after the machine has been restored
+const processedWindow = 128
+
+// SnapshotNotFoundError is returned by a SnapshotStore when there is no snapshot for a machine
+var SnapshotNotFoundError = errors.New("snapshot not found")
+
+// Snapshot captures the current state of a machine so it can be restored after a restart
+type Snapshot struct {
+	// MachineID is the unique id of the state machine
+	MachineID uuid.UUID `json:"machine-id"`
+	// Name is the name assigned to the state machine
+	Name string `json:"name"`
+	// State is the description of the current state
+	State string `json:"state"`
+	// Data is the serialized data of the current state, if the state implements Snapshotter
+	Data []byte `json:"data,omitempty"`
+	// LastEventID is the id of the last event processed by the machine
+	LastEventID uuid.UUID `json:"last-event-id"`
+	// Processed contains the ids of the most recent events processed by the machine
+	Processed []uuid.UUID `json:"processed,omitempty"`
+	// Sequence is the journal sequence of the last event processed by the machine, if it has a journal
+	Sequence uint64 `json:"seq,omitempty"`
+	// Timestamp is the time the snapshot was taken as nanoseconds past epoch
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Snapshotter is implemented by states that hold data which must be saved in snapshots
+type Snapshotter interface {
+	SnapshotData() ([]byte, error)
+}
+
+// StateFactory recreates a state from the state description and data saved in a snapshot
+type StateFactory func(state string, data []byte) (State, error)
+
+// SnapshotStore saves and loads machine snapshots, implementations can use whatever storage is
+// appropriate for the service, e.g. a database, Redis or the local file system
+type SnapshotStore interface {
+	// Save stores the snapshot, replacing any previous snapshot for the machine
+	Save(context.Context, Snapshot) error
+	// Load retrieves the latest snapshot for the machine, returning SnapshotNotFoundError if there is none
+	Load(context.Context, uuid.UUID) (Snapshot, error)
+}
+
+// Snapshot captures the current state of the machine
+func (m *machine) Snapshot() (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.snapshot()
+}
+
+// snapshot captures the current state of the machine, the caller must hold the machine's lock
+func (m *machine) snapshot() (Snapshot, error) {
+	snap := Snapshot{
+		MachineID:   m.id,
+		Name:        m.name,
+		State:       m.current.Description(),
+		LastEventID: m.lastEvent,
+		Processed:   append([]uuid.UUID{}, m.processed...),
+		Sequence:    m.sequence,
+		Timestamp:   m.clock.Now().UnixNano(),
+	}
+
+	if s, ok := m.current.(Snapshotter); ok {
+		data, err := s.SnapshotData()
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("could not serialize state %s: %w", m.current.Description(), err)
+		}
+
+		snap.Data = data
+	}
+
+	return snap, nil
+}
+
+// saveSnapshot saves a snapshot after every event, or when the machine has a journal and a snapshot interval,
+// once the interval has been reached. Snapshots are not saved while the machine is replaying its journal
+func (m *machine) saveSnapshot(ctx context.Context) error {
+	if m.snapshots == nil || m.replaying {
+		return nil
+	}
+
+	if m.journal != nil && m.snapshotEvery > 0 {
+		if m.sinceSnapshot < m.snapshotEvery {
+			return nil
+		}
+
+		m.sinceSnapshot = 0
+	}
+
+	snap, err := m.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return m.snapshots.Save(ctx, snap)
+}
+
+func (m *machine) hasProcessed(id uuid.UUID) bool {
+	for _, p := range m.processed {
+		if p == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *machine) markProcessed(id uuid.UUID) {
+	m.lastEvent = id
+	m.processed = append(m.processed, id)
+
+	if len(m.processed) > processedWindow {
+		m.processed = m.processed[len(m.processed)-processedWindow:]
+	}
+}
+
+// Restore recreates a state machine from a snapshot, using the factory to recreate its current state.
+// Events that were processed before the snapshot was taken are skipped if they are received again
+func Restore(snap Snapshot, factory StateFactory, opts ...Option) (*machine, chan error, error) {
+	current, err := factory(snap.State, snap.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not restore state %s: %w", snap.State, err)
+	}
+
+	m, errCh := New(snap.MachineID, snap.Name, current, opts...)
+	m.lastEvent = snap.LastEventID
+	m.processed = append([]uuid.UUID{}, snap.Processed...)
+	m.sequence = snap.Sequence
+
+	return m, errCh, nil
+}
+
+// MemorySnapshotStore is a SnapshotStore that keeps snapshots in memory, it is intended for tests
+// and machines that only need to survive being recreated within the same process
+type MemorySnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[uuid.UUID]Snapshot
+}
+
+// NewMemorySnapshotStore creates an empty in-memory snapshot store
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{
+		snapshots: make(map[uuid.UUID]Snapshot),
+	}
+}
+
+func (s *MemorySnapshotStore) Save(_ context.Context, snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[snap.MachineID] = snap
+
+	return nil
+}
+
+func (s *MemorySnapshotStore) Load(_ context.Context, id uuid.UUID) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return Snapshot{}, SnapshotNotFoundError
+	}
+
+	return snap, nil
+}
+
+// FileSnapshotStore is a SnapshotStore that saves each machine's snapshot as a JSON file in a directory
+type FileSnapshotStore struct {
+	dir string
+}
+
+// NewFileSnapshotStore creates a snapshot store that saves snapshots in the given directory
+func NewFileSnapshotStore(dir string) (*FileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+
+	return &FileSnapshotStore{dir: dir}, nil
+}
+
+// Save writes the snapshot to a temporary file and renames it, so a crash while saving never leaves
+// a partially written snapshot
+func (s *FileSnapshotStore) Save(_ context.Context, snap Snapshot) error {
+	content, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(snap.MachineID)
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *FileSnapshotStore) Load(_ context.Context, id uuid.UUID) (Snapshot, error) {
+	content, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Snapshot{}, SnapshotNotFoundError
+		}
+
+		return Snapshot{}, err
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(content, &snap); err != nil {
+		return Snapshot{}, fmt.Errorf("could not read snapshot for %s: %w", id, err)
+	}
+
+	return snap, nil
+}
+
+func (s *FileSnapshotStore) path(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String()+".json")
+}
@@ -0,0 +1,107 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+func TestRestore(t *testing.T) {
+	stores := map[string]func(t *testing.T) fsm.SnapshotStore{
+		"memory": func(*testing.T) fsm.SnapshotStore {
+			return fsm.NewMemorySnapshotStore()
+		},
+		"file": func(t *testing.T) fsm.SnapshotStore {
+			store, err := fsm.NewFileSnapshotStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("could not create snapshot store - %v", err)
+			}
+
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			id := uuid.New()
+
+			m, errCh, err := worker().Build(id, "worker", fsm.WithSnapshotStore(store))
+			if err != nil {
+				t.Fatalf("could not build state machine - %v", err)
+			}
+
+			pause := event("pause")
+
+			if errs := runMachine(m, errCh, event("start"), event("fetched"), pause); len(errs) != 0 {
+				t.Fatalf("unexpected errors - %v", errs)
+			}
+
+			snap, err := store.Load(context.Background(), id)
+			if err != nil {
+				t.Fatalf("could not load snapshot - %v", err)
+			}
+
+			if snap.State != "paused" || snap.LastEventID != pause.ID() {
+				t.Errorf("unexpected snapshot %+v", snap)
+			}
+
+			restored, errCh, err := worker().Restore(snap, fsm.WithSnapshotStore(store))
+			if err != nil {
+				t.Fatalf("could not restore state machine - %v", err)
+			}
+
+			if restored.Current() != "paused" {
+				t.Errorf("expected restored machine to be paused, but was %s", restored.Current())
+			}
+
+			// the pause event has already been processed so it must be skipped rather than rejected
+			if errs := runMachine(restored, errCh, pause, event("resume")); len(errs) != 0 {
+				t.Fatalf("unexpected errors - %v", errs)
+			}
+
+			if restored.Current() != "processing" {
+				t.Errorf("expected restored machine to resume processing, but was %s", restored.Current())
+			}
+		})
+	}
+}
+
+func TestMemorySnapshotStore_NotFound(t *testing.T) {
+	_, err := fsm.NewMemorySnapshotStore().Load(context.Background(), uuid.New())
+
+	if !errors.Is(err, fsm.SnapshotNotFoundError) {
+		t.Errorf("expected snapshot not found, got %v", err)
+	}
+}
+
+func TestMachine_SnapshotWhileSending(t *testing.T) {
+	m, _, err := turnstile().Build(uuid.New(), "turnstile")
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			_, _ = m.Send(context.Background(), event("coin"))
+			_, _ = m.Send(context.Background(), event("push"))
+		}
+	}()
+
+	// run with -race to check the snapshot does not read the machine while it is processing events
+	for i := 0; i < 100; i++ {
+		if _, err := m.Snapshot(); err != nil {
+			t.Fatalf("could not take snapshot - %v", err)
+		}
+	}
+
+	<-done
+}
@@ -1,6 +1,7 @@
 package fsm
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -20,10 +21,36 @@ type State interface {
 	WithTransitions(...Transition) State
 }
 
+// Enterer is implemented by states that need to run code when the state machine enters them.
+// If OnEnter returns an error the transition is aborted and the machine remains in its current state,
+// the exit hook of the current state has already run by then and is not undone
+type Enterer interface {
+	OnEnter(context.Context, Event) error
+}
+
+// Exiter is implemented by states that need to run code when the state machine leaves them.
+// If OnExit returns an error the transition is aborted and the machine remains in the state.
+// OnExit runs again each time the machine tries to leave the state, including after a transition that was aborted
+// by the transition's action or the next state's entry hook, so it should be safe to repeat
+type Exiter interface {
+	OnExit(context.Context, Event) error
+}
+
 // Next takes the current state and a list of transitions then evaluates each
 // transition to see which state it should transition to.
 // If no transition check passes, Next will return the current state
 func Next(current State, transitions ...Transition) State {
+	if tr, ok := Select(current, transitions...); ok {
+		return tr.Next(current)
+	}
+
+	return current
+}
+
+// Select takes the current state and a list of transitions then returns the first
+// transition where all the checks pass. If no transition check passes, Select will
+// return false
+func Select(current State, transitions ...Transition) (Transition, bool) {
 	for _, tr := range transitions {
 		failed := false
 		// evaluate all check functions
@@ -37,13 +64,12 @@ func Next(current State, transitions ...Transition) State {
 
 		if !failed {
 			// if all checks pass, then we should transition to the next state
-			return tr.Next(current)
+			return tr, true
 		}
 	}
 
 	// if we are here, then at least one check on each transition must have failed
-	// so return the current state
-	return current
+	return Transition{}, false
 }
 
 func UnexpectedEventError(event Event) error {
@@ -0,0 +1,364 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+const (
+	// TimerSource is the source of the events sent by timers
+	TimerSource = "fsm.timer"
+	// timeoutPrefix is the prefix of the names of timer events
+	timeoutPrefix = "timeout/"
+)
+
+// Clock provides the current time and schedules timers, a ManualClock can be used in tests to control time
+type Clock interface {
+	Now() time.Time
+	AfterFunc(time.Duration, func()) Timer
+}
+
+// Timer is a scheduled function that can be cancelled
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock is the Clock used by machines by default, it uses the system time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return time.AfterFunc(d, fn)
+}
+
+// Timeout is declared by a state that should receive an event if the machine is still in the state once the
+// duration has elapsed. The timer is started when the machine enters the state and cancelled when it leaves
+type Timeout struct {
+	// State is the name of the state declaring the timeout
+	State string
+	// After is the time the machine must remain in the state before the event is sent
+	After time.Duration
+	// Event is the name of the event that is sent
+	Event string
+}
+
+// Timed is implemented by states that declare timeouts
+type Timed interface {
+	Timeouts() []Timeout
+}
+
+// reentrant is implemented by states that stay in place when the machine transitions, such as the composite
+// states created from a Definition, and reports whether the named state was entered again by the last transition
+type reentrant interface {
+	reentered(name string) bool
+}
+
+// TimeoutEventName returns the name of the event sent when a timeout of the given duration has elapsed
+func TimeoutEventName(d time.Duration) string {
+	return timeoutPrefix + d.String()
+}
+
+// IsTimeout checks if the event was sent by a timer
+func IsTimeout(e Event) bool {
+	return e.Source() == TimerSource && strings.HasPrefix(e.Name(), timeoutPrefix)
+}
+
+// TimerEvent is the event sent to a machine when a timeout has elapsed
+type TimerEvent struct {
+	id        uuid.UUID
+	name      string
+	state     string
+	timestamp int64
+}
+
+// NewTimerEvent creates the event sent when the state's timeout has elapsed
+func NewTimerEvent(name, state string, t time.Time) TimerEvent {
+	return TimerEvent{
+		id:        uuid.New(),
+		name:      name,
+		state:     state,
+		timestamp: t.UnixNano(),
+	}
+}
+
+func (e TimerEvent) ID() uuid.UUID {
+	return e.id
+}
+
+func (e TimerEvent) Source() string {
+	return TimerSource
+}
+
+func (e TimerEvent) Name() string {
+	return e.name
+}
+
+func (e TimerEvent) Timestamp() int64 {
+	return e.timestamp
+}
+
+// State returns the name of the state that declared the timeout
+func (e TimerEvent) State() string {
+	return e.state
+}
+
+func (e TimerEvent) MarshalPayload() ([]byte, error) {
+	return json.Marshal(map[string]string{"state": e.state})
+}
+
+// timerEventFactory recreates timer events from their envelope, it is used by every Registry
+func timerEventFactory(env Envelope) (Event, error) {
+	var payload struct {
+		State string `json:"state"`
+	}
+
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return TimerEvent{id: env.ID, name: env.Name, state: payload.State, timestamp: env.Timestamp}, nil
+}
+
+type runningTimer struct {
+	timeout Timeout
+	timer   Timer
+}
+
+// timerKey identifies a timeout, states that do not name themselves in their timeouts are identified by their id
+// so every new state starts its own timers
+func timerKey(s State, t Timeout) string {
+	if t.State == "" {
+		return s.ID().String() + "/" + t.Event
+	}
+
+	return t.State + "/" + t.Event
+}
+
+// scheduleTimers starts the timers for the timeouts declared by the current state and cancels the timers of
+// states the machine has left. Timers for states that are still active keep running unless the state was re-entered.
+// No timers are started until the machine has been started, or once it has stopped
+func (m *machine) scheduleTimers() {
+	if !m.started || m.stopped {
+		return
+	}
+
+	desired := make(map[string]Timeout)
+
+	if timed, ok := m.current.(Timed); ok {
+		for _, t := range timed.Timeouts() {
+			desired[timerKey(m.current, t)] = t
+		}
+	}
+
+	r, _ := m.current.(reentrant)
+
+	for key, rt := range m.timers {
+		t, ok := desired[key]
+
+		if !ok || (r != nil && r.reentered(t.State)) {
+			rt.timer.Stop()
+			delete(m.timers, key)
+		}
+	}
+
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := m.timers[key]; ok {
+			continue
+		}
+
+		rt := &runningTimer{timeout: desired[key]}
+		key := key
+
+		rt.timer = m.clock.AfterFunc(rt.timeout.After, func() {
+			m.fireTimer(key, rt)
+		})
+
+		m.timers[key] = rt
+	}
+}
+
+// stopTimers cancels every running timer
+func (m *machine) stopTimers() {
+	for key, rt := range m.timers {
+		rt.timer.Stop()
+		delete(m.timers, key)
+	}
+}
+
+// fireTimer sends the timer event to the machine, unless the timer was cancelled before it could take the lock
+func (m *machine) fireTimer(key string, rt *runningTimer) {
+	m.mu.Lock()
+
+	if m.timers[key] != rt || m.stopped {
+		m.mu.Unlock()
+		return
+	}
+
+	delete(m.timers, key)
+
+	event := NewTimerEvent(rt.timeout.Event, rt.timeout.State, m.clock.Now())
+	_, err := m.handle(context.Background(), event)
+	onTimer := m.onTimer
+
+	m.mu.Unlock()
+	m.notify()
+
+	if onTimer != nil {
+		onTimer()
+	}
+
+	if err != nil {
+		m.publishError(fmt.Errorf("timer %s: %w", rt.timeout.Event, err))
+	}
+}
+
+// publishError sends the error to the error channel without blocking, if the channel is full or has been closed
+// because Run has finished the error is logged. The errors of a managed machine are passed to its manager
+func (m *machine) publishError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.onError != nil {
+		m.onError(err)
+		return
+	}
+
+	if m.errClosed {
+		logger.Logger().Error("State machine has finished running, dropping state machine error", zap.Error(err))
+		return
+	}
+
+	select {
+	case m.errCh <- err:
+	default:
+		logger.Logger().Error("Error channel is full, dropping state machine error", zap.Error(err))
+	}
+}
+
+// ManualClock is a Clock that only moves when it is advanced, so tests can control when timers fire
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock   *ManualClock
+	at      time.Time
+	fn      func()
+	stopped bool
+}
+
+// NewManualClock creates a clock set to the given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, fn func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{clock: c, at: c.now.Add(d), fn: fn}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Advance moves the clock forward, running the functions of the timers that are due in the order they are due.
+// The functions run on the calling goroutine, so they have completed when Advance returns
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+
+		var next *manualTimer
+
+		for _, t := range c.timers {
+			if !t.stopped && !t.at.After(target) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+
+		if next == nil {
+			c.now = target
+			c.mu.Unlock()
+			return
+		}
+
+		next.stopped = true
+		c.now = next.at
+		c.prune()
+		c.mu.Unlock()
+
+		next.fn()
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (c *ManualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune()
+
+	return len(c.timers)
+}
+
+func (c *ManualClock) prune() {
+	timers := c.timers[:0]
+
+	for _, t := range c.timers {
+		if !t.stopped {
+			timers = append(timers, t)
+		}
+	}
+
+	c.timers = timers
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.stopped {
+		return false
+	}
+
+	t.stopped = true
+
+	return true
+}
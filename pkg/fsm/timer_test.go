@@ -0,0 +1,169 @@
+package fsm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/birchwood-langham/bootstrap/pkg/fsm"
+)
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	clock := fsm.NewManualClock(time.Now())
+
+	m, _, err := turnstile().
+		From("unlocked").After(30*time.Second).To("locked").
+		Build(uuid.New(), "turnstile", fsm.WithClock(clock))
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if _, err := m.Send(ctx, event("coin")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	clock.Advance(29 * time.Second)
+
+	if m.Current() != "unlocked" {
+		t.Errorf("expected the machine to still be unlocked, but was %s", m.Current())
+	}
+
+	clock.Advance(time.Second)
+
+	if m.Current() != "locked" {
+		t.Errorf("expected the timeout to lock the machine, but was %s", m.Current())
+	}
+
+	// leaving the state before the timeout cancels the timer
+	if _, err := m.Send(ctx, event("coin")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	if _, err := m.Send(ctx, event("push")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	if clock.Pending() != 0 {
+		t.Errorf("expected the timer to be cancelled, %d timers are pending", clock.Pending())
+	}
+}
+
+func TestTimeout_CompositeState(t *testing.T) {
+	ctx := context.Background()
+	clock := fsm.NewManualClock(time.Now())
+
+	m, _, err := worker().
+		From("running").After(time.Minute).To("paused").
+		Build(uuid.New(), "worker", fsm.WithClock(clock))
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if _, err := m.Send(ctx, event("start")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	clock.Advance(40 * time.Second)
+
+	// moving between the children of running does not restart the timer of running
+	if _, err := m.Send(ctx, event("fetched")); err != nil {
+		t.Fatalf("unexpected error - %v", err)
+	}
+
+	clock.Advance(20 * time.Second)
+
+	if m.Current() != "paused" {
+		t.Errorf("expected the timeout of the composite state to pause the machine, but was %s", m.Current())
+	}
+}
+
+func TestTimeout_StartsWithMachine(t *testing.T) {
+	clock := fsm.NewManualClock(time.Now())
+
+	m, _, err := turnstile().
+		From("locked").After(time.Minute).To("unlocked").
+		Build(uuid.New(), "turnstile", fsm.WithClock(clock))
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if clock.Pending() != 0 {
+		t.Errorf("expected no timers before the machine is used, %d timers are pending", clock.Pending())
+	}
+
+	if _, err := m.Send(context.Background(), event("push")); err == nil {
+		t.Fatalf("expected the push to be rejected")
+	}
+
+	if clock.Pending() != 1 {
+		t.Errorf("expected the timer of the initial state to start with the first event, %d timers are pending", clock.Pending())
+	}
+}
+
+func TestTimeout_AfterRun(t *testing.T) {
+	clock := fsm.NewManualClock(time.Now())
+
+	m, errCh, err := turnstile().
+		From("unlocked").After(time.Minute).To("locked").
+		OnEnter("locked", func(context.Context, fsm.Event) error {
+			return errors.New("gate jammed")
+		}).
+		Build(uuid.New(), "turnstile", fsm.WithClock(clock))
+
+	if err != nil {
+		t.Fatalf("could not build state machine - %v", err)
+	}
+
+	if errs := runMachine(m, errCh, event("coin")); len(errs) != 0 {
+		t.Fatalf("unexpected errors - %v", errs)
+	}
+
+	if clock.Pending() != 0 {
+		t.Errorf("expected the timers to be cancelled when Run returns, %d timers are pending", clock.Pending())
+	}
+
+	// a timer firing now would publish its error on the closed error channel
+	clock.Advance(time.Minute)
+
+	if m.Current() != "unlocked" {
+		t.Errorf("expected the stopped machine to remain unlocked, but was %s", m.Current())
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := fsm.NewManualClock(start)
+	fired := make([]time.Duration, 0)
+
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		d := d
+		clock.AfterFunc(d, func() {
+			fired = append(fired, clock.Now().Sub(start))
+		})
+	}
+
+	stopped := clock.AfterFunc(time.Second, func() {
+		t.Errorf("stopped timer fired")
+	})
+
+	if !stopped.Stop() {
+		t.Errorf("expected the timer to be stopped")
+	}
+
+	clock.Advance(5 * time.Second)
+
+	if len(fired) != 3 || fired[0] != time.Second || fired[1] != 2*time.Second || fired[2] != 3*time.Second {
+		t.Errorf("expected timers to fire in order at their due time, got %v", fired)
+	}
+
+	if clock.Now() != start.Add(5*time.Second) {
+		t.Errorf("expected clock to be advanced to %v, got %v", start.Add(5*time.Second), clock.Now())
+	}
+}
@@ -1,5 +1,7 @@
 package fsm
 
+import "context"
+
 // CheckFn takes a state and determines whether or not it is ready to transition
 type CheckFn func(State) bool
 
@@ -9,12 +11,27 @@ type CheckFn func(State) bool
 // data and passed to the next state
 type NextFn func(State) State
 
+// ActionFn is executed by the state machine when a transition takes place, or a state is entered or exited.
+// It is given the event that triggered the transition, and if it returns an error the transition is aborted
+type ActionFn func(context.Context, Event) error
+
 // Transition contains the check functions required for a transition and the
 // Next function to generate the next state
 type Transition struct {
+	// Name is an optional label for the transition, it is used when the machine is drawn as a graph
+	Name string
 	// Checks are the checks that need to pass before the transition
 	// can take place
 	Checks []CheckFn
 	// Next creates the next state for the state machine to transition to
 	Next NextFn
+	// Action is an optional function executed by the state machine when the transition
+	// takes place, it is only executed for states that implement the Transitioner interface
+	Action ActionFn
+}
+
+// Transitioner is implemented by states that expose their transitions to the state machine,
+// allowing the state machine to select the transition itself and execute its Action
+type Transitioner interface {
+	Transitions() []Transition
 }
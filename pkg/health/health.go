@@ -0,0 +1,196 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the time each check is given to complete before it is reported as failed
+const DefaultTimeout = 5 * time.Second
+
+const (
+	// StatusUp is reported when a check, or every check in a report, succeeded
+	StatusUp = "up"
+	// StatusDown is reported when a check, or any check in a report, failed
+	StatusDown = "down"
+)
+
+// Checker checks the health of a dependency such as a database, returning an error if it is unhealthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckFunc is a function that implements Checker
+type CheckFunc func(ctx context.Context) error
+
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the outcome of every check in a registry
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Healthy returns true if every check succeeded
+func (r Report) Healthy() bool {
+	return r.Status == StatusUp
+}
+
+// Registry holds the health checks of the application's dependencies
+type Registry struct {
+	mu      sync.RWMutex
+	checks  map[string]Checker
+	timeout time.Duration
+}
+
+// NewRegistry creates an empty registry, each check is given the timeout to complete or DefaultTimeout if it is zero
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Registry{checks: make(map[string]Checker), timeout: timeout}
+}
+
+// Register adds a check to the registry, replacing any check registered with the same name
+func (r *Registry) Register(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = c
+}
+
+// Unregister removes a check from the registry
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.checks, name)
+}
+
+// Names returns the names of the registered checks in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Run runs every check concurrently and reports their results
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make(map[string]Checker, len(r.checks))
+	for name, c := range r.checks {
+		checks[name] = c
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, c := range checks {
+		wg.Add(1)
+
+		go func(name string, c Checker) {
+			defer wg.Done()
+
+			res := r.check(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = res
+
+			if res.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(name, c)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+func (r *Registry) check(ctx context.Context, c Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Check(ctx)
+	res := Result{Status: StatusUp, Duration: time.Since(start)}
+
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Handler returns an http handler that runs the checks and writes the report as JSON, responding with
+// 200 OK if every check succeeded or 503 Service Unavailable if any check failed
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Run(req.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if report.Healthy() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+var defaultRegistry = NewRegistry(DefaultTimeout)
+
+// Default returns the registry used by the package level functions
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds a check to the default registry
+func Register(name string, c Checker) {
+	defaultRegistry.Register(name, c)
+}
+
+// Unregister removes a check from the default registry
+func Unregister(name string) {
+	defaultRegistry.Unregister(name)
+}
+
+// Run runs the checks in the default registry
+func Run(ctx context.Context) Report {
+	return defaultRegistry.Run(ctx)
+}
+
+// Handler returns an http handler for the default registry
+func Handler() http.Handler {
+	return defaultRegistry.Handler()
+}
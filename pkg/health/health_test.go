@@ -0,0 +1,70 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/health"
+)
+
+func TestRegistry_Run(t *testing.T) {
+	r := health.NewRegistry(10 * time.Millisecond)
+
+	r.Register("cache", health.CheckFunc(func(context.Context) error { return nil }))
+	r.Register("database", health.CheckFunc(func(context.Context) error { return errors.New("connection refused") }))
+	r.Register("queue", health.CheckFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	report := r.Run(context.Background())
+
+	if report.Healthy() {
+		t.Fatalf("expected the report to be unhealthy")
+	}
+
+	tests := map[string]string{
+		"cache":    health.StatusUp,
+		"database": health.StatusDown,
+		"queue":    health.StatusDown,
+	}
+
+	for name, status := range tests {
+		if report.Checks[name].Status != status {
+			t.Errorf("expected %s to be %s, got %+v", name, status, report.Checks[name])
+		}
+	}
+
+	r.Unregister("database")
+	r.Unregister("queue")
+
+	if report := r.Run(context.Background()); !report.Healthy() {
+		t.Errorf("expected the report to be healthy, got %+v", report)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := health.NewRegistry(0)
+	r.Register("database", health.CheckFunc(func(context.Context) error { return errors.New("connection refused") }))
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	var report health.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("could not decode report - %v", err)
+	}
+
+	if report.Checks["database"].Error != "connection refused" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -1,10 +1,26 @@
 package fs
 
 import (
-	"io/ioutil"
+	ge "errors"
+	"io"
+	iofs "io/fs"
 	"os"
+	"sort"
 )
 
+// FS is a file system in the style of io/fs that also reports symbolic links. Names are slash separated paths relative
+// to the root of the file system and must be valid according to io/fs.ValidPath, so they cannot refer to anything
+// outside of it. OS, NewMemoryFS, Overlay and FromFS create file systems
+type FS interface {
+	iofs.StatFS
+	iofs.ReadDirFS
+
+	// Lstat returns information about the named file without following it if it is a symbolic link
+	Lstat(name string) (iofs.FileInfo, error)
+	// ReadLink returns the path within the file system the named symbolic link resolves to, following any further links
+	ReadLink(name string) (string, error)
+}
+
 // FileExists checks if a file exists at the given path, the function will return an error if the file exists, but is not accessible
 // otherwise it will return a true or false without any errors
 func FileExists(path string) (bool, error) {
@@ -25,39 +41,151 @@ func FileExists(path string) (bool, error) {
 
 // ListFiles gets a list of only the files in a given path
 func ListFiles(path string) ([]string, error) {
-	files, err := ioutil.ReadDir(path)
+	entries, err := os.ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
 
-	results := make([]string, 0, len(files))
+	return names(entries, false), nil
+}
 
-	for _, f := range files {
-		if f.IsDir() {
-			continue
+// ListSubFolders returns a list of subfolders in a given path
+func ListSubFolders(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return names(entries, true), nil
+}
+
+// FileExistsIn checks if a file exists with the given name in the file system, like FileExists it only returns an
+// error if the file may exist but could not be accessed
+func FileExistsIn(fsys iofs.FS, name string) (bool, error) {
+	if _, err := iofs.Stat(fsys, name); err != nil {
+		if ge.Is(err, iofs.ErrNotExist) {
+			return false, nil
 		}
-		results = append(results, f.Name())
+
+		return false, err
 	}
 
-	return results, nil
+	return true, nil
 }
 
-// ListSubFolders returns a list of subfolders in a given path
-func ListSubFolders(path string) ([]string, error) {
-	files, err := ioutil.ReadDir(path)
+// ListFilesIn gets a list of only the files in the named directory of the file system
+func ListFilesIn(fsys iofs.FS, dir string) ([]string, error) {
+	entries, err := iofs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return names(entries, false), nil
+}
+
+// ListSubFoldersIn returns a list of subfolders in the named directory of the file system
+func ListSubFoldersIn(fsys iofs.FS, dir string) ([]string, error) {
+	entries, err := iofs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
 
-	results := make([]string, 0, len(files))
+	return names(entries, true), nil
+}
+
+// names returns the names of the directories, or of the other entries, symbolic links are not followed so they are
+// always listed as files
+func names(entries []iofs.DirEntry, dirs bool) []string {
+	results := make([]string, 0, len(entries))
 
-	for _, f := range files {
-		if !f.IsDir() {
+	for _, e := range entries {
+		if e.IsDir() != dirs {
 			continue
 		}
 
-		results = append(results, f.Name())
+		results = append(results, e.Name())
+	}
+
+	return results
+}
+
+// FromFS adapts an io/fs file system, such as an embed.FS, to FS. It has no symbolic links, so Lstat is the same as
+// Stat and ReadLink always fails
+func FromFS(fsys iofs.FS) FS {
+	if f, ok := fsys.(FS); ok {
+		return f
 	}
 
-	return results, nil
+	return ioFS{fsys: fsys}
+}
+
+type ioFS struct {
+	fsys iofs.FS
+}
+
+func (f ioFS) Open(name string) (iofs.File, error) {
+	return f.fsys.Open(name)
+}
+
+func (f ioFS) Stat(name string) (iofs.FileInfo, error) {
+	return iofs.Stat(f.fsys, name)
+}
+
+func (f ioFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	return iofs.ReadDir(f.fsys, name)
+}
+
+func (f ioFS) Lstat(name string) (iofs.FileInfo, error) {
+	return iofs.Stat(f.fsys, name)
+}
+
+func (f ioFS) ReadLink(name string) (string, error) {
+	return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+}
+
+// dirFile is an open directory of a file system that holds its entries in memory
+type dirFile struct {
+	info    iofs.FileInfo
+	entries []iofs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (iofs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.info.Name(), Err: iofs.ErrInvalid}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (d *dirFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	d.offset += n
+
+	return remaining[:n], nil
+}
+
+// sortEntries sorts directory entries by name, as io/fs.ReadDir does
+func sortEntries(entries []iofs.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
 }
@@ -1,7 +1,14 @@
 package fs_test
 
 import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/birchwood-langham/bootstrap/pkg/io/fs"
 )
@@ -57,3 +64,138 @@ func Test_FileExists(t *testing.T) {
 		t.Errorf("fs.go does not exist")
 	}
 }
+
+func newMemoryFS(t *testing.T) *fs.MemoryFS {
+	m := fs.NewMemoryFS()
+
+	files := map[string]string{
+		"configuration.yaml":      "version: 1",
+		"config/app.yaml":         "service: orders",
+		"config/db.yaml":          "driver: postgres",
+		"config/local/app.yaml":   "service: orders-local",
+		"config/testdata/bad.yml": "version:",
+		"migrations/1_init.sql":   "CREATE TABLE orders (id INTEGER)",
+	}
+
+	for name, data := range files {
+		if err := m.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("could not write %s - %v", name, err)
+		}
+	}
+
+	if err := m.MkdirAll("logs", 0755); err != nil {
+		t.Fatalf("could not create directory - %v", err)
+	}
+
+	return m
+}
+
+func Test_ListIn(t *testing.T) {
+	m := newMemoryFS(t)
+
+	if err := m.Symlink("config", "current"); err != nil {
+		t.Fatalf("could not create link - %v", err)
+	}
+
+	files, err := fs.ListFilesIn(m, ".")
+	if err != nil {
+		t.Fatalf("could not get a list of files - %v", err)
+	}
+
+	// links are not followed, so a link to a directory is listed as a file, as ListFiles does
+	if want := []string{"configuration.yaml", "current"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("expected files %v, got %v", want, files)
+	}
+
+	folders, err := fs.ListSubFoldersIn(m, ".")
+	if err != nil {
+		t.Fatalf("could not get a list of folders - %v", err)
+	}
+
+	if want := []string{"config", "logs", "migrations"}; !reflect.DeepEqual(folders, want) {
+		t.Errorf("expected folders %v, got %v", want, folders)
+	}
+
+	if files, _ := fs.ListFilesIn(m, "current"); !reflect.DeepEqual(files, []string{"app.yaml", "db.yaml"}) {
+		t.Errorf("expected the link to be followed when it is listed, got %v", files)
+	}
+
+	for name, want := range map[string]bool{"config/app.yaml": true, "current/db.yaml": true, "config/missing.yaml": false} {
+		if exists, err := fs.FileExistsIn(m, name); exists != want || err != nil {
+			t.Errorf("expected %s to exist to be %v, got %v - %v", name, want, exists, err)
+		}
+	}
+
+	if _, err := fs.FileExistsIn(m, "../etc/passwd"); !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("expected names outside the file system to be invalid, got %v", err)
+	}
+}
+
+func TestMemoryFS(t *testing.T) {
+	m := newMemoryFS(t)
+
+	if err := m.Symlink("../config/app.yaml", "migrations/app.yaml"); err != nil {
+		t.Fatalf("could not create link - %v", err)
+	}
+
+	if err := fstest.TestFS(m, "configuration.yaml", "config/local/app.yaml", "migrations/app.yaml", "logs"); err != nil {
+		t.Errorf("memory file system does not behave as an io/fs file system - %v", err)
+	}
+
+	if data, _ := iofs.ReadFile(m, "migrations/app.yaml"); string(data) != "service: orders" {
+		t.Errorf("expected reading the link to read the file it points to, got %s", data)
+	}
+
+	if target, err := m.ReadLink("migrations/app.yaml"); target != "config/app.yaml" || err != nil {
+		t.Errorf("expected the link to resolve to config/app.yaml, got %s - %v", target, err)
+	}
+
+	_ = m.Symlink("loop-b", "loop-a")
+	_ = m.Symlink("loop-a", "loop-b")
+
+	if _, err := m.Stat("loop-a"); err == nil {
+		t.Errorf("expected a link cycle to fail")
+	}
+
+	if err := m.RemoveAll("config"); err != nil {
+		t.Fatalf("could not remove directory - %v", err)
+	}
+
+	if exists, _ := fs.FileExistsIn(m, "config/local/app.yaml"); exists {
+		t.Errorf("expected everything in the directory to be removed")
+	}
+}
+
+func TestOS(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+
+	_ = os.MkdirAll(filepath.Join(root, "config"), 0755)
+	_ = os.WriteFile(filepath.Join(root, "config", "app.yaml"), []byte("service: orders"), 0644)
+	_ = os.WriteFile(filepath.Join(outside, "secret"), []byte("password"), 0644)
+
+	if err := os.Symlink(filepath.Join(root, "config"), filepath.Join(root, "current")); err != nil {
+		t.Skipf("symbolic links are not supported - %v", err)
+	}
+
+	fsys := fs.OS(root)
+
+	if err := fstest.TestFS(fsys, "config/app.yaml", "current"); err != nil {
+		t.Errorf("os file system does not behave as an io/fs file system - %v", err)
+	}
+
+	_ = os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "secret"))
+
+	if target, err := fsys.ReadLink("current"); target != "config" || err != nil {
+		t.Errorf("expected the link to resolve to config, got %s - %v", target, err)
+	}
+
+	_, err := iofs.ReadFile(fsys, "secret")
+
+	if !errors.Is(err, iofs.ErrPermission) || strings.Contains(err.Error(), outside) {
+		t.Errorf("expected links outside of the root to be denied without revealing the path, got %v", err)
+	}
+
+	if info, err := fsys.Lstat("secret"); err != nil || info.Mode()&iofs.ModeSymlink == 0 {
+		t.Errorf("expected the link itself to be visible - %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package fs
+
+import (
+	"bytes"
+	iofs "io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLinks is the number of symbolic links followed while resolving a name before giving up, so link cycles fail
+const maxLinks = 40
+
+// MemoryFS is a file system held in memory, for tests and for files generated at runtime. It is safe for concurrent use
+type MemoryFS struct {
+	mu    sync.RWMutex
+	nodes map[string]*node
+}
+
+// node is a file, directory or symbolic link in a MemoryFS
+type node struct {
+	data    []byte
+	mode    iofs.FileMode
+	modTime time.Time
+	target  string
+}
+
+var _ FS = (*MemoryFS)(nil)
+
+// NewMemoryFS creates an empty file system
+func NewMemoryFS() *MemoryFS {
+	return &MemoryFS{
+		nodes: map[string]*node{".": {mode: iofs.ModeDir | 0755, modTime: time.Now()}},
+	}
+}
+
+// WriteFile writes data to the named file, creating it and any missing parent directories if needed
+func (m *MemoryFS) WriteFile(name string, data []byte, perm iofs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.create("writefile", name)
+	if err != nil {
+		return err
+	}
+
+	if n, ok := m.nodes[p]; ok && n.mode.IsDir() {
+		return &iofs.PathError{Op: "writefile", Path: name, Err: iofs.ErrExist}
+	}
+
+	m.nodes[p] = &node{data: append([]byte(nil), data...), mode: perm.Perm(), modTime: time.Now()}
+
+	return nil
+}
+
+// MkdirAll creates the named directory and any missing parent directories
+func (m *MemoryFS) MkdirAll(name string, perm iofs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !iofs.ValidPath(name) {
+		return &iofs.PathError{Op: "mkdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	_, err := m.mkdirAll(name, perm)
+
+	return err
+}
+
+// Symlink creates a symbolic link with the given name pointing to target. Like a link on the disk, a relative target
+// is resolved from the directory holding the link, a target starting with a slash is resolved from the root of the
+// file system. Targets cannot resolve to anything outside of the file system
+func (m *MemoryFS) Symlink(target, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.create("symlink", name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m.nodes[p]; ok {
+		return &iofs.PathError{Op: "symlink", Path: name, Err: iofs.ErrExist}
+	}
+
+	m.nodes[p] = &node{mode: iofs.ModeSymlink | 0777, modTime: time.Now(), target: target}
+
+	return nil
+}
+
+// RemoveAll removes the named file, or directory and everything in it, symbolic links are removed rather than followed
+func (m *MemoryFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if name == "." {
+		return &iofs.PathError{Op: "remove", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	p, _, err := m.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+
+	for k := range m.nodes {
+		if k == p || strings.HasPrefix(k, p+"/") {
+			delete(m.nodes, k)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryFS) Open(name string) (iofs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, n, err := m.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	info := fileInfo{name: baseName(name), node: n}
+
+	if n.mode.IsDir() {
+		return &dirFile{info: info, entries: m.entries(p)}, nil
+	}
+
+	return &memFile{Reader: bytes.NewReader(n.data), info: info}, nil
+}
+
+func (m *MemoryFS) Stat(name string) (iofs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, n, err := m.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{name: baseName(name), node: n}, nil
+}
+
+func (m *MemoryFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, n, err := m.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.mode.IsDir() {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	return m.entries(p), nil
+}
+
+func (m *MemoryFS) Lstat(name string) (iofs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, n, err := m.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{name: baseName(name), node: n}, nil
+}
+
+func (m *MemoryFS) ReadLink(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, n, err := m.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	if n.mode&iofs.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	p, _, err := m.resolve("readlink", name, true)
+
+	return p, err
+}
+
+// resolve returns the path and node of the named file, following symbolic links in its parent directories and, if
+// follow is set, the file itself
+func (m *MemoryFS) resolve(op, name string, follow bool) (string, *node, error) {
+	if !iofs.ValidPath(name) {
+		return "", nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	p := name
+
+	for links := 0; ; {
+		resolved, n, rest, err := m.walk(p, follow)
+		if err != nil {
+			return "", nil, &iofs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		if n.mode&iofs.ModeSymlink == 0 || (rest == "" && !follow) {
+			return resolved, n, nil
+		}
+
+		if links++; links > maxLinks {
+			return "", nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+		}
+
+		p = join(linkTarget(resolved, n.target), rest)
+	}
+}
+
+// walk follows the path until it reaches its end or a symbolic link that has to be followed, returning the node that
+// was reached and the rest of the path after it
+func (m *MemoryFS) walk(p string, follow bool) (string, *node, string, error) {
+	current := "."
+	n := m.nodes[current]
+
+	if p == "." {
+		return current, n, "", nil
+	}
+
+	parts := strings.Split(p, "/")
+
+	for i, part := range parts {
+		if !n.mode.IsDir() {
+			return "", nil, "", iofs.ErrNotExist
+		}
+
+		current = join(current, part)
+
+		next, ok := m.nodes[current]
+		if !ok {
+			return "", nil, "", iofs.ErrNotExist
+		}
+
+		n = next
+		rest := strings.Join(parts[i+1:], "/")
+
+		if n.mode&iofs.ModeSymlink != 0 && (rest != "" || follow) {
+			return current, n, rest, nil
+		}
+	}
+
+	return current, n, "", nil
+}
+
+// create resolves the parent directory of a file that is about to be created, creating any missing directories, and
+// returns the path of the file
+func (m *MemoryFS) create(op, name string) (string, error) {
+	if !iofs.ValidPath(name) || name == "." {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	dir, err := m.mkdirAll(path.Dir(name), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	p := join(dir, path.Base(name))
+
+	// writing to an existing link writes to the file it points to
+	if n, ok := m.nodes[p]; ok && n.mode&iofs.ModeSymlink != 0 && op == "writefile" {
+		target, _, err := m.resolve(op, p, true)
+		if err != nil {
+			return "", err
+		}
+
+		return target, nil
+	}
+
+	return p, nil
+}
+
+// mkdirAll creates the directories of the path that are missing and returns the resolved path of the directory
+func (m *MemoryFS) mkdirAll(name string, perm iofs.FileMode) (string, error) {
+	if name == "." {
+		return ".", nil
+	}
+
+	parent, err := m.mkdirAll(path.Dir(name), perm)
+	if err != nil {
+		return "", err
+	}
+
+	p := join(parent, path.Base(name))
+
+	n, ok := m.nodes[p]
+	if !ok {
+		m.nodes[p] = &node{mode: iofs.ModeDir | perm.Perm(), modTime: time.Now()}
+		return p, nil
+	}
+
+	if n.mode&iofs.ModeSymlink != 0 {
+		target, n, err := m.resolve("mkdir", p, true)
+		if err == nil && n.mode.IsDir() {
+			return target, nil
+		}
+	}
+
+	if !n.mode.IsDir() {
+		return "", &iofs.PathError{Op: "mkdir", Path: name, Err: iofs.ErrExist}
+	}
+
+	return p, nil
+}
+
+// entries returns the sorted entries of the directory at the resolved path
+func (m *MemoryFS) entries(dir string) []iofs.DirEntry {
+	entries := make([]iofs.DirEntry, 0)
+
+	for p, n := range m.nodes {
+		if p != "." && path.Dir(p) == dir {
+			entries = append(entries, iofs.FileInfoToDirEntry(fileInfo{name: path.Base(p), node: n}))
+		}
+	}
+
+	sortEntries(entries)
+
+	return entries
+}
+
+// linkTarget returns the path a link at the given path points to, clamped to the root of the file system
+func linkTarget(link, target string) string {
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join("/", path.Dir(link), target)
+	}
+
+	if p := strings.TrimPrefix(path.Clean(target), "/"); p != "" {
+		return p
+	}
+
+	return "."
+}
+
+// join joins file system paths, treating "." as the root
+func join(dir, name string) string {
+	switch {
+	case dir == ".":
+		return name
+	case name == "":
+		return dir
+	}
+
+	return dir + "/" + name
+}
+
+// fileInfo describes a node of a MemoryFS
+type fileInfo struct {
+	name string
+	node *node
+}
+
+func (i fileInfo) Name() string {
+	return i.name
+}
+
+func (i fileInfo) Size() int64 {
+	return int64(len(i.node.data))
+}
+
+func (i fileInfo) Mode() iofs.FileMode {
+	return i.node.mode
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return i.node.modTime
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.node.mode.IsDir()
+}
+
+func (i fileInfo) Sys() interface{} {
+	return nil
+}
+
+// memFile is an open file of a MemoryFS
+type memFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *memFile) Stat() (iofs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
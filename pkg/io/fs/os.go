@@ -0,0 +1,185 @@
+package fs
+
+import (
+	ge "errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OS returns the directory tree rooted at dir as a file system. Unlike os.DirFS, symbolic links are only followed if
+// they resolve to a path inside the directory, so the file system cannot be used to reach anything outside of it.
+// Errors report names within the file system rather than paths on the disk
+func OS(dir string) FS {
+	return osFS{root: dir}
+}
+
+type osFS struct {
+	root string
+}
+
+func (f osFS) Open(name string) (iofs.File, error) {
+	p, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return osFile{File: file, name: baseName(name)}, nil
+}
+
+func (f osFS) Stat(name string) (iofs.FileInfo, error) {
+	p, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return namedInfo{FileInfo: info, name: baseName(name)}, nil
+}
+
+func (f osFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	p, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	return entries, nil
+}
+
+func (f osFS) Lstat(name string) (iofs.FileInfo, error) {
+	p, err := f.resolveParent("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, pathError("lstat", name, err)
+	}
+
+	return namedInfo{FileInfo: info, name: baseName(name)}, nil
+}
+
+func (f osFS) ReadLink(name string) (string, error) {
+	info, err := f.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&iofs.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	p, err := f.resolve("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	root, _ := filepath.EvalSymlinks(f.root)
+	rel, _ := filepath.Rel(root, p)
+
+	return filepath.ToSlash(rel), nil
+}
+
+// resolve returns the path on the disk of the named file with every symbolic link resolved, it fails if the path is
+// outside of the root directory
+func (f osFS) resolve(op, name string) (string, error) {
+	if !iofs.ValidPath(name) {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		return "", pathError(op, name, err)
+	}
+
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", pathError(op, name, err)
+	}
+
+	if !within(root, p) {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrPermission}
+	}
+
+	return p, nil
+}
+
+// resolveParent is like resolve, but leaves the last element of the name as it is
+func (f osFS) resolveParent(op, name string) (string, error) {
+	if name == "." {
+		return f.resolve(op, name)
+	}
+
+	dir, base := filepath.Split(filepath.FromSlash(name))
+
+	parent, err := f.resolve(op, filepath.ToSlash(filepath.Clean(dir)))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(parent, base), nil
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// pathError reports the error of an operation on the disk against the name within the file system
+func pathError(op, name string, err error) error {
+	var pe *iofs.PathError
+	if ge.As(err, &pe) {
+		err = pe.Err
+	}
+
+	return &iofs.PathError{Op: op, Path: name, Err: err}
+}
+
+// osFile is an open file that reports the name it was opened with
+type osFile struct {
+	*os.File
+	name string
+}
+
+func (f osFile) Stat() (iofs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return namedInfo{FileInfo: info, name: f.name}, nil
+}
+
+// namedInfo reports the name a file was looked up with, rather than the name of the file a symbolic link resolved to
+type namedInfo struct {
+	iofs.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
+
+func baseName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+
+	return name
+}
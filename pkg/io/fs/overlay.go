@@ -0,0 +1,164 @@
+package fs
+
+import (
+	ge "errors"
+	iofs "io/fs"
+	"path"
+)
+
+// Overlay combines file systems into a single read only file system, e.g. configuration files in a directory over
+// the defaults embedded in the binary. A file in an earlier layer hides the file with the same name in later layers,
+// the entries of a directory are merged from every layer that has the directory, until a layer has a file in its place
+func Overlay(layers ...FS) FS {
+	return overlayFS{layers: layers}
+}
+
+type overlayFS struct {
+	layers []FS
+}
+
+func (o overlayFS) Open(name string) (iofs.File, error) {
+	info, err := o.Stat(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	if info.IsDir() {
+		entries, err := o.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &dirFile{info: info, entries: entries}, nil
+	}
+
+	var f iofs.File
+
+	err = o.first("open", name, func(layer FS) (err error) {
+		f, err = layer.Open(name)
+		return err
+	})
+
+	return f, err
+}
+
+func (o overlayFS) Stat(name string) (iofs.FileInfo, error) {
+	var info iofs.FileInfo
+
+	err := o.first("stat", name, func(layer FS) (err error) {
+		info, err = layer.Stat(name)
+		return err
+	})
+
+	return info, err
+}
+
+func (o overlayFS) Lstat(name string) (iofs.FileInfo, error) {
+	var info iofs.FileInfo
+
+	err := o.first("lstat", name, func(layer FS) (err error) {
+		info, err = layer.Lstat(name)
+		return err
+	})
+
+	return info, err
+}
+
+func (o overlayFS) ReadLink(name string) (string, error) {
+	var target string
+
+	err := o.first("readlink", name, func(layer FS) (err error) {
+		target, err = layer.ReadLink(name)
+		return err
+	})
+
+	return target, err
+}
+
+func (o overlayFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	seen := make(map[string]bool)
+	entries := make([]iofs.DirEntry, 0)
+	found := false
+
+	for _, layer := range o.layers {
+		info, err := layer.Stat(name)
+		if ge.Is(err, iofs.ErrNotExist) {
+			if hidden(layer, name) {
+				break
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// a file hides the directories in the layers below it
+		if !info.IsDir() {
+			if !found {
+				return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+			}
+
+			break
+		}
+
+		found = true
+
+		layerEntries, err := layer.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range layerEntries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrNotExist}
+	}
+
+	sortEntries(entries)
+
+	return entries, nil
+}
+
+// first calls fn for each layer until it finds the named file, returning the first error other than the file not
+// existing
+func (o overlayFS) first(op, name string, fn func(layer FS) error) error {
+	if !iofs.ValidPath(name) {
+		return &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	for _, layer := range o.layers {
+		if err := fn(layer); !ge.Is(err, iofs.ErrNotExist) {
+			return err
+		}
+
+		if hidden(layer, name) {
+			break
+		}
+	}
+
+	return &iofs.PathError{Op: op, Path: name, Err: iofs.ErrNotExist}
+}
+
+// hidden reports whether the layer has a file in place of one of the parent directories of the name, which hides the
+// name in the layers below it
+func hidden(layer FS, name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if info, err := layer.Stat(dir); err == nil {
+			return !info.IsDir()
+		}
+	}
+
+	return false
+}
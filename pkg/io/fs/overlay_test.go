@@ -0,0 +1,53 @@
+package fs_test
+
+import (
+	"errors"
+	iofs "io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/birchwood-langham/bootstrap/pkg/io/fs"
+)
+
+func TestOverlay(t *testing.T) {
+	local := fs.NewMemoryFS()
+	_ = local.WriteFile("config/app.yaml", []byte("service: orders-local"), 0644)
+	_ = local.WriteFile("config/local.yaml", []byte("debug: true"), 0644)
+	_ = local.WriteFile("migrations", []byte("not a directory"), 0644)
+
+	defaults := fs.FromFS(fstest.MapFS{
+		"config/app.yaml":       {Data: []byte("service: orders")},
+		"config/db.yaml":        {Data: []byte("driver: postgres")},
+		"migrations/1_init.sql": {Data: []byte("CREATE TABLE orders (id INTEGER)")},
+	})
+
+	o := fs.Overlay(local, defaults)
+
+	if err := fstest.TestFS(o, "config/app.yaml", "config/db.yaml", "config/local.yaml", "migrations"); err != nil {
+		t.Errorf("overlay does not behave as an io/fs file system - %v", err)
+	}
+
+	if data, _ := iofs.ReadFile(o, "config/app.yaml"); string(data) != "service: orders-local" {
+		t.Errorf("expected the first layer to hide later layers, got %s", data)
+	}
+
+	files, err := fs.ListFilesIn(o, "config")
+	if want := []string{"app.yaml", "db.yaml", "local.yaml"}; err != nil || !reflect.DeepEqual(files, want) {
+		t.Errorf("expected the directories to be merged into %v, got %v - %v", want, files, err)
+	}
+
+	if exists, _ := fs.FileExistsIn(o, "migrations/1_init.sql"); exists {
+		t.Errorf("expected the file in the first layer to hide the directory in later layers")
+	}
+
+	if _, ok := o.(interface {
+		WriteFile(string, []byte, iofs.FileMode) error
+	}); ok {
+		t.Errorf("expected the overlay to be read only")
+	}
+
+	if _, err := o.Open("../config/app.yaml"); !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("expected names outside the file system to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package fs
+
+import (
+	ge "errors"
+	iofs "io/fs"
+	"path"
+	"strings"
+)
+
+// SymlinkMode controls what Walk does with symbolic links
+type SymlinkMode int
+
+const (
+	// ReportSymlinks passes symbolic links to the walk function without following them, this is the default
+	ReportSymlinks SymlinkMode = iota
+	// SkipSymlinks leaves symbolic links out of the walk
+	SkipSymlinks
+	// FollowSymlinks walks the files and directories symbolic links point to as if they were in place of the links,
+	// a link to one of the directories being walked is skipped so cycles do not walk forever
+	FollowSymlinks
+)
+
+type walker struct {
+	fsys     FS
+	root     string
+	include  []string
+	exclude  []string
+	symlinks SymlinkMode
+	fn       iofs.WalkDirFunc
+}
+
+// WalkOption sets an optional setting on a walk
+type WalkOption func(*walker)
+
+// WithInclude only reports the files that match one of the patterns, directories are still walked and reported so
+// files deeper in the tree can match. See Glob for the pattern syntax
+func WithInclude(patterns ...string) WalkOption {
+	return func(w *walker) {
+		w.include = append(w.include, patterns...)
+	}
+}
+
+// WithExclude leaves out the files and directories that match one of the patterns, nothing inside an excluded
+// directory is walked. See Glob for the pattern syntax
+func WithExclude(patterns ...string) WalkOption {
+	return func(w *walker) {
+		w.exclude = append(w.exclude, patterns...)
+	}
+}
+
+// WithSymlinks sets what the walk does with symbolic links
+func WithSymlinks(mode SymlinkMode) WalkOption {
+	return func(w *walker) {
+		w.symlinks = mode
+	}
+}
+
+// Walk walks the file tree rooted at root in lexical order, calling fn for each file and directory as io/fs.WalkDir
+// does, including returning io/fs.SkipDir from fn to skip a directory. Include and exclude patterns are matched
+// against the path relative to root, e.g.
+//
+//	fs.Walk(fs.OS("."), "config", fn, fs.WithInclude("*.yaml"), fs.WithExclude("testdata"))
+func Walk(fsys FS, root string, fn iofs.WalkDirFunc, opts ...WalkOption) error {
+	w := &walker{fsys: fsys, root: root, fn: fn}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	for _, pattern := range append(append([]string{}, w.include...), w.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	info, err := fsys.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, iofs.FileInfoToDirEntry(info), w.realPath(root), map[string]bool{})
+	}
+
+	if ge.Is(err, iofs.SkipDir) {
+		return nil
+	}
+
+	return err
+}
+
+func (w *walker) walk(name string, d iofs.DirEntry, real string, ancestors map[string]bool) error {
+	if err := w.fn(name, d, nil); err != nil || !d.IsDir() {
+		return err
+	}
+
+	entries, err := w.fsys.ReadDir(name)
+	if err != nil {
+		// give the walk function a chance to skip the directory or carry on, as io/fs.WalkDir does
+		if err = w.fn(name, d, err); err != nil {
+			return err
+		}
+	}
+
+	ancestors[real] = true
+	defer delete(ancestors, real)
+
+	for _, e := range entries {
+		p, entryReal := path.Join(name, e.Name()), path.Join(real, e.Name())
+		rel := w.relative(p)
+
+		if matchAny(w.exclude, rel) {
+			continue
+		}
+
+		if e.Type()&iofs.ModeSymlink != 0 {
+			switch w.symlinks {
+			case SkipSymlinks:
+				continue
+			case FollowSymlinks:
+				target, info, err := w.follow(p)
+				if err != nil {
+					if err = w.fn(p, e, err); err != nil && !ge.Is(err, iofs.SkipDir) {
+						return err
+					}
+
+					continue
+				}
+
+				if info.IsDir() && ancestors[target] {
+					continue
+				}
+
+				e, entryReal = iofs.FileInfoToDirEntry(info), target
+			}
+		}
+
+		if !e.IsDir() && len(w.include) > 0 && !matchAny(w.include, rel) {
+			continue
+		}
+
+		if err := w.walk(p, e, entryReal, ancestors); err != nil {
+			if !ge.Is(err, iofs.SkipDir) {
+				return err
+			}
+
+			// skipping from a file skips the rest of its directory
+			if !e.IsDir() {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// follow returns the path a symbolic link resolves to and information about the file it points to
+func (w *walker) follow(name string) (string, iofs.FileInfo, error) {
+	target, err := w.fsys.ReadLink(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	info, err := w.fsys.Stat(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return target, info, nil
+}
+
+// realPath returns the path of the root of the walk with any symbolic link resolved, so links back to it are found
+func (w *walker) realPath(name string) string {
+	if target, err := w.fsys.ReadLink(name); err == nil {
+		return target
+	}
+
+	return name
+}
+
+func (w *walker) relative(name string) string {
+	if w.root == "." {
+		return name
+	}
+
+	return strings.TrimPrefix(name, w.root+"/")
+}
+
+// Glob returns the names of the files and directories that match the pattern, which uses the syntax of path.Match
+// with the addition of ** to match any number of directories, e.g. config/**/*.yaml. A pattern without a slash
+// matches the base name of files anywhere in the tree. Symbolic links are not followed
+func Glob(fsys FS, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	root := staticPrefix(pattern)
+
+	if exists, err := FileExistsIn(fsys, root); err != nil || !exists {
+		return nil, err
+	}
+
+	matches := make([]string, 0)
+
+	err := Walk(fsys, root, func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name != "." && match(pattern, name) {
+			matches = append(matches, name)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// staticPrefix returns the leading directories of a pattern that contain no wildcards, which is where a walk for the
+// pattern can start
+func staticPrefix(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	static := make([]string, 0, len(parts))
+
+	for _, part := range parts[:len(parts)-1] {
+		if strings.ContainsAny(part, `*?[\`) {
+			break
+		}
+
+		static = append(static, part)
+	}
+
+	if len(static) == 0 {
+		return "."
+	}
+
+	return strings.Join(static, "/")
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if match(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether the slash separated name matches the pattern, a pattern without a slash matches the base name
+func match(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
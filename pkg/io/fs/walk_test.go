@@ -0,0 +1,127 @@
+package fs_test
+
+import (
+	iofs "io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/birchwood-langham/bootstrap/pkg/io/fs"
+)
+
+func TestWalk(t *testing.T) {
+	m := newMemoryFS(t)
+
+	_ = m.Symlink("../migrations", "config/migrations")
+	_ = m.Symlink("..", "config/local/parent")
+	_ = m.Symlink("missing.yaml", "config/broken.yaml")
+
+	tests := []struct {
+		name string
+		root string
+		opts []fs.WalkOption
+		want []string
+	}{
+		{
+			name: "reports links",
+			root: "config",
+			want: []string{"config", "config/app.yaml", "config/broken.yaml", "config/db.yaml", "config/local",
+				"config/local/app.yaml", "config/local/parent", "config/migrations", "config/testdata", "config/testdata/bad.yml"},
+		},
+		{
+			name: "include and exclude",
+			root: "config",
+			opts: []fs.WalkOption{fs.WithInclude("*.yaml"), fs.WithExclude("testdata", "local/*.yaml"), fs.WithSymlinks(fs.SkipSymlinks)},
+			want: []string{"config", "config/app.yaml", "config/db.yaml", "config/local"},
+		},
+		{
+			name: "recursive include",
+			root: ".",
+			opts: []fs.WalkOption{fs.WithInclude("config/**/app.yaml"), fs.WithExclude("migrations")},
+			want: []string{".", "config", "config/app.yaml", "config/local", "config/local/app.yaml", "config/testdata", "logs"},
+		},
+		{
+			name: "follows links without cycles",
+			root: "config",
+			opts: []fs.WalkOption{fs.WithSymlinks(fs.FollowSymlinks), fs.WithExclude("testdata", "broken.yaml")},
+			want: []string{"config", "config/app.yaml", "config/db.yaml", "config/local", "config/local/app.yaml",
+				"config/migrations", "config/migrations/1_init.sql"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			walked := make([]string, 0)
+
+			err := fs.Walk(m, test.root, func(name string, d iofs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				walked = append(walked, name)
+				return nil
+			}, test.opts...)
+
+			if err != nil {
+				t.Fatalf("could not walk the file system - %v", err)
+			}
+
+			if !reflect.DeepEqual(walked, test.want) {
+				t.Errorf("expected to walk %v, got %v", test.want, walked)
+			}
+		})
+	}
+}
+
+func TestWalk_Errors(t *testing.T) {
+	m := newMemoryFS(t)
+	_ = m.Symlink("missing.yaml", "config/broken.yaml")
+
+	var broken string
+
+	err := fs.Walk(m, "config", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			broken = name
+			return nil
+		}
+
+		if name == "config/local" {
+			return iofs.SkipDir
+		}
+
+		return nil
+	}, fs.WithSymlinks(fs.FollowSymlinks))
+
+	if err != nil || broken != "config/broken.yaml" {
+		t.Errorf("expected the broken link to be reported to the walk function, got %s - %v", broken, err)
+	}
+
+	if err := fs.Walk(m, ".", func(string, iofs.DirEntry, error) error { return nil }, fs.WithInclude("[")); err == nil {
+		t.Errorf("expected a bad pattern to fail")
+	}
+}
+
+func TestGlob(t *testing.T) {
+	m := newMemoryFS(t)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "config/*.yaml", want: []string{"config/app.yaml", "config/db.yaml"}},
+		{pattern: "config/**/*.y*ml", want: []string{"config/app.yaml", "config/db.yaml", "config/local/app.yaml", "config/testdata/bad.yml"}},
+		{pattern: "app.yaml", want: []string{"config/app.yaml", "config/local/app.yaml"}},
+		{pattern: "**/local", want: []string{"config/local"}},
+		{pattern: "missing/*.yaml", want: nil},
+	}
+
+	for _, test := range tests {
+		matches, err := fs.Glob(m, test.pattern)
+		if err != nil {
+			t.Fatalf("could not match %s - %v", test.pattern, err)
+		}
+
+		if len(matches) != len(test.want) || (len(matches) > 0 && !reflect.DeepEqual(matches, test.want)) {
+			t.Errorf("expected %s to match %v, got %v", test.pattern, test.want, matches)
+		}
+	}
+}
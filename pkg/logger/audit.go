@@ -0,0 +1,373 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+)
+
+const (
+	auditHeadExt     = ".head"
+	auditTimeFormat  = time.RFC3339Nano
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+var auditOnce sync.Once
+var auditLog *AuditLogger
+
+// AuditLogTamperedError is returned when verifying an audit log that has been modified or truncated
+var AuditLogTamperedError = errors.New("audit log has been tampered with")
+
+// AuditEntry is a single line in the audit log. Each entry contains the hash of the entry before it
+// so any modification, insertion or removal of entries breaks the chain
+type AuditEntry struct {
+	Sequence uint64          `json:"seq"`
+	Time     string          `json:"time"`
+	Event    string          `json:"event"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Previous string          `json:"prev"`
+	Hash     string          `json:"hash"`
+}
+
+func (e AuditEntry) computeHash(key []byte) string {
+	h := sha256.New()
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	}
+
+	_, _ = fmt.Fprintf(h, "%d\n%s\n%s\n%s\n", e.Sequence, e.Time, e.Event, e.Previous)
+	_, _ = h.Write(e.Data)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditLogger writes a trail of events as append-only JSON lines to a dedicated file.
+// The sequence number and hash of the latest entry are also written to a head file alongside the log
+// so entries removed from the end of the log can be detected.
+// Without a key the chain is a plain SHA-256 chain, which detects accidental modification but can be
+// recomputed by anyone able to write the file. Set a key with WithAuditKey to make the log tamper-evident,
+// each hash is then an HMAC which cannot be forged without the key.
+// A nil AuditLogger is valid and discards every entry, so callers do not need to check whether auditing is enabled.
+type AuditLogger struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+	file *os.File
+	seq  uint64
+	last string
+	now  func() time.Time
+}
+
+// AuditOption sets an optional setting when opening or verifying an audit log
+type AuditOption func(*auditOptions)
+
+type auditOptions struct {
+	key []byte
+}
+
+// WithAuditKey sets the secret key used to compute the hash of every entry. A log written with a key
+// can only be verified with the same key
+func WithAuditKey(key []byte) AuditOption {
+	return func(o *auditOptions) {
+		o.key = append([]byte(nil), key...)
+	}
+}
+
+func newAuditOptions(opts []AuditOption) auditOptions {
+	var o auditOptions
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// OpenAuditLog opens the audit log at the given path for appending, creating it if it does not exist.
+// The existing entries are verified before the log is opened so new entries are never chained to a log
+// that has already been tampered with
+func OpenAuditLog(path string, opts ...AuditOption) (*AuditLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create audit log directory: %w", err)
+	}
+
+	a := &AuditLogger{
+		path: path,
+		key:  newAuditOptions(opts).key,
+		last: auditGenesisHash,
+	}
+
+	if auditFileExists(path) || auditFileExists(auditHeadPath(path)) {
+		head, err := VerifyAuditLog(path, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		a.seq = head.Sequence
+		a.last = head.Hash
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+
+	a.file = f
+
+	return a, nil
+}
+
+// Audit returns the audit logger configured by the audit.filepath setting in the application configuration file,
+// entries are keyed with the audit.key setting when it is set.
+// If auditing has not been configured, or the audit log could not be opened, a nil AuditLogger is returned which
+// discards all entries
+func Audit() *AuditLogger {
+	auditOnce.Do(func() {
+		path := viper.GetString(config.AuditFilePathKey)
+
+		if path == "" {
+			return
+		}
+
+		var opts []AuditOption
+		if key := viper.GetString(config.AuditKeyKey); key != "" {
+			opts = append(opts, WithAuditKey([]byte(key)))
+		}
+
+		a, err := OpenAuditLog(path, opts...)
+		if err != nil {
+			Logger().Error("could not open audit log", zap.String("file-path", path), zap.Error(err))
+			return
+		}
+
+		auditLog = a
+	})
+
+	return auditLog
+}
+
+// Record appends an entry for the event with the given data to the audit log.
+// The data must be serializable to JSON
+func (a *AuditLogger) Record(event string, data map[string]interface{}) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return errors.New("audit log has been closed")
+	}
+
+	entry := AuditEntry{
+		Sequence: a.seq + 1,
+		Time:     a.currentTime().Format(auditTimeFormat),
+		Event:    event,
+		Previous: a.last,
+	}
+
+	if len(data) > 0 {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("could not serialize audit data: %w", err)
+		}
+
+		entry.Data = raw
+	}
+
+	entry.Hash = entry.computeHash(a.key)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("could not serialize audit entry: %w", err)
+	}
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write audit entry: %w", err)
+	}
+
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync audit log: %w", err)
+	}
+
+	a.seq = entry.Sequence
+	a.last = entry.Hash
+
+	return writeAuditHead(a.path, entry)
+}
+
+// Close closes the audit log, any further entries will be rejected
+func (a *AuditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	err := a.file.Close()
+	a.file = nil
+
+	return err
+}
+
+func (a *AuditLogger) currentTime() time.Time {
+	if a.now != nil {
+		return a.now().UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+// VerifyAuditLog reads the audit log at the given path and checks the hash chain of every entry.
+// The last entry must match the head file alongside the log, so entries removed from the end of the
+// log are detected, a log with entries but no head file has been tampered with.
+// The last entry in the log is returned when the log is intact
+func VerifyAuditLog(path string, opts ...AuditOption) (AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	defer f.Close()
+
+	last, err := VerifyAudit(f, opts...)
+	if err != nil {
+		return last, err
+	}
+
+	head, err := readAuditHead(path)
+	if err != nil {
+		return last, err
+	}
+
+	if head == nil {
+		if last.Sequence > 0 {
+			return last, fmt.Errorf("%w: log has %d entries but the head file is missing", AuditLogTamperedError, last.Sequence)
+		}
+
+		return last, nil
+	}
+
+	if head.Sequence != last.Sequence || head.Hash != last.Hash {
+		return last, fmt.Errorf("%w: log ends at entry %d but head records entry %d", AuditLogTamperedError, last.Sequence, head.Sequence)
+	}
+
+	return last, nil
+}
+
+// VerifyAudit reads audit entries from the reader and checks the hash chain of every entry,
+// returning the last entry read when the chain is intact. The head file cannot be checked, so entries
+// removed from the end of the log are not detected
+func VerifyAudit(r io.Reader, opts ...AuditOption) (AuditEntry, error) {
+	key := newAuditOptions(opts).key
+	reader := bufio.NewReader(r)
+
+	last := AuditEntry{Hash: auditGenesisHash}
+	lineNo := 0
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if len(line) > 0 {
+			lineNo++
+
+			if line[len(line)-1] != '\n' {
+				return last, fmt.Errorf("%w: line %d is incomplete, the log has been truncated", AuditLogTamperedError, lineNo)
+			}
+
+			var entry AuditEntry
+			if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+				return last, fmt.Errorf("%w: line %d is not a valid audit entry: %v", AuditLogTamperedError, lineNo, err)
+			}
+
+			switch {
+			case entry.Sequence != last.Sequence+1:
+				return last, fmt.Errorf("%w: line %d has sequence %d, expected %d", AuditLogTamperedError, lineNo, entry.Sequence, last.Sequence+1)
+			case entry.Previous != last.Hash:
+				return last, fmt.Errorf("%w: line %d is not chained to the previous entry", AuditLogTamperedError, lineNo)
+			case entry.Hash != entry.computeHash(key):
+				return last, fmt.Errorf("%w: line %d has been modified", AuditLogTamperedError, lineNo)
+			}
+
+			last = entry
+		}
+
+		if err == io.EOF {
+			return last, nil
+		}
+
+		if err != nil {
+			return last, err
+		}
+	}
+}
+
+func auditHeadPath(path string) string {
+	return path + auditHeadExt
+}
+
+// writeAuditHead records the sequence and hash of the latest entry, the file is replaced atomically
+// so a crash part way through never leaves a partial head
+func writeAuditHead(path string, entry AuditEntry) error {
+	tmp := auditHeadPath(path) + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %s\n", entry.Sequence, entry.Hash)), 0640); err != nil {
+		return fmt.Errorf("could not write audit head: %w", err)
+	}
+
+	if err := os.Rename(tmp, auditHeadPath(path)); err != nil {
+		return fmt.Errorf("could not write audit head: %w", err)
+	}
+
+	return nil
+}
+
+func readAuditHead(path string) (*AuditEntry, error) {
+	content, err := os.ReadFile(auditHeadPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	parts := strings.Fields(string(content))
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: head file is malformed", AuditLogTamperedError)
+	}
+
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: head file is malformed", AuditLogTamperedError)
+	}
+
+	return &AuditEntry{Sequence: seq, Hash: parts[1]}, nil
+}
+
+func auditFileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAuditEntries(t *testing.T, path string, n int, opts ...AuditOption) {
+	t.Helper()
+
+	a, err := OpenAuditLog(path, opts...)
+	if err != nil {
+		t.Fatalf("could not open audit log - %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := a.Record("test.event", map[string]interface{}{"index": i}); err != nil {
+			t.Fatalf("could not record audit entry - %v", err)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("could not close audit log - %v", err)
+	}
+}
+
+func TestAuditLogger_Verify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	writeAuditEntries(t, path, 3)
+	// reopening the log should continue the existing chain
+	writeAuditEntries(t, path, 2)
+
+	last, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatalf("expected audit log to be intact - %v", err)
+	}
+
+	if last.Sequence != 5 {
+		t.Errorf("expected 5 entries, found %d", last.Sequence)
+	}
+}
+
+func TestAuditLogger_DetectsTampering(t *testing.T) {
+	testCases := []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{
+			name: "modified entry",
+			tamper: func(b []byte) []byte {
+				return bytes.Replace(b, []byte(`"index":1`), []byte(`"index":7`), 1)
+			},
+		},
+		{
+			name: "removed entry",
+			tamper: func(b []byte) []byte {
+				lines := bytes.SplitAfter(b, []byte("\n"))
+				return bytes.Join(append(lines[:1:1], lines[2:]...), nil)
+			},
+		},
+		{
+			name: "truncated entry",
+			tamper: func(b []byte) []byte {
+				return b[:len(b)-10]
+			},
+		},
+		{
+			name: "removed last entry",
+			tamper: func(b []byte) []byte {
+				lines := bytes.SplitAfter(b, []byte("\n"))
+				return bytes.Join(lines[:len(lines)-2], nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			writeAuditEntries(t, path, 3)
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("could not read audit log - %v", err)
+			}
+
+			if err := os.WriteFile(path, tc.tamper(content), 0640); err != nil {
+				t.Fatalf("could not write audit log - %v", err)
+			}
+
+			if _, err := VerifyAuditLog(path); !errors.Is(err, AuditLogTamperedError) {
+				t.Errorf("expected tampering to be detected, got %v", err)
+			}
+
+			if _, err := OpenAuditLog(path); err == nil {
+				t.Errorf("expected a tampered audit log to be rejected when opened")
+			}
+		})
+	}
+}
+
+func TestAuditLogger_MissingHead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditEntries(t, path, 3)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read audit log - %v", err)
+	}
+
+	// removing the trailing entries along with the head leaves a valid chain
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if err := os.WriteFile(path, lines[0], 0640); err != nil {
+		t.Fatalf("could not write audit log - %v", err)
+	}
+
+	if err := os.Remove(path + auditHeadExt); err != nil {
+		t.Fatalf("could not remove audit head - %v", err)
+	}
+
+	if _, err := VerifyAuditLog(path); !errors.Is(err, AuditLogTamperedError) {
+		t.Errorf("expected a missing head to be detected, got %v", err)
+	}
+
+	if _, err := OpenAuditLog(path); err == nil {
+		t.Errorf("expected an audit log without a head to be rejected when opened")
+	}
+}
+
+func TestAuditLogger_Key(t *testing.T) {
+	key := []byte("secret")
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	writeAuditEntries(t, path, 2, WithAuditKey(key))
+	writeAuditEntries(t, path, 1, WithAuditKey(key))
+
+	if _, err := VerifyAuditLog(path, WithAuditKey(key)); err != nil {
+		t.Fatalf("expected audit log to be intact - %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		opts []AuditOption
+	}{
+		{name: "no key"},
+		{name: "wrong key", opts: []AuditOption{WithAuditKey([]byte("guess"))}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := VerifyAuditLog(path, tc.opts...); !errors.Is(err, AuditLogTamperedError) {
+				t.Errorf("expected verification to fail, got %v", err)
+			}
+		})
+	}
+
+	// rewriting the log without the key produces a valid unkeyed chain that the keyed verification rejects
+	forged := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditEntries(t, forged, 3)
+
+	if _, err := VerifyAuditLog(forged, WithAuditKey(key)); !errors.Is(err, AuditLogTamperedError) {
+		t.Errorf("expected a forged audit log to be detected, got %v", err)
+	}
+}
@@ -88,6 +88,16 @@ func ConfiguredLumberjackLogger() *lumberjack.Logger {
 	)
 }
 
+// ConfiguredWriter returns the writer for the application log file. If a rotation schedule, total size limit
+// or compression algorithm has been configured a RotatingWriter is returned, otherwise the lumberjack logger is used
+func ConfiguredWriter() io.Writer {
+	if viper.IsSet(config.LogFileRotation) || viper.IsSet(config.LogFileMaxTotalSize) || viper.IsSet(config.LogFileCompression) {
+		return ConfiguredRotatingLogger()
+	}
+
+	return ConfiguredLumberjackLogger()
+}
+
 func ConsoleLogger() *zap.Logger {
 	c := zapcore.NewCore(ZapEncoder(), zapcore.AddSync(os.Stdout), zapcore.InfoLevel)
 	return zap.New(c, zap.AddCaller())
@@ -119,7 +129,7 @@ func ApplicationLogLevel() zapcore.Level {
 }
 
 // Logger logger will create a new logger with the configured application log level
-// and lumberjack logger if one doesn't exist, or return the created logger if it has
+// and configured log file writer if one doesn't exist, or return the created logger if it has
 func Logger() *zap.Logger {
-	return Get(ApplicationLogLevel(), ConfiguredLumberjackLogger())
+	return Get(ApplicationLogLevel(), ConfiguredWriter())
 }
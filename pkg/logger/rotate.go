@@ -0,0 +1,582 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+
+	"github.com/birchwood-langham/bootstrap/pkg/config"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	megabyte         = 1024 * 1024
+	gzipExt          = ".gz"
+	zstdExt          = ".zst"
+)
+
+// Schedule determines how often a RotatingWriter rotates its log file, regardless of its size
+type Schedule int
+
+const (
+	// NoSchedule only rotates the log file when it reaches its maximum size
+	NoSchedule Schedule = iota
+	// Hourly rotates the log file at the start of every hour
+	Hourly
+	// Daily rotates the log file at midnight
+	Daily
+)
+
+// ParseSchedule converts the configured rotation schedule into a Schedule, unknown values will
+// be treated as NoSchedule
+func ParseSchedule(s string) Schedule {
+	switch strings.ToUpper(s) {
+	case "HOURLY":
+		return Hourly
+	case "DAILY":
+		return Daily
+	default:
+		return NoSchedule
+	}
+}
+
+// next returns the time after t when the next scheduled rotation should take place
+func (s Schedule) next(t time.Time) time.Time {
+	switch s {
+	case Hourly:
+		// Truncate works on absolute time, so the boundary is taken from the wall clock for zones offset by part of an hour
+		y, m, d := t.Date()
+		return time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+	case Daily:
+		y, m, d := t.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// Compression is the algorithm used to compress rotated log files
+type Compression int
+
+const (
+	// NoCompression leaves rotated log files uncompressed
+	NoCompression Compression = iota
+	// Gzip compresses rotated log files using gzip
+	Gzip
+	// Zstd compresses rotated log files using zstandard
+	Zstd
+)
+
+// ParseCompression converts the configured compression algorithm into a Compression, unknown values will
+// be treated as NoCompression
+func ParseCompression(s string) Compression {
+	switch strings.ToUpper(s) {
+	case "GZIP", "GZ":
+		return Gzip
+	case "ZSTD", "ZST":
+		return Zstd
+	default:
+		return NoCompression
+	}
+}
+
+func (c Compression) ext() string {
+	switch c {
+	case Gzip:
+		return gzipExt
+	case Zstd:
+		return zstdExt
+	default:
+		return ""
+	}
+}
+
+// RotatingWriter is an io.WriteCloser that writes to a log file and rotates it when it reaches its maximum
+// size, or when the configured schedule comes around. Rotated files are named using the time they were rotated,
+// compressed in the background and removed when they fall outside of the configured retention policies.
+type RotatingWriter struct {
+	// Filename is the file to write logs to, rotated files are kept in the same directory
+	Filename string
+	// MaxSize is the maximum size in megabytes of the log file before it is rotated, zero disables size based rotation
+	MaxSize int
+	// Schedule determines whether the log file should also be rotated hourly or daily
+	Schedule Schedule
+	// MaxTotalSize is the maximum size in megabytes of all the rotated files kept on disk, zero keeps all files
+	MaxTotalSize int
+	// MaxBackups is the maximum number of rotated files to keep, zero keeps all files
+	MaxBackups int
+	// MaxAge is the maximum number of days to keep rotated files, zero keeps all files
+	MaxAge int
+	// Compression is the algorithm used to compress rotated files
+	Compression Compression
+	// LocalTime determines if rotated files are named using local time rather than UTC
+	LocalTime bool
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	rotateAt  time.Time
+	millCh    chan struct{}
+	millOnce  sync.Once
+	millWg    sync.WaitGroup
+	stopCh    chan struct{}
+	signalsCh chan os.Signal
+	now       func() time.Time
+}
+
+// RotatingLogger creates a RotatingWriter with the given settings
+func RotatingLogger(fileName string, maxSize int, schedule Schedule, maxTotalSize, maxBackups, maxAge int, compression Compression) *RotatingWriter {
+	return &RotatingWriter{
+		Filename:     fileName,
+		MaxSize:      maxSize,
+		Schedule:     schedule,
+		MaxTotalSize: maxTotalSize,
+		MaxBackups:   maxBackups,
+		MaxAge:       maxAge,
+		Compression:  compression,
+	}
+}
+
+// ConfiguredRotatingLogger creates a RotatingWriter using the log settings in the application configuration file.
+// If log.compression has not been set, the log.compress flag will select gzip compression
+func ConfiguredRotatingLogger() *RotatingWriter {
+	compression := ParseCompression(viper.GetString(config.LogFileCompression))
+
+	if !viper.IsSet(config.LogFileCompression) && viper.GetBool(config.LogFileCompress) {
+		compression = Gzip
+	}
+
+	return RotatingLogger(
+		viper.GetString(config.LogFilePathKey),
+		viper.GetInt(config.LogFileMaxSize),
+		ParseSchedule(viper.GetString(config.LogFileRotation)),
+		viper.GetInt(config.LogFileMaxTotalSize),
+		viper.GetInt(config.LogFileMaxBackups),
+		viper.GetInt(config.LogFileMaxAge),
+		compression,
+	)
+}
+
+// Write writes to the log file, rotating it first if the write would take it over its maximum size
+// or the scheduled rotation time has passed
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Sync commits the current contents of the log file to disk
+func (w *RotatingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Sync()
+}
+
+// Rotate closes the current log file, renames it using the current time and opens a new log file
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	return w.rotate()
+}
+
+// RotateOnSignal rotates the log file whenever one of the given signals is received, if no signals are given
+// the log file will be rotated on SIGHUP. Signal handling stops when the writer is closed.
+func (w *RotatingWriter) RotateOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.signalsCh != nil {
+		signal.Stop(w.signalsCh)
+	}
+
+	w.signalsCh = make(chan os.Signal, 1)
+	signal.Notify(w.signalsCh, sigs...)
+
+	go func(ch <-chan os.Signal, stop <-chan struct{}) {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ch:
+				if err := w.Rotate(); err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "could not rotate log file %s: %v\n", w.Filename, err)
+				}
+			}
+		}
+	}(w.signalsCh, w.stop())
+}
+
+// Close closes the log file, stops any signal handling and waits for background compression to complete
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+
+	if w.signalsCh != nil {
+		signal.Stop(w.signalsCh)
+		w.signalsCh = nil
+	}
+
+	if w.stopCh != nil {
+		close(w.stopCh)
+	}
+
+	// a writer can be written to after it has been closed, it starts a new mill with the next rotation
+	w.stopCh = nil
+	w.millCh = nil
+	w.millOnce = sync.Once{}
+
+	var err error
+
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+
+	w.mu.Unlock()
+
+	w.millWg.Wait()
+
+	return err
+}
+
+func (w *RotatingWriter) stop() chan struct{} {
+	if w.stopCh == nil {
+		w.stopCh = make(chan struct{})
+	}
+
+	return w.stopCh
+}
+
+func (w *RotatingWriter) currentTime() time.Time {
+	t := time.Now()
+
+	if w.now != nil {
+		t = w.now()
+	}
+
+	return t.In(w.location())
+}
+
+// location is the time zone rotated files are named in
+func (w *RotatingWriter) location() *time.Location {
+	if w.LocalTime {
+		return time.Local
+	}
+
+	return time.UTC
+}
+
+func (w *RotatingWriter) shouldRotate(n int64) bool {
+	if w.MaxSize > 0 && w.size > 0 && w.size+n > int64(w.MaxSize)*megabyte {
+		return true
+	}
+
+	return !w.rotateAt.IsZero() && !w.currentTime().Before(w.rotateAt)
+}
+
+func (w *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Filename), 0755); err != nil {
+		return fmt.Errorf("could not create log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(w.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not stat log file: %w", err)
+	}
+
+	w.file = f
+	w.size = info.Size()
+	w.rotateAt = w.Schedule.next(w.currentTime())
+
+	return nil
+}
+
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	w.file = nil
+
+	if err := os.Rename(w.Filename, w.backupName(w.currentTime())); err != nil {
+		return fmt.Errorf("could not rename log file: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.mill()
+
+	return nil
+}
+
+// backupName generates a name for a rotated file using the given time, if a file already exists with that name
+// the time is incremented until an unused name is found
+func (w *RotatingWriter) backupName(t time.Time) string {
+	dir, prefix, ext := w.nameParts()
+
+	for {
+		name := filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, t.Format(backupTimeFormat), ext))
+
+		if !fileExists(name) && !fileExists(name+gzipExt) && !fileExists(name+zstdExt) {
+			return name
+		}
+
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func (w *RotatingWriter) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(w.Filename)
+	base := filepath.Base(w.Filename)
+	ext = filepath.Ext(base)
+	prefix = strings.TrimSuffix(base, ext)
+
+	return
+}
+
+// mill signals the background goroutine to compress and remove rotated files
+func (w *RotatingWriter) mill() {
+	w.millOnce.Do(func() {
+		w.millCh = make(chan struct{}, 1)
+		w.millWg.Add(1)
+
+		go func(millCh <-chan struct{}, stop <-chan struct{}) {
+			defer w.millWg.Done()
+
+			for {
+				select {
+				case <-stop:
+					// complete any outstanding work before exiting so rotated files are not left uncompressed
+					select {
+					case <-millCh:
+						if err := w.millRun(); err != nil {
+							_, _ = fmt.Fprintf(os.Stderr, "could not clean up rotated log files for %s: %v\n", w.Filename, err)
+						}
+					default:
+					}
+
+					return
+				case <-millCh:
+					if err := w.millRun(); err != nil {
+						_, _ = fmt.Fprintf(os.Stderr, "could not clean up rotated log files for %s: %v\n", w.Filename, err)
+					}
+				}
+			}
+		}(w.millCh, w.stop())
+	})
+
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+type backup struct {
+	path      string
+	size      int64
+	timestamp time.Time
+}
+
+// millRun compresses any uncompressed rotated files and applies the retention policies, newest files are kept first
+func (w *RotatingWriter) millRun() error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	if w.Compression != NoCompression {
+		for i, b := range backups {
+			if strings.HasSuffix(b.path, gzipExt) || strings.HasSuffix(b.path, zstdExt) {
+				continue
+			}
+
+			compressed := b.path + w.Compression.ext()
+
+			if err := compressFile(b.path, compressed, w.Compression); err != nil {
+				return err
+			}
+
+			info, err := os.Stat(compressed)
+			if err != nil {
+				return err
+			}
+
+			backups[i].path = compressed
+			backups[i].size = info.Size()
+		}
+	}
+
+	var total int64
+	cutoff := w.currentTime().Add(-time.Duration(w.MaxAge) * 24 * time.Hour)
+
+	for i, b := range backups {
+		total += b.size
+
+		remove := (w.MaxBackups > 0 && i >= w.MaxBackups) ||
+			(w.MaxAge > 0 && b.timestamp.Before(cutoff)) ||
+			(w.MaxTotalSize > 0 && total > int64(w.MaxTotalSize)*megabyte)
+
+		if !remove {
+			continue
+		}
+
+		total -= b.size
+
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backups lists the rotated files for the log file, sorted newest first
+func (w *RotatingWriter) backups() ([]backup, error) {
+	dir, prefix, ext := w.nameParts()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]backup, 0, len(entries))
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(e.Name(), gzipExt), zstdExt)
+
+		if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		ts, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix+"-"), ext),
+			w.location())
+		if err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, backup{
+			path:      filepath.Join(dir, e.Name()),
+			size:      info.Size(),
+			timestamp: ts,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].timestamp.After(backups[j].timestamp)
+	})
+
+	return backups, nil
+}
+
+// compressFile compresses src into dst and removes src once the compressed file has been written
+func compressFile(src, dst string, compression Compression) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(dst)
+		}
+	}()
+
+	var cw io.WriteCloser
+
+	switch compression {
+	case Gzip:
+		cw = gzip.NewWriter(out)
+	case Zstd:
+		if cw, err = zstd.NewWriter(out); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported compression algorithm")
+	}
+
+	if _, err = io.Copy(cw, in); err != nil {
+		return err
+	}
+
+	if err = cw.Close(); err != nil {
+		return err
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
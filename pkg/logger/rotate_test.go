@@ -0,0 +1,299 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testClock is read by the writer's mill goroutine while the test moves it on
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.t
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t = c.t.Add(d)
+}
+
+func newTestWriter(t *testing.T, clock *testClock) *RotatingWriter {
+	t.Helper()
+
+	w := RotatingLogger(filepath.Join(t.TempDir(), "app.log"), 0, NoSchedule, 0, 0, 0, NoCompression)
+	w.now = clock.now
+
+	return w
+}
+
+func listBackups(t *testing.T, w *RotatingWriter) []string {
+	t.Helper()
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatalf("could not list backups - %v", err)
+	}
+
+	names := make([]string, 0, len(backups))
+	for _, b := range backups {
+		names = append(names, filepath.Base(b.path))
+	}
+
+	return names
+}
+
+func TestRotatingWriter_SizeRotation(t *testing.T) {
+	clock := &testClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	w := newTestWriter(t, clock)
+	w.MaxSize = 1
+
+	chunk := bytes.Repeat([]byte("a"), megabyte/2+1)
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write failed - %v", err)
+		}
+
+		clock.add(time.Second)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("close failed - %v", err)
+	}
+
+	got := listBackups(t, w)
+	want := []string{"app-2021-03-01T10-00-02.000.log", "app-2021-03-01T10-00-01.000.log"}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want backups %v, got %v", want, got)
+	}
+}
+
+func TestRotatingWriter_ScheduledRotation(t *testing.T) {
+	clock := &testClock{t: time.Date(2021, 3, 1, 23, 59, 0, 0, time.UTC)}
+	w := newTestWriter(t, clock)
+	w.Schedule = Daily
+
+	if _, err := w.Write([]byte("before midnight\n")); err != nil {
+		t.Fatalf("write failed - %v", err)
+	}
+
+	clock.add(time.Minute)
+
+	if _, err := w.Write([]byte("after midnight\n")); err != nil {
+		t.Fatalf("write failed - %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("close failed - %v", err)
+	}
+
+	got := listBackups(t, w)
+
+	if len(got) != 1 || got[0] != "app-2021-03-02T00-00-00.000.log" {
+		t.Errorf("expected a single backup rotated at midnight, got %v", got)
+	}
+
+	content, err := os.ReadFile(w.Filename)
+	if err != nil {
+		t.Fatalf("could not read log file - %v", err)
+	}
+
+	if string(content) != "after midnight\n" {
+		t.Errorf("unexpected log file content %q", content)
+	}
+}
+
+func TestSchedule_NextHourly(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*60*60+30*60)
+
+	next := Hourly.next(time.Date(2021, 3, 1, 10, 45, 0, 0, kolkata))
+
+	if want := time.Date(2021, 3, 1, 11, 0, 0, 0, kolkata); !next.Equal(want) {
+		t.Errorf("expected the hourly rotation at the start of the local hour %v, got %v", want, next)
+	}
+}
+
+func TestRotatingWriter_MaxTotalSize(t *testing.T) {
+	clock := &testClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	w := newTestWriter(t, clock)
+	w.MaxTotalSize = 1
+
+	chunk := bytes.Repeat([]byte("b"), megabyte/3)
+
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write failed - %v", err)
+		}
+
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("rotate failed - %v", err)
+		}
+
+		clock.add(time.Second)
+	}
+
+	// closing the writer waits for the rotation after the final write to be milled
+	if err := w.Close(); err != nil {
+		t.Fatalf("close failed - %v", err)
+	}
+
+	assertTotalSize(t, w)
+
+	// a writer that is written to after it has been closed still mills its rotations
+	for i := 0; i < 2; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write failed - %v", err)
+		}
+
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("rotate failed - %v", err)
+		}
+
+		clock.add(time.Second)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("close failed - %v", err)
+	}
+
+	assertTotalSize(t, w)
+}
+
+func assertTotalSize(t *testing.T, w *RotatingWriter) {
+	t.Helper()
+
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatalf("could not list backups - %v", err)
+	}
+
+	var total int64
+	for _, b := range backups {
+		total += b.size
+	}
+
+	if total > megabyte {
+		t.Errorf("expected backups to be limited to %d bytes, found %d", megabyte, total)
+	}
+
+	if len(backups) != 3 {
+		t.Errorf("expected 3 backups to be retained, found %d", len(backups))
+	}
+}
+
+func TestRotatingWriter_MaxAgeLocalTime(t *testing.T) {
+	// backups are named in local time, twelve hours behind UTC, so reading the names as UTC would age them by
+	// twelve hours and remove backups that are still within the maximum age
+	local := time.Local
+	time.Local = time.FixedZone("UTC-12", -12*60*60)
+	defer func() { time.Local = local }()
+
+	clock := &testClock{t: time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)}
+	w := newTestWriter(t, clock)
+	w.LocalTime = true
+	w.MaxAge = 1
+
+	dir, prefix, ext := w.nameParts()
+	now := clock.now().In(time.Local)
+
+	for _, age := range []time.Duration{20 * time.Hour, 30 * time.Hour} {
+		name := filepath.Join(dir, prefix+"-"+now.Add(-age).Format(backupTimeFormat)+ext)
+
+		if err := os.WriteFile(name, []byte("old entries\n"), 0644); err != nil {
+			t.Fatalf("could not write backup - %v", err)
+		}
+	}
+
+	if err := w.millRun(); err != nil {
+		t.Fatalf("mill failed - %v", err)
+	}
+
+	got := listBackups(t, w)
+	want := prefix + "-" + now.Add(-20*time.Hour).Format(backupTimeFormat) + ext
+
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("expected only %s to be retained, got %v", want, got)
+	}
+}
+
+func TestRotatingWriter_Compression(t *testing.T) {
+	testCases := []struct {
+		compression Compression
+		decompress  func(io.Reader) (io.Reader, error)
+	}{
+		{
+			compression: Gzip,
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			compression: Zstd,
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		clock := &testClock{t: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+		w := newTestWriter(t, clock)
+		w.Compression = tc.compression
+
+		if _, err := w.Write([]byte("compress me\n")); err != nil {
+			t.Fatalf("write failed - %v", err)
+		}
+
+		if err := w.Rotate(); err != nil {
+			t.Fatalf("rotate failed - %v", err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("close failed - %v", err)
+		}
+
+		backups := listBackups(t, w)
+		if len(backups) != 1 || !strings.HasSuffix(backups[0], tc.compression.ext()) {
+			t.Fatalf("expected a single compressed backup, got %v", backups)
+		}
+
+		f, err := os.Open(filepath.Join(filepath.Dir(w.Filename), backups[0]))
+		if err != nil {
+			t.Fatalf("could not open backup - %v", err)
+		}
+
+		r, err := tc.decompress(f)
+		if err != nil {
+			t.Fatalf("could not decompress backup - %v", err)
+		}
+
+		content, err := io.ReadAll(r)
+		_ = f.Close()
+
+		if err != nil {
+			t.Fatalf("could not read backup - %v", err)
+		}
+
+		if string(content) != "compress me\n" {
+			t.Errorf("unexpected backup content %q", content)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package resilience
+
+import (
+	"context"
+	ge "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+const (
+	// DefaultFailureThreshold is the number of consecutive failures that opens a circuit
+	DefaultFailureThreshold = 5
+	// DefaultOpenTimeout is the time a circuit stays open before it lets probes through
+	DefaultOpenTimeout = 30 * time.Second
+	// DefaultHalfOpenProbes is the number of probes that must succeed to close a half-open circuit
+	DefaultHalfOpenProbes = 1
+)
+
+// OpenCircuitError is returned when a circuit breaker rejects an operation without running it
+var OpenCircuitError error = errors.New(errors.Unavailable, "circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// Closed lets every operation through
+	Closed State = iota
+	// Open rejects every operation until the open timeout has passed
+	Open
+	// HalfOpen lets a limited number of probes through to find out whether the dependency has recovered
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// StateChangeFunc is called when a circuit breaker changes state
+type StateChangeFunc func(name string, from, to State)
+
+// CircuitBreaker stops calling a dependency that keeps failing, so the dependency has a chance to recover and callers
+// fail fast instead of waiting for it. The circuit opens after a number of consecutive failures and rejects operations
+// with OpenCircuitError. Once the open timeout has passed the circuit is half-open and lets probes through, it closes
+// again when enough probes succeed and opens again if any of them fail
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	timeout   time.Duration
+	probes    int
+	isFailure func(error) bool
+	listeners []StateChangeFunc
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	failures   int
+	successes  int
+	inFlight   int
+	openedAt   time.Time
+}
+
+// BreakerOption sets an optional setting on the circuit breaker
+type BreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold sets the number of consecutive failures that opens the circuit
+func WithFailureThreshold(n int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.threshold = n
+	}
+}
+
+// WithOpenTimeout sets the time the circuit stays open before it lets probes through
+func WithOpenTimeout(d time.Duration) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.timeout = d
+	}
+}
+
+// WithHalfOpenProbes sets the number of probes let through while the circuit is half-open, the circuit closes when
+// they all succeed
+func WithHalfOpenProbes(n int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.probes = n
+	}
+}
+
+// WithFailureCondition decides which errors count as failures of the dependency, by default every error does except
+// those from a canceled context. Errors that are not failures are returned without affecting the circuit, e.g.
+//
+//	resilience.WithFailureCondition(func(err error) bool {
+//		return !stderrors.Is(err, errors.NotFound)
+//	})
+func WithFailureCondition(fn func(error) bool) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.isFailure = fn
+	}
+}
+
+// WithStateChange adds a function that is called whenever the circuit changes state, functions are called in the
+// order they are added after the change has been made, so they may call the circuit breaker
+func WithStateChange(fn StateChangeFunc) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.listeners = append(b.listeners, fn)
+	}
+}
+
+// NewCircuitBreaker creates a closed circuit breaker, the name identifies the dependency in the log and in state
+// change callbacks
+func NewCircuitBreaker(name string, opts ...BreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		name:      name,
+		threshold: DefaultFailureThreshold,
+		timeout:   DefaultOpenTimeout,
+		probes:    DefaultHalfOpenProbes,
+		isFailure: func(err error) bool {
+			return !ge.Is(err, context.Canceled)
+		},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Name returns the name of the circuit breaker
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State returns the current state of the circuit, an open circuit whose timeout has passed is reported as half-open
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.timeout {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Execute runs the operation if the circuit allows it and records the result, an operation that is rejected returns
+// an error that matches OpenCircuitError
+func (b *CircuitBreaker) Execute(ctx context.Context, op Operation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	// a panic counts as a failure, so a probe that panics does not hold the half-open circuit forever
+	defer func() {
+		if r := recover(); r != nil {
+			b.record(generation, fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+
+	err = op(ctx)
+	b.record(generation, err)
+
+	return err
+}
+
+// allow reserves a call through the circuit, returning the generation of the state the call was made in
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+
+	var change func()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.timeout {
+		change = b.setState(HalfOpen)
+	}
+
+	allowed := b.state == Closed || (b.state == HalfOpen && b.inFlight < b.probes)
+	if allowed && b.state == HalfOpen {
+		b.inFlight++
+	}
+
+	generation := b.generation
+	b.mu.Unlock()
+
+	if change != nil {
+		change()
+	}
+
+	if !allowed {
+		return 0, fmt.Errorf("%s: %w", b.name, OpenCircuitError)
+	}
+
+	return generation, nil
+}
+
+// record updates the circuit with the result of a call, results of calls made before the last state change are ignored
+func (b *CircuitBreaker) record(generation uint64, err error) {
+	b.mu.Lock()
+
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
+
+	failed := err != nil && b.isFailure(err)
+
+	var change func()
+
+	switch b.state {
+	case Closed:
+		switch {
+		case failed:
+			b.failures++
+
+			if b.failures >= b.threshold {
+				change = b.setState(Open)
+			}
+		case err == nil:
+			b.failures = 0
+		}
+	case HalfOpen:
+		b.inFlight--
+
+		switch {
+		case failed:
+			change = b.setState(Open)
+		case err == nil:
+			b.successes++
+
+			if b.successes >= b.probes {
+				change = b.setState(Closed)
+			}
+		}
+	}
+
+	b.mu.Unlock()
+
+	if change != nil {
+		change()
+	}
+}
+
+// setState moves the circuit to a new state, it must be called holding the lock and returns a function that logs
+// the change and calls the listeners, which must be called once the lock has been released
+func (b *CircuitBreaker) setState(to State) func() {
+	from := b.state
+
+	b.state = to
+	b.generation++
+	b.failures, b.successes, b.inFlight = 0, 0, 0
+
+	if to == Open {
+		b.openedAt = time.Now()
+	}
+
+	return func() {
+		l := logger.Logger()
+
+		if to == Open {
+			l.Warn("Circuit breaker opened", zap.String("name", b.name), zap.String("from", from.String()),
+				zap.Duration("timeout", b.timeout))
+		} else {
+			l.Info("Circuit breaker state changed", zap.String("name", b.name), zap.String("from", from.String()),
+				zap.String("to", to.String()))
+		}
+
+		for _, fn := range b.listeners {
+			fn(b.name, from, to)
+		}
+	}
+}
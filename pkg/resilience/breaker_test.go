@@ -0,0 +1,106 @@
+package resilience_test
+
+import (
+	"context"
+	ge "errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/resilience"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	unavailable := ge.New("service unavailable")
+
+	changes := make([]string, 0)
+
+	b := resilience.NewCircuitBreaker("orders",
+		resilience.WithFailureThreshold(2),
+		resilience.WithOpenTimeout(20*time.Millisecond),
+		resilience.WithHalfOpenProbes(2),
+		resilience.WithFailureCondition(func(err error) bool { return !ge.Is(err, errors.NotFound) }),
+		resilience.WithStateChange(func(name string, from, to resilience.State) {
+			changes = append(changes, name+":"+from.String()+"->"+to.String())
+		}),
+	)
+
+	fail := func(context.Context) error { return unavailable }
+	succeed := func(context.Context) error { return nil }
+	notFound := func(context.Context) error { return errors.New(errors.NotFound, "order not found") }
+
+	_ = b.Execute(ctx, fail)
+	_ = b.Execute(ctx, succeed)
+	_ = b.Execute(ctx, fail)
+	_ = b.Execute(ctx, notFound)
+
+	if b.State() != resilience.Closed {
+		t.Fatalf("expected a success to reset the failure count and ignored errors not to count, got %s", b.State())
+	}
+
+	_ = b.Execute(ctx, fail)
+
+	if err := b.Execute(ctx, succeed); !ge.Is(err, resilience.OpenCircuitError) || errors.HTTPStatus(err) != 503 {
+		t.Fatalf("expected the open circuit to reject the operation, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if b.State() != resilience.HalfOpen {
+		t.Fatalf("expected the circuit to be half-open after the timeout, got %s", b.State())
+	}
+
+	_ = b.Execute(ctx, fail)
+
+	if b.State() != resilience.Open {
+		t.Fatalf("expected a failed probe to open the circuit, got %s", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	_ = b.Execute(ctx, succeed)
+	_ = b.Execute(ctx, succeed)
+
+	if b.State() != resilience.Closed {
+		t.Fatalf("expected the successful probes to close the circuit, got %s", b.State())
+	}
+
+	want := []string{
+		"orders:closed->open", "orders:open->half-open", "orders:half-open->open",
+		"orders:open->half-open", "orders:half-open->closed",
+	}
+
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected state changes %v, got %v", want, changes)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbes(t *testing.T) {
+	ctx := context.Background()
+
+	b := resilience.NewCircuitBreaker("orders", resilience.WithFailureThreshold(1), resilience.WithOpenTimeout(0))
+	_ = b.Execute(ctx, func(context.Context) error { return ge.New("service unavailable") })
+
+	probing := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- b.Execute(ctx, func(context.Context) error {
+			close(probing)
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+	}()
+
+	<-probing
+
+	if err := b.Execute(ctx, func(context.Context) error { return nil }); !ge.Is(err, resilience.OpenCircuitError) {
+		t.Errorf("expected only one probe to be let through, got %v", err)
+	}
+
+	if err := <-done; err != nil || b.State() != resilience.Closed {
+		t.Errorf("expected the probe to close the circuit, got %s - %v", b.State(), err)
+	}
+}
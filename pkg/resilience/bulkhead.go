@@ -0,0 +1,117 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+// BulkheadFullError is returned when a bulkhead rejects an operation because all its slots are in use
+var BulkheadFullError error = errors.New(errors.ResourceExhausted, "bulkhead is full")
+
+// Bulkhead limits the number of operations that run at the same time, so a slow dependency cannot tie up every
+// goroutine or connection of the service. Operations wait for a free slot until their context ends, or for the
+// maximum wait if one has been set
+type Bulkhead struct {
+	name      string
+	slots     chan struct{}
+	maxWait   time.Duration
+	limitWait bool
+}
+
+// BulkheadOption sets an optional setting on the bulkhead
+type BulkheadOption func(*Bulkhead)
+
+// WithMaxWait limits the time an operation waits for a free slot, operations are rejected straight away if the bulkhead
+// is full and the time is zero
+func WithMaxWait(d time.Duration) BulkheadOption {
+	return func(b *Bulkhead) {
+		b.maxWait = d
+		b.limitWait = true
+	}
+}
+
+// NewBulkhead creates a bulkhead that runs up to max operations at the same time, the name identifies the bulkhead in
+// the log
+func NewBulkhead(name string, max int, opts ...BulkheadOption) *Bulkhead {
+	if max < 1 {
+		max = 1
+	}
+
+	b := &Bulkhead{name: name, slots: make(chan struct{}, max)}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Name returns the name of the bulkhead
+func (b *Bulkhead) Name() string {
+	return b.name
+}
+
+// InUse returns the number of operations running in the bulkhead
+func (b *Bulkhead) InUse() int {
+	return len(b.slots)
+}
+
+// Capacity returns the number of operations the bulkhead runs at the same time
+func (b *Bulkhead) Capacity() int {
+	return cap(b.slots)
+}
+
+// Execute runs the operation once a slot is free, an operation that is rejected returns an error that matches
+// BulkheadFullError, or the context's error if the context ends while waiting
+func (b *Bulkhead) Execute(ctx context.Context, op Operation) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+
+	defer func() { <-b.slots }()
+
+	return op(ctx)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+
+	if b.limitWait {
+		if b.maxWait <= 0 {
+			return b.rejected()
+		}
+
+		timer := time.NewTimer(b.maxWait)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return b.rejected()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Bulkhead) rejected() error {
+	logger.Logger().Warn("Bulkhead is full, operation rejected", zap.String("name", b.name),
+		zap.Int("capacity", b.Capacity()))
+
+	return fmt.Errorf("%s: %w", b.name, BulkheadFullError)
+}
@@ -0,0 +1,98 @@
+package resilience_test
+
+import (
+	"context"
+	ge "errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/resilience"
+)
+
+func TestBulkhead(t *testing.T) {
+	ctx := context.Background()
+	b := resilience.NewBulkhead("reports", 2)
+
+	var running, peak int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_ = b.Execute(ctx, func(context.Context) error {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	if peak != 2 || b.InUse() != 0 {
+		t.Errorf("expected at most 2 operations to run at the same time, got %d", peak)
+	}
+}
+
+func TestBulkhead_Rejected(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	b := resilience.NewBulkhead("reports", 1, resilience.WithMaxWait(10*time.Millisecond))
+
+	go func() {
+		_ = b.Execute(context.Background(), func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+	defer close(release)
+
+	noop := func(context.Context) error { return nil }
+
+	if err := b.Execute(context.Background(), noop); !ge.Is(err, resilience.BulkheadFullError) || errors.HTTPStatus(err) != 429 {
+		t.Errorf("expected the operation to be rejected after waiting, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := resilience.NewBulkhead("reports", 1).Execute(ctx, noop); err != nil {
+		t.Errorf("expected a free slot to be used even when waiting is not possible - %v", err)
+	}
+
+	wait := resilience.NewBulkhead("reports", 1)
+
+	go func() {
+		_ = wait.Execute(context.Background(), func(context.Context) error {
+			<-release
+			return nil
+		})
+	}()
+
+	for wait.InUse() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := wait.Execute(ctx, noop); !ge.Is(err, context.Canceled) {
+		t.Errorf("expected waiting to stop when the context ends, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package resilience
+
+import (
+	"context"
+	ge "errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/logger"
+)
+
+const (
+	// DefaultAttempts is the number of times an operation is attempted, including the first attempt
+	DefaultAttempts = 3
+	// DefaultInitialBackoff is the delay before the first retry
+	DefaultInitialBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay between retries
+	DefaultMaxBackoff = 10 * time.Second
+	// DefaultJitter is the fraction of each delay that is randomised
+	DefaultJitter = 0.2
+)
+
+// Operation is the work a retry policy, circuit breaker or bulkhead protects
+type Operation func(ctx context.Context) error
+
+// Backoff returns the delay before the next attempt of an operation that has failed the given number of times
+type Backoff func(failures int) time.Duration
+
+// Constant waits the same time before every retry
+func Constant(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// Exponential waits the initial delay before the first retry and doubles it for every following retry, the delay is
+// limited to max unless it is zero
+func Exponential(initial, max time.Duration) Backoff {
+	return func(failures int) time.Duration {
+		d := initial
+
+		for i := 1; i < failures && (max <= 0 || d < max); i++ {
+			if d > math.MaxInt64/2 {
+				return math.MaxInt64
+			}
+
+			d *= 2
+		}
+
+		if max > 0 && d > max {
+			return max
+		}
+
+		return d
+	}
+}
+
+var (
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMu sync.Mutex
+)
+
+// Jitter randomises the delays of the backoff by up to the given fraction either way, e.g. a fraction of 0.2 turns a
+// one second delay into a delay between 800ms and 1.2s, so clients that failed together do not retry together
+func Jitter(b Backoff, fraction float64) Backoff {
+	return func(failures int) time.Duration {
+		d := b(failures)
+
+		randomMu.Lock()
+		r := random.Float64()
+		randomMu.Unlock()
+
+		return time.Duration(float64(d) * (1 + fraction*(2*r-1)))
+	}
+}
+
+// RetryPolicy runs an operation until it succeeds, returns an error that is not retryable, or runs out of attempts
+// or time. A policy can be shared by any number of goroutines
+type RetryPolicy struct {
+	name       string
+	attempts   int
+	backoff    Backoff
+	maxElapsed time.Duration
+	retryable  func(error) bool
+}
+
+// RetryOption sets an optional setting on the retry policy
+type RetryOption func(*RetryPolicy)
+
+// WithAttempts sets the number of times the operation is attempted, including the first attempt. There is no limit if
+// it is zero, so the operation is retried until it succeeds, the maximum elapsed time passes or the context ends
+func WithAttempts(n int) RetryOption {
+	return func(p *RetryPolicy) {
+		p.attempts = n
+	}
+}
+
+// WithBackoff sets the delays between attempts
+func WithBackoff(b Backoff) RetryOption {
+	return func(p *RetryPolicy) {
+		p.backoff = b
+	}
+}
+
+// WithMaxElapsedTime stops retrying once the next attempt would start more than the given time after the first
+func WithMaxElapsedTime(d time.Duration) RetryOption {
+	return func(p *RetryPolicy) {
+		p.maxElapsed = d
+	}
+}
+
+// WithRetryable decides which errors are retried, IsTransient is used by default
+func WithRetryable(fn func(error) bool) RetryOption {
+	return func(p *RetryPolicy) {
+		p.retryable = fn
+	}
+}
+
+// NewRetryPolicy creates a retry policy, the name identifies the operation in the log. By default the operation is
+// attempted DefaultAttempts times with an exponential backoff from DefaultInitialBackoff to DefaultMaxBackoff, jittered
+// by DefaultJitter
+func NewRetryPolicy(name string, opts ...RetryOption) *RetryPolicy {
+	p := &RetryPolicy{
+		name:      name,
+		attempts:  DefaultAttempts,
+		backoff:   Jitter(Exponential(DefaultInitialBackoff, DefaultMaxBackoff), DefaultJitter),
+		retryable: IsTransient,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// IsTransient is the default retryable predicate, it does not retry errors whose code means that trying again will
+// not help, such as errors.InvalidArgument or errors.NotFound, errors from a canceled context, or errors marked with
+// Permanent
+func IsTransient(err error) bool {
+	if IsPermanent(err) {
+		return false
+	}
+
+	switch errors.CodeOf(err) {
+	case errors.Canceled, errors.InvalidArgument, errors.NotFound, errors.AlreadyExists, errors.PermissionDenied,
+		errors.Unauthenticated, errors.FailedPrecondition, errors.Unimplemented:
+		return false
+	}
+
+	return true
+}
+
+// Do runs the operation until it succeeds or the policy gives up, returning the last error. The context passed to
+// the operation is the one given to Do, waiting between attempts stops as soon as it ends
+func (p *RetryPolicy) Do(ctx context.Context, op Operation) error {
+	l := logger.Logger()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if !p.retryable(err) {
+			return err
+		}
+
+		if p.attempts > 0 && attempt >= p.attempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", p.name, attempt, err)
+		}
+
+		delay := p.backoff(attempt)
+
+		if p.maxElapsed > 0 && time.Since(start)+delay > p.maxElapsed {
+			return fmt.Errorf("%s failed after %d attempts in %s: %w", p.name, attempt,
+				time.Since(start).Round(time.Millisecond), err)
+		}
+
+		l.Warn("Operation failed, retrying", zap.String("operation", p.name), zap.Int("attempt", attempt),
+			zap.Duration("backoff", delay), zap.Error(err))
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s interrupted after %d attempts: %w", p.name, attempt, errors.Append(err, ctx.Err()))
+		}
+	}
+}
+
+// permanentError marks an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error so the default retry policy does not retry it, errors.Is and errors.As still see the error
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err: err}
+}
+
+// IsPermanent returns true if the error has been marked with Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return ge.As(err, &p)
+}
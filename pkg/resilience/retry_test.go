@@ -0,0 +1,122 @@
+package resilience_test
+
+import (
+	"context"
+	ge "errors"
+	"testing"
+	"time"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/resilience"
+)
+
+// failing returns an operation that fails with err the given number of times before it succeeds
+func failing(times int, err error, calls *int) resilience.Operation {
+	return func(context.Context) error {
+		*calls++
+
+		if *calls <= times {
+			return err
+		}
+
+		return nil
+	}
+}
+
+func TestExponential(t *testing.T) {
+	backoff := resilience.Exponential(100*time.Millisecond, time.Second)
+
+	for failures, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond, 5: time.Second, 100: time.Second} {
+		if d := backoff(failures); d != want {
+			t.Errorf("expected a delay of %s after %d failures, got %s", want, failures, d)
+		}
+	}
+
+	if d := resilience.Exponential(time.Second, 0)(100); d <= 0 {
+		t.Errorf("expected an unlimited backoff not to overflow, got %s", d)
+	}
+
+	jittered := resilience.Jitter(resilience.Constant(time.Second), 0.2)
+
+	for i := 0; i < 100; i++ {
+		if d := jittered(1); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("expected the delay to be within 20%% of a second, got %s", d)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	refused := ge.New("connection refused")
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		opts     []resilience.RetryOption
+		calls    int
+		failed   bool
+	}{
+		{name: "succeeds after retrying", failures: 2, err: refused, calls: 3},
+		{name: "runs out of attempts", failures: 5, err: refused, calls: 3, failed: true},
+		{name: "unlimited attempts", failures: 5, err: refused, opts: []resilience.RetryOption{resilience.WithAttempts(0)}, calls: 6},
+		{name: "does not retry invalid arguments", failures: 5, err: errors.New(errors.InvalidArgument, "bad request"), calls: 1, failed: true},
+		{name: "does not retry permanent errors", failures: 5, err: resilience.Permanent(refused), calls: 1, failed: true},
+		{
+			name:     "retryable predicate",
+			failures: 5,
+			err:      refused,
+			opts:     []resilience.RetryOption{resilience.WithRetryable(func(err error) bool { return !ge.Is(err, refused) })},
+			calls:    1,
+			failed:   true,
+		},
+		{
+			name:     "max elapsed time",
+			failures: 100,
+			err:      refused,
+			opts: []resilience.RetryOption{
+				resilience.WithAttempts(0),
+				resilience.WithBackoff(resilience.Constant(10 * time.Millisecond)),
+				resilience.WithMaxElapsedTime(35 * time.Millisecond),
+			},
+			calls:  4,
+			failed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			opts := append([]resilience.RetryOption{resilience.WithBackoff(resilience.Constant(time.Millisecond))}, test.opts...)
+
+			err := resilience.NewRetryPolicy("connect", opts...).Do(context.Background(), failing(test.failures, test.err, &calls))
+
+			if calls != test.calls {
+				t.Errorf("expected %d calls, got %d", test.calls, calls)
+			}
+
+			if test.failed != (err != nil) {
+				t.Fatalf("expected failed to be %v - %v", test.failed, err)
+			}
+
+			if test.failed && !ge.Is(err, test.err) {
+				t.Errorf("expected the last error to be returned, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Canceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	refused := ge.New("connection refused")
+	calls := 0
+
+	err := resilience.NewRetryPolicy("connect", resilience.WithBackoff(resilience.Constant(time.Hour))).
+		Do(ctx, failing(5, refused, &calls))
+
+	if calls != 1 || !ge.Is(err, context.DeadlineExceeded) || !ge.Is(err, refused) {
+		t.Errorf("expected waiting to stop when the context ends, got %d calls - %v", calls, err)
+	}
+}
@@ -2,15 +2,25 @@ package service
 
 import (
 	"context"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
 )
 
 type InitFunc func(ctx context.Context, state StateStore) error
 type CleanupFunc func(state StateStore) error
 type RunFunc func(ctx context.Context, state StateStore) error
 
+// Component is a resource the application manages, such as a connection pool, that is initialized before the
+// application's init functions and cleaned up after its cleanup functions
+type Component interface {
+	Init(ctx context.Context, state StateStore) error
+	Cleanup(state StateStore) error
+}
+
 type Application struct {
 	initFunctions    []InitFunc
 	cleanupFunctions []CleanupFunc
+	components       []Component
 	runFunc          RunFunc
 }
 
@@ -18,14 +28,23 @@ func NewApplication() Application {
 	return Application{
 		initFunctions:    make([]InitFunc, 0),
 		cleanupFunctions: make([]CleanupFunc, 0),
+		components:       make([]Component, 0),
 		runFunc:          nil,
 	}
 }
 
+// Init initializes the components and then runs the init functions. If either fails, the components that have
+// already been initialized are cleaned up in reverse order, and their failures are returned with the init error
 func (a Application) Init(ctx context.Context, state StateStore) error {
+	for i, c := range a.components {
+		if err := c.Init(ctx, state); err != nil {
+			return errors.Append(err, a.cleanupComponents(state, i))
+		}
+	}
+
 	for _, f := range a.initFunctions {
 		if err := f(ctx, state); err != nil {
-			return err
+			return errors.Append(err, a.cleanupComponents(state, len(a.components)))
 		}
 	}
 
@@ -37,14 +56,30 @@ func (a Application) AddInitFunc(initFuncs ...InitFunc) Application {
 	return a
 }
 
+// Cleanup runs every cleanup function and then cleans up the components, a failure does not stop the remaining
+// cleanups from running. All the failures are returned together as an *errors.MultiError
 func (a Application) Cleanup(state StateStore) error {
+	var errs errors.MultiError
+
 	for _, f := range a.cleanupFunctions {
-		if err := f(state); err != nil {
-			return err
-		}
+		errs.Append(f(state))
 	}
 
-	return nil
+	errs.Append(a.cleanupComponents(state, len(a.components)))
+
+	return errs.ErrorOrNil()
+}
+
+// cleanupComponents cleans up the first n components in the reverse order they were added, so components can depend
+// on earlier ones
+func (a Application) cleanupComponents(state StateStore, n int) error {
+	var errs errors.MultiError
+
+	for i := n - 1; i >= 0; i-- {
+		errs.Append(a.components[i].Cleanup(state))
+	}
+
+	return errs.ErrorOrNil()
 }
 
 func (a Application) AddCleanupFunc(fns ...CleanupFunc) Application {
@@ -52,6 +87,12 @@ func (a Application) AddCleanupFunc(fns ...CleanupFunc) Application {
 	return a
 }
 
+// AddComponent adds components to the application, components are initialized in the order they are added
+func (a Application) AddComponent(components ...Component) Application {
+	a.components = append(a.components, components...)
+	return a
+}
+
 func (a Application) SetProperties(usage, shortDesc, longDesc string) {
 	SetCliProperties(usage, shortDesc, longDesc)
 }
@@ -0,0 +1,98 @@
+package service_test
+
+import (
+	"context"
+	ge "errors"
+	"reflect"
+	"testing"
+
+	"github.com/birchwood-langham/bootstrap/pkg/errors"
+	"github.com/birchwood-langham/bootstrap/pkg/service"
+)
+
+type component struct {
+	name    string
+	initErr error
+	err     error
+	cleaned *[]string
+}
+
+func (c component) Init(context.Context, service.StateStore) error {
+	return c.initErr
+}
+
+func (c component) Cleanup(service.StateStore) error {
+	*c.cleaned = append(*c.cleaned, c.name)
+	return c.err
+}
+
+func TestApplication_Cleanup(t *testing.T) {
+	cleaned := make([]string, 0)
+	flushFailed := ge.New("could not flush audit log")
+	closeFailed := errors.New(errors.Unavailable, "could not close connection pool")
+
+	cleanup := func(name string, err error) service.CleanupFunc {
+		return func(service.StateStore) error {
+			cleaned = append(cleaned, name)
+			return err
+		}
+	}
+
+	app := service.NewApplication().
+		AddComponent(component{name: "database", err: closeFailed, cleaned: &cleaned}, component{name: "cache", cleaned: &cleaned}).
+		AddCleanupFunc(cleanup("audit", flushFailed), cleanup("server", nil))
+
+	err := app.Cleanup(nil)
+
+	if want := []string{"audit", "server", "cache", "database"}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("expected every cleanup to run in order %v, got %v", want, cleaned)
+	}
+
+	if !ge.Is(err, flushFailed) || !ge.Is(err, closeFailed) {
+		t.Errorf("expected every failure to be reported, got %v", err)
+	}
+
+	if err := service.NewApplication().AddCleanupFunc(cleanup("server", nil)).Cleanup(nil); err != nil {
+		t.Errorf("did not expect an error - %v", err)
+	}
+}
+
+func TestApplication_InitFailure(t *testing.T) {
+	cleaned := make([]string, 0)
+	connectFailed := errors.New(errors.Unavailable, "could not connect to the broker")
+	closeFailed := ge.New("could not close connection pool")
+
+	app := service.NewApplication().
+		AddComponent(
+			component{name: "database", err: closeFailed, cleaned: &cleaned},
+			component{name: "cache", cleaned: &cleaned},
+			component{name: "broker", initErr: connectFailed, cleaned: &cleaned},
+			component{name: "server", cleaned: &cleaned},
+		)
+
+	err := app.Init(context.Background(), nil)
+
+	if want := []string{"cache", "database"}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("expected the initialized components to be cleaned up in order %v, got %v", want, cleaned)
+	}
+
+	if !ge.Is(err, connectFailed) || !ge.Is(err, closeFailed) {
+		t.Errorf("expected the init and cleanup failures to be reported, got %v", err)
+	}
+
+	cleaned = cleaned[:0]
+	initFailed := ge.New("could not load reference data")
+
+	err = service.NewApplication().
+		AddComponent(component{name: "database", cleaned: &cleaned}).
+		AddInitFunc(func(context.Context, service.StateStore) error { return initFailed }).
+		Init(context.Background(), nil)
+
+	if want := []string{"database"}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("expected the components to be cleaned up when an init function fails, got %v", cleaned)
+	}
+
+	if err != initFailed {
+		t.Errorf("expected the init failure to be returned, got %v", err)
+	}
+}
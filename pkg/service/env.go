@@ -8,14 +8,41 @@ import (
 )
 
 var bindings = map[string]string{
-	"version":         "VERSION",
-	"service.name":    "SERVICE_NAME",
-	"log.filepath":    "LOG_FILE_PATH",
-	"log.level":       "LOG_LEVEL",
-	"log.max-size":    "LOG_MAX_SIZE",
-	"log.max-backups": "LOG_MAX_BACKUP",
-	"log.max-age":     "LOG_MAX_AGE",
-	"log.compress":    "LOG_COMPRESS",
+	"version":                     "VERSION",
+	"service.name":                "SERVICE_NAME",
+	"log.filepath":                "LOG_FILE_PATH",
+	"log.level":                   "LOG_LEVEL",
+	"log.max-size":                "LOG_MAX_SIZE",
+	"log.max-backups":             "LOG_MAX_BACKUP",
+	"log.max-age":                 "LOG_MAX_AGE",
+	"log.compress":                "LOG_COMPRESS",
+	"log.compression":             "LOG_COMPRESSION",
+	"log.rotation":                "LOG_ROTATION",
+	"log.max-total-size":          "LOG_MAX_TOTAL_SIZE",
+	"audit.filepath":              "AUDIT_FILE_PATH",
+	"audit.key":                   "AUDIT_KEY",
+	"database.driver":             "DATABASE_DRIVER",
+	"database.host":               "DATABASE_HOST",
+	"database.port":               "DATABASE_PORT",
+	"database.user":               "DATABASE_USER",
+	"database.password":           "DATABASE_PASSWORD",
+	"database.name":               "DATABASE_NAME",
+	"database.sslmode":            "DATABASE_SSLMODE",
+	"database.connect-timeout":    "DATABASE_CONNECT_TIMEOUT",
+	"database.application-name":   "DATABASE_APPLICATION_NAME",
+	"database.search-path":        "DATABASE_SEARCH_PATH",
+	"database.statement-timeout":  "DATABASE_STATEMENT_TIMEOUT",
+	"database.sslcert":            "DATABASE_SSLCERT",
+	"database.sslkey":             "DATABASE_SSLKEY",
+	"database.sslrootcert":        "DATABASE_SSLROOTCERT",
+	"database.tls":                "DATABASE_TLS",
+	"database.path":               "DATABASE_PATH",
+	"database.max-open-conns":     "DATABASE_MAX_OPEN_CONNS",
+	"database.max-idle-conns":     "DATABASE_MAX_IDLE_CONNS",
+	"database.conn-max-lifetime":  "DATABASE_CONN_MAX_LIFETIME",
+	"database.conn-max-idle-time": "DATABASE_CONN_MAX_IDLE_TIME",
+	"database.connect-attempts":   "DATABASE_CONNECT_ATTEMPTS",
+	"database.connect-backoff":    "DATABASE_CONNECT_BACKOFF",
 }
 
 // BindEnvVars binds any environment variables that have been defined with the